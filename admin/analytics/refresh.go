@@ -0,0 +1,114 @@
+// Package analytics keeps the dashboard's materialized views (see
+// db.AnalyticsViews) current. Each replica LISTENs on db.AnalyticsChannel
+// and refreshes the views shortly after a change commits; changes that
+// arrive while it waits are folded into the same refresh.
+package analytics
+
+import (
+	"admin/db"
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	// debounce is how long a refresh waits after the change that called
+	// for it, so a burst of writes such as an import is refreshed once.
+	debounce = 2 * time.Second
+	// fallbackInterval bounds how stale the views get without changes, as
+	// board terms lapse and recent changes age by the calendar alone.
+	fallbackInterval = time.Hour
+)
+
+// Run refreshes the views on start, after changes and at least hourly,
+// until ctx is done.
+func Run(ctx context.Context, dsn string, gdb *gorm.DB) {
+	stale := make(chan struct{}, 1)
+	go listen(ctx, dsn, stale)
+
+	fallback := time.NewTicker(fallbackInterval)
+	defer fallback.Stop()
+	var due <-chan time.Time
+	refresh(ctx, gdb)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stale:
+			if due == nil {
+				due = time.After(debounce)
+			}
+		case <-due:
+			due = nil
+			refresh(ctx, gdb)
+		case <-fallback.C:
+			refresh(ctx, gdb)
+		}
+	}
+}
+
+// listen signals stale for every change notification on dsn, and once
+// after each reconnect, as changes may have been missed in between.
+func listen(ctx context.Context, dsn string, stale chan<- struct{}) {
+	signal := func() {
+		select {
+		case stale <- struct{}{}:
+		default:
+		}
+	}
+	for ctx.Err() == nil {
+		err := waitForChanges(ctx, dsn, signal)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("analytics: listener failed, reconnecting:", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+		signal()
+	}
+}
+
+func waitForChanges(ctx context.Context, dsn string, signal func()) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+db.AnalyticsChannel); err != nil {
+		return err
+	}
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		signal()
+	}
+}
+
+// refresh rebuilds every view without blocking readers. Only one replica
+// refreshes at a time; the others skip, as every replica hears of every
+// change and the one refreshing will refresh again for changes that
+// arrived meanwhile.
+func refresh(ctx context.Context, gdb *gorm.DB) {
+	err := gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext('analytics_refresh'))").Scan(&locked).Error; err != nil || !locked {
+			return err
+		}
+		for _, view := range db.AnalyticsViews {
+			if err := tx.Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY " + view).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Println("analytics: cannot refresh views:", err)
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AnalyticsChannel is the NOTIFY channel announcing that a table the
+// analytics views read from has changed. Postgres folds the notifications of
+// one transaction into one, so a write is announced once, on commit.
+const AnalyticsChannel = "analytics_stale"
+
+// AnalyticsViews are the materialized views behind the dashboard, each with
+// a unique index so it can be refreshed without blocking readers.
+var AnalyticsViews = []string{
+	"analytics_manager_load",
+	"analytics_distribution",
+	"analytics_completeness",
+	"analytics_recent_changes",
+	"analytics_daily_changes",
+}
+
+// analyticsSources are the tables the views read from, with the writes to
+// each that can change them. Outbox events are only ever added to as far as
+// the views are concerned.
+var analyticsSources = []struct{ Table, Events string }{
+	{"associations", "INSERT OR UPDATE OR DELETE"},
+	{"managers", "INSERT OR UPDATE OR DELETE"},
+	{"units", "INSERT OR UPDATE OR DELETE"},
+	{"board_members", "INSERT OR UPDATE OR DELETE"},
+	{"outbox_events", "INSERT"},
+}
+
+// RecentChangesDays is how far back analytics_recent_changes reaches.
+const RecentChangesDays = 30
+
+// CompletenessChecks are the association fields scored for completeness,
+// each with the SQL condition under which it counts as missing.
+var CompletenessChecks = []struct{ Field, Missing string }{
+	{"street", "a.street = ''"},
+	{"city", "a.city = ''"},
+	{"county", "a.county = ''"},
+	{"state", "a.state = ''"},
+	{"zip", "a.zip = ''"},
+	{"type", "a.type = ''"},
+	{"unitCount", "a.unit_count = 0"},
+	{"fiscalYearEnd", "a.fiscal_year_end = ''"},
+	{"stateCorpNumber", "a.state_corp_number = ''"},
+	{"ein", "a.ein = ''"},
+	{"incorporationDate", "a.incorporation_date IS NULL"},
+	{"board", `NOT EXISTS (SELECT 1 FROM board_members b WHERE b.association_id = a.id
+		AND b.term_start <= CURRENT_DATE AND (b.term_end IS NULL OR b.term_end >= CURRENT_DATE))`},
+}
+
+// completenessMissing is the SQL array of the fields an association a lacks.
+func completenessMissing() string {
+	cases := make([]string, len(CompletenessChecks))
+	for i, check := range CompletenessChecks {
+		cases[i] = "CASE WHEN " + check.Missing + " THEN '" + check.Field + "' END"
+	}
+	return "array_remove(ARRAY[" + strings.Join(cases, ",\n\t\t\t") + "]::text[], NULL)"
+}
+
+// analyticsDDL (re)creates the views. Every view stamps its rows with the
+// time it was last refreshed.
+func analyticsDDL() []string {
+	ddl := []string{
+		`CREATE OR REPLACE FUNCTION notify_analytics_stale() RETURNS trigger AS $$
+		BEGIN
+			PERFORM pg_notify('` + AnalyticsChannel + `', '');
+			RETURN NULL;
+		END
+		$$ LANGUAGE plpgsql`,
+	}
+	for _, src := range analyticsSources {
+		ddl = append(ddl,
+			`DROP TRIGGER IF EXISTS `+src.Table+`_analytics_stale ON `+src.Table,
+			`CREATE TRIGGER `+src.Table+`_analytics_stale AFTER `+src.Events+` ON `+src.Table+`
+		FOR EACH STATEMENT EXECUTE FUNCTION notify_analytics_stale()`)
+	}
+	for i := len(AnalyticsViews) - 1; i >= 0; i-- {
+		ddl = append(ddl, `DROP MATERIALIZED VIEW IF EXISTS `+AnalyticsViews[i])
+	}
+	return append(ddl,
+		// Associations and units of each manager; declared units are the
+		// associations' unit counts, units the unit records on file.
+		`CREATE MATERIALIZED VIEW analytics_manager_load AS
+		SELECT m.id AS manager_id, m.name,
+			count(a.id) AS associations,
+			coalesce(sum(a.unit_count), 0) AS declared_units,
+			coalesce(sum(u.units), 0) AS units,
+			now() AS refreshed_at
+		FROM managers m
+		LEFT JOIN associations a ON a.manager_id = m.id AND a.deleted_at IS NULL
+		LEFT JOIN (SELECT association_id, count(*) AS units FROM units
+			WHERE deleted_at IS NULL GROUP BY association_id) u ON u.association_id = a.id
+		WHERE m.deleted_at IS NULL
+		GROUP BY m.id, m.name`,
+		`CREATE UNIQUE INDEX analytics_manager_load_key ON analytics_manager_load (manager_id)`,
+
+		// Associations and declared units by location, city, county, state
+		// and type; counties are qualified by state.
+		`CREATE MATERIALIZED VIEW analytics_distribution AS
+		SELECT dimension, value, count(*) AS associations,
+			coalesce(sum(unit_count), 0) AS declared_units,
+			now() AS refreshed_at
+		FROM associations a
+		CROSS JOIN LATERAL (VALUES
+			('location', a.location),
+			('city', a.city),
+			('county', CASE WHEN a.county = '' THEN '' ELSE a.county || ', ' || a.state END),
+			('state', a.state),
+			('type', a.type)
+		) d (dimension, value)
+		WHERE a.deleted_at IS NULL
+		GROUP BY dimension, value`,
+		`CREATE UNIQUE INDEX analytics_distribution_key ON analytics_distribution (dimension, value)`,
+
+		// The profile fields each association lacks, and the share it has.
+		`CREATE MATERIALIZED VIEW analytics_completeness AS
+		SELECT association_id, legal_name, manager_id, missing,
+			round(1 - cardinality(missing)::numeric / `+strconv.Itoa(len(CompletenessChecks))+`, 4) AS score,
+			now() AS refreshed_at
+		FROM (SELECT a.id AS association_id, a.legal_name, a.manager_id,
+			`+completenessMissing()+` AS missing
+			FROM associations a WHERE a.deleted_at IS NULL) c`,
+		`CREATE UNIQUE INDEX analytics_completeness_key ON analytics_completeness (association_id)`,
+
+		// The latest change to each record changed recently, named as it
+		// was written.
+		`CREATE MATERIALIZED VIEW analytics_recent_changes AS
+		SELECT DISTINCT ON (entity_id)
+			split_part(type, '.', 1) AS entity, entity_id, type, actor,
+			coalesce(data->>'LegalName', data->>'Name', data->>'Username', '') AS label,
+			coalesce(data->>'Role', '') AS role,
+			created_at AS changed_at,
+			now() AS refreshed_at
+		FROM outbox_events
+		WHERE created_at >= now() - interval '`+strconv.Itoa(RecentChangesDays)+` days'
+		ORDER BY entity_id, seq DESC`,
+		`CREATE UNIQUE INDEX analytics_recent_changes_key ON analytics_recent_changes (entity_id)`,
+		`CREATE INDEX analytics_recent_changes_changed_at ON analytics_recent_changes (changed_at DESC)`,
+
+		// Changes per day, record kind and kind of change, for comparing
+		// date ranges.
+		`CREATE MATERIALIZED VIEW analytics_daily_changes AS
+		SELECT (created_at AT TIME ZONE 'UTC')::date AS day,
+			split_part(type, '.', 1) AS entity, split_part(type, '.', 2) AS change,
+			count(*) AS events,
+			now() AS refreshed_at
+		FROM outbox_events
+		GROUP BY 1, 2, 3`,
+		`CREATE UNIQUE INDEX analytics_daily_changes_key ON analytics_daily_changes (day, entity, change)`,
+	)
+}
+
+// migrateAnalytics recreates the views in one transaction, so a changed
+// definition takes effect and readers on other replicas wait rather than
+// fail.
+func migrateAnalytics(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range analyticsDDL() {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
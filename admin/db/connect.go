@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -14,16 +16,71 @@ var DB *gorm.DB
 
 func InitDB() {
 	dsn := os.Getenv("DATABASE_URL") // or construct with host/user/pass/port/dbname
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		log.Fatal("Invalid DATABASE_URL:", err)
+	}
+	conn := stdlib.OpenDB(*cfg, stdlib.OptionAfterConnect(configureSession))
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	if err := db.SetupJoinTable(&models.Association{}, "Tags", &models.AssociationTag{}); err != nil {
+		log.Fatal("Failed to set up association tags:", err)
+	}
+
 	// Auto-migrate the User model
-	if err := db.AutoMigrate(&models.User{}, &models.Association{}, &models.Manager{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Association{}, &models.Manager{},
+		&models.Unit{}, &models.Owner{}, &models.Ownership{}, &models.AssociationGrant{},
+		&models.BoardMember{}, &models.ManagerAssignment{},
+		&models.Reassignment{}, &models.ReassignmentMove{}, &models.Merge{}, &models.CustomField{},
+		&models.Tag{}, &models.AssociationTag{}, &models.Segment{},
+		&models.Vendor{}, &models.VendorContract{},
+		&models.Document{}, &models.DocumentVersion{},
+		&models.OutboxEvent{}, &models.Webhook{}, &models.WebhookDelivery{},
+		&models.IdempotencyKey{}); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Email and username uniqueness moved to partial indexes that ignore
+	// soft-deleted rows; drop the original full-table indexes.
+	for _, idx := range []string{"idx_managers_email", "idx_users_username"} {
+		if err := db.Exec("DROP INDEX IF EXISTS " + idx).Error; err != nil {
+			log.Fatal("Failed to drop legacy index:", err)
+		}
+	}
+
+	// Tag names are unique regardless of case.
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_name_lower ON tags (lower(name))").Error; err != nil {
+		log.Fatal("Failed to index tags:", err)
+	}
+
+	// Associations from before assignment history start theirs with the
+	// current manager as primary.
+	if err := db.Exec(`INSERT INTO manager_assignments (association_id, manager_id, role, effective_from, reason, changed_by)
+		SELECT a.id, a.manager_id, 'primary', CURRENT_DATE, 'Recorded when assignment history began', 'system'
+		FROM associations a
+		WHERE NOT EXISTS (SELECT 1 FROM manager_assignments m WHERE m.association_id = a.id AND m.role = 'primary')`).Error; err != nil {
+		log.Fatal("Failed to backfill manager assignments:", err)
+	}
+
+	if err := migrateSearch(db); err != nil {
+		log.Fatal("Failed to set up search:", err)
+	}
+	if err := migrateGeo(db); err != nil {
+		log.Fatal("Failed to set up geographic search:", err)
+	}
+	if err := migrateDedupe(db); err != nil {
+		log.Fatal("Failed to set up duplicate detection:", err)
+	}
+	if err := migrateNotify(db); err != nil {
+		log.Fatal("Failed to set up event notifications:", err)
+	}
+	if err := migrateAnalytics(db); err != nil {
+		log.Fatal("Failed to set up analytics:", err)
+	}
+
 	DB = db
 	fmt.Println("✅ Admin connected to PostgreSQL")
 }
@@ -0,0 +1,40 @@
+package db
+
+import "gorm.io/gorm"
+
+// dedupeDDL adds dedupe_name, the form of an association's or manager's name
+// compared when looking for duplicates, and trigram indexes on it. It drops
+// case, punctuation and the words that vary between spellings of the same
+// name, so "Palm Villas Condo Assoc." and "The Palm Villas Condominium
+// Association, Inc." are both "palm villas". Likewise dedupe_street is the
+// form of a street address compared, indexed so associations join on it:
+// case, punctuation and the street type do not matter, so "12 Palm Ave." is
+// "12 Palm Avenue".
+var dedupeDDL = []string{
+	`CREATE OR REPLACE FUNCTION dedupe_name(name text) RETURNS text AS $$
+		SELECT btrim(regexp_replace(regexp_replace(
+			regexp_replace(lower(name), '[^a-z0-9]+', ' ', 'g'),
+			'\m(the|of|at|and|inc|incorporated|llc|corp|corporation|co|company|condominium|condominiums|condo|condos|association|assoc|assn|homeowners|homeowner|owners|owner|property|hoa|poa|coa|cooperative|coop|op)\M', ' ', 'g'),
+			'\s+', ' ', 'g'))
+	$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_dedupe_name ON associations
+		USING gin (dedupe_name(legal_name) gin_trgm_ops) WHERE deleted_at IS NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_managers_dedupe_name ON managers
+		USING gin (dedupe_name(name) gin_trgm_ops) WHERE deleted_at IS NULL`,
+	`CREATE OR REPLACE FUNCTION dedupe_street(street text) RETURNS text AS $$
+		SELECT regexp_replace(regexp_replace(lower(street),
+			'\m(street|st|avenue|ave|boulevard|blvd|drive|dr|road|rd|lane|ln|court|ct|circle|cir|place|pl|way|terrace|ter|parkway|pkwy|highway|hwy)\M', '', 'g'),
+			'[^a-z0-9]+', '', 'g')
+	$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_dedupe_street ON associations
+		(dedupe_street(street)) WHERE deleted_at IS NULL`,
+}
+
+func migrateDedupe(db *gorm.DB) error {
+	for _, stmt := range dedupeDDL {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package db
+
+import "gorm.io/gorm"
+
+// geoDDL indexes associations by position for radius and bounding-box
+// queries. Distances come from the earthdistance extension, which ships
+// with Postgres like pg_trgm, so PostGIS is not needed.
+var geoDDL = []string{
+	`CREATE EXTENSION IF NOT EXISTS cube`,
+	`CREATE EXTENSION IF NOT EXISTS earthdistance`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_earth ON associations
+		USING gist (ll_to_earth(latitude, longitude))
+		WHERE latitude IS NOT NULL AND longitude IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_lat_lon ON associations (latitude, longitude)`,
+}
+
+func migrateGeo(db *gorm.DB) error {
+	for _, stmt := range geoDDL {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package db
+
+import "gorm.io/gorm"
+
+// EventChannel is the NOTIFY channel announcing each new outbox event; the
+// payload is the event's Seq. Notifications are sent on commit, so every
+// replica listening hears of every event, whichever replica wrote it.
+const EventChannel = "outbox_events"
+
+var notifyDDL = []string{
+	`CREATE OR REPLACE FUNCTION notify_outbox_event() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_notify('` + EventChannel + `', NEW.seq::text);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events`,
+	`CREATE TRIGGER outbox_events_notify AFTER INSERT ON outbox_events
+		FOR EACH ROW EXECUTE FUNCTION notify_outbox_event()`,
+}
+
+func migrateNotify(db *gorm.DB) error {
+	for _, stmt := range notifyDDL {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// FuzzyThreshold is the word similarity (0..1) above which a trigram match
+// counts as a hit. pg_trgm's default of 0.6 misses most one-letter typos in
+// short names.
+const FuzzyThreshold = "0.3"
+
+// searchDDL adds what ranked search needs beyond the models: a weighted
+// tsvector per searchable table, kept current by Postgres as a generated
+// column, and trigram indexes for typo-tolerant and substring matching.
+var searchDDL = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+
+	`ALTER TABLE associations ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(legal_name, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(filter_name, '')), 'B') ||
+			setweight(to_tsvector('simple', coalesce(location, '')), 'C')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_search ON associations USING gin (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_legal_name_trgm ON associations USING gin (legal_name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_filter_name_trgm ON associations USING gin (filter_name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_associations_location_trgm ON associations USING gin (location gin_trgm_ops)`,
+
+	`ALTER TABLE managers ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(email, '')), 'B') ||
+			setweight(to_tsvector('simple', coalesce(titles, '')), 'C')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_managers_search ON managers USING gin (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_managers_name_trgm ON managers USING gin (name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_managers_email_trgm ON managers USING gin (email gin_trgm_ops)`,
+
+	`CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (username gin_trgm_ops)`,
+
+	`CREATE INDEX IF NOT EXISTS idx_units_number_trgm ON units USING gin (number gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_units_street_trgm ON units USING gin (street gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_owners_name_trgm ON owners USING gin (name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_vendors_name_trgm ON vendors USING gin (name gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_vendors_contact_name_trgm ON vendors USING gin (contact_name gin_trgm_ops)`,
+}
+
+func migrateSearch(db *gorm.DB) error {
+	for _, stmt := range searchDDL {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureSession runs on every new pooled connection so the trigram
+// operators use FuzzyThreshold.
+func configureSession(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, "SET pg_trgm.word_similarity_threshold = "+FuzzyThreshold)
+	return err
+}
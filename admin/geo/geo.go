@@ -0,0 +1,272 @@
+// Package geo places free-text locations offline, against a gazetteer of
+// places and ZIP code centroids loaded once at startup with Init.
+//
+// A Florida subset of places and ZIP codes is bundled (places_fl.tsv and
+// zips_fl.tsv). GAZETTEER_FILES
+// adds more: a comma-separated list of tab-separated files in the layout of
+// the Census Bureau's Gazetteer files, e.g. 2023_Gaz_place_national.txt and
+// 2023_Gaz_zcta_national.txt. Files with a NAME column are places (USPS,
+// NAME, INTPTLAT, INTPTLONG and, if present, COUNTY); files without one are
+// ZIP code tabulation areas (GEOID, INTPTLAT, INTPTLONG).
+package geo
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	//go:embed places_fl.tsv
+	bundledPlaces string
+	//go:embed zips_fl.tsv
+	bundledZIPs string
+)
+
+// Precision is how closely a match locates a place.
+const (
+	PrecisionZIP  = "zip"
+	PrecisionCity = "city"
+)
+
+// MetersPerMile converts miles to the meters the database measures in.
+const MetersPerMile = 1609.344
+
+// Place is a gazetteer entry.
+type Place struct {
+	Name, County, State string
+	Lat, Lon            float64
+}
+
+// gazetteer indexes places by key of their name, and ZIP centroids by ZIP.
+type gazetteer struct {
+	places map[string][]Place
+	zips   map[string][2]float64
+}
+
+var current = gazetteer{places: map[string][]Place{}, zips: map[string][2]float64{}}
+
+// Init loads the bundled places and the files named in GAZETTEER_FILES.
+func Init() error {
+	g := gazetteer{places: map[string][]Place{}, zips: map[string][2]float64{}}
+	for _, bundled := range []string{bundledPlaces, bundledZIPs} {
+		if err := g.read(strings.NewReader(bundled)); err != nil {
+			return fmt.Errorf("geo: bundled gazetteer: %w", err)
+		}
+	}
+	for _, path := range strings.Split(os.Getenv("GAZETTEER_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("geo: %w", err)
+		}
+		err = g.read(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("geo: %s: %w", path, err)
+		}
+	}
+	current = g
+	return nil
+}
+
+// lsadSuffixes are the legal/statistical area descriptions the Census
+// appends to place names, e.g. "Tampa city".
+var lsadSuffixes = []string{" city and borough", " city", " town", " village", " borough", " CDP", " municipality"}
+
+func (g *gazetteer) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !sc.Scan() {
+		return sc.Err()
+	}
+	col := map[string]int{}
+	for i, name := range strings.Split(sc.Text(), "\t") {
+		col[strings.TrimSpace(name)] = i
+	}
+	get := func(fields []string, name string) string {
+		if i, ok := col[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	_, isPlaces := col["NAME"]
+	for line := 2; sc.Scan(); line++ {
+		fields := strings.Split(sc.Text(), "\t")
+		lat, err1 := strconv.ParseFloat(get(fields, "INTPTLAT"), 64)
+		lon, err2 := strconv.ParseFloat(get(fields, "INTPTLONG"), 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("line %d: bad INTPTLAT or INTPTLONG", line)
+		}
+		if !isPlaces {
+			g.zips[get(fields, "GEOID")] = [2]float64{lat, lon}
+			continue
+		}
+		name := get(fields, "NAME")
+		// Consolidated cities, e.g. "Nashville-Davidson metropolitan government (balance)".
+		if i := strings.Index(name, " (balance)"); i >= 0 {
+			name = name[:i]
+			for _, s := range []string{" metropolitan government", " consolidated government", " unified government"} {
+				name = strings.TrimSuffix(name, s)
+			}
+		}
+		for _, s := range lsadSuffixes {
+			if strings.HasSuffix(name, s) {
+				name = strings.TrimSuffix(name, s)
+				break
+			}
+		}
+		p := Place{Name: name, County: get(fields, "COUNTY"), State: get(fields, "USPS"), Lat: lat, Lon: lon}
+		g.places[key(name)] = append(g.places[key(name)], p)
+	}
+	return sc.Err()
+}
+
+// abbreviations are the words written short in place names.
+var abbreviations = map[string]string{"st": "saint", "ste": "sainte", "ft": "fort", "mt": "mount", "pt": "port"}
+
+// key is how place names are matched: case, periods, hyphens and common
+// abbreviations do not matter, so "St. Petersburg" is "saint petersburg".
+func key(name string) string {
+	name = strings.NewReplacer(".", "", "-", " ").Replace(strings.ToLower(name))
+	words := strings.Fields(name)
+	for i, w := range words {
+		if long, ok := abbreviations[w]; ok {
+			words[i] = long
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Location is an address broken into the parts the gazetteer knows.
+type Location struct {
+	City, County, State, ZIP string
+}
+
+// Match is a located Location. City, County and State come from a matched
+// place in the gazetteer; ZIP is the one given, with or without a known
+// centroid.
+type Match struct {
+	Location
+	Lat, Lon  float64
+	Precision string
+}
+
+// Resolve places loc: at its ZIP's centroid when known, otherwise at the
+// place named by City, within State if given. Without a state the city must
+// name one place only. It reports false when neither is found.
+func Resolve(loc Location) (Match, bool) {
+	m := Match{Location: Location{ZIP: loc.ZIP}}
+	if c, ok := current.zips[loc.ZIP]; ok {
+		m.Lat, m.Lon, m.Precision = c[0], c[1], PrecisionZIP
+	}
+	if p, ok := current.place(loc.City, loc.State); ok {
+		m.City, m.County, m.State = p.Name, p.County, p.State
+		if m.Precision == "" {
+			m.Lat, m.Lon, m.Precision = p.Lat, p.Lon, PrecisionCity
+		}
+	}
+	return m, m.Precision != ""
+}
+
+func (g gazetteer) place(city, state string) (Place, bool) {
+	if city == "" {
+		return Place{}, false
+	}
+	var found []Place
+	for _, p := range g.places[key(city)] {
+		if state == "" || strings.EqualFold(p.State, state) {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 || (state == "" && len(found) > 1) {
+		return Place{}, false
+	}
+	return found[0], true
+}
+
+var zipRe = regexp.MustCompile(`\b([0-9]{5})(?:-[0-9]{4})?\b`)
+
+// Parse breaks free text such as "Tampa", "Tampa, FL 33602", "St. Petersburg,
+// Florida" or "Brandon, Hillsborough County, FL" into its parts. Only a
+// valid state code or name is taken as the state.
+func Parse(text string) Location {
+	var loc Location
+	if m := zipRe.FindStringSubmatchIndex(text); m != nil {
+		loc.ZIP = text[m[2]:m[3]]
+		text = text[:m[0]] + text[m[1]:]
+	}
+	var parts []string
+	for _, p := range strings.Split(text, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if n := len(parts); n > 0 {
+		last := parts[n-1]
+		if code, ok := stateCode(last); ok && n > 1 {
+			loc.State, parts = code, parts[:n-1]
+		} else if i := strings.LastIndex(last, " "); i > 0 {
+			// "Tampa FL", or "Tampa Florida": a code only in capitals, so
+			// "Palm Bay" keeps its last word.
+			for j := i; j > 0; j = strings.LastIndex(last[:j], " ") {
+				word := last[j+1:]
+				if code, ok := stateCode(word); ok && (len(word) > 2 || word == strings.ToUpper(word)) {
+					loc.State, parts[n-1] = code, strings.TrimSpace(last[:j])
+					break
+				}
+			}
+		}
+	}
+	for _, p := range parts {
+		lower := strings.ToLower(p)
+		switch {
+		case strings.HasSuffix(lower, " county") && loc.County == "":
+			loc.County = strings.TrimSpace(p[:len(p)-len(" county")])
+		case loc.City == "":
+			loc.City = p
+		}
+	}
+	return loc
+}
+
+// stateCode returns the USPS code for a state's code or name.
+func stateCode(s string) (string, bool) {
+	if code := strings.ToUpper(s); len(code) == 2 {
+		for _, name := range stateNames {
+			if name[0] == code {
+				return code, true
+			}
+		}
+		return "", false
+	}
+	for _, name := range stateNames {
+		if strings.EqualFold(name[1], s) {
+			return name[0], true
+		}
+	}
+	return "", false
+}
+
+// stateNames pairs each USPS code with the state's name.
+var stateNames = [][2]string{
+	{"AL", "Alabama"}, {"AK", "Alaska"}, {"AZ", "Arizona"}, {"AR", "Arkansas"}, {"CA", "California"},
+	{"CO", "Colorado"}, {"CT", "Connecticut"}, {"DE", "Delaware"}, {"FL", "Florida"}, {"GA", "Georgia"},
+	{"HI", "Hawaii"}, {"ID", "Idaho"}, {"IL", "Illinois"}, {"IN", "Indiana"}, {"IA", "Iowa"},
+	{"KS", "Kansas"}, {"KY", "Kentucky"}, {"LA", "Louisiana"}, {"ME", "Maine"}, {"MD", "Maryland"},
+	{"MA", "Massachusetts"}, {"MI", "Michigan"}, {"MN", "Minnesota"}, {"MS", "Mississippi"}, {"MO", "Missouri"},
+	{"MT", "Montana"}, {"NE", "Nebraska"}, {"NV", "Nevada"}, {"NH", "New Hampshire"}, {"NJ", "New Jersey"},
+	{"NM", "New Mexico"}, {"NY", "New York"}, {"NC", "North Carolina"}, {"ND", "North Dakota"}, {"OH", "Ohio"},
+	{"OK", "Oklahoma"}, {"OR", "Oregon"}, {"PA", "Pennsylvania"}, {"RI", "Rhode Island"}, {"SC", "South Carolina"},
+	{"SD", "South Dakota"}, {"TN", "Tennessee"}, {"TX", "Texas"}, {"UT", "Utah"}, {"VT", "Vermont"},
+	{"VA", "Virginia"}, {"WA", "Washington"}, {"WV", "West Virginia"}, {"WI", "Wisconsin"}, {"WY", "Wyoming"},
+	{"DC", "District of Columbia"}, {"PR", "Puerto Rico"}, {"GU", "Guam"}, {"VI", "U.S. Virgin Islands"},
+	{"AS", "American Samoa"}, {"MP", "Northern Mariana Islands"},
+}
@@ -0,0 +1,524 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// The dashboard reads the materialized views in db.AnalyticsViews, which
+// package analytics refreshes shortly after every change. Each response
+// carries the refreshedAt of the data it was computed from; it is null when
+// the view is empty.
+
+// refreshedAt is when view was last refreshed, or nil if it is empty.
+func refreshedAt(view string) (*time.Time, error) {
+	var at *time.Time
+	err := db.DB.Table(view).Select("max(refreshed_at)").Row().Scan(&at)
+	return at, err
+}
+
+// managerLoad is a row of analytics_manager_load.
+type managerLoad struct {
+	ManagerID     string `json:"managerId"`
+	Name          string `json:"name"`
+	Associations  int    `json:"associations"`
+	Units         int    `json:"units"`
+	DeclaredUnits int    `json:"declaredUnits"`
+}
+
+// managerLoadOrder maps the sort options of the per-manager report to SQL.
+var managerLoadOrder = map[string]string{
+	"associations":  "associations desc, name",
+	"units":         "units desc, name",
+	"declaredUnits": "declared_units desc, name",
+	"name":          "name",
+}
+
+func loadManagerLoad(order string) ([]managerLoad, error) {
+	list := []managerLoad{}
+	err := db.DB.Table("analytics_manager_load").
+		Select("manager_id, name, associations, units, declared_units").
+		Order(order).Scan(&list).Error
+	return list, err
+}
+
+// GET /api/admin/analytics/managers?sort=associations|units|declaredUnits|name
+// Associations and units per manager. Units are the unit records on file;
+// declared units the sum of the associations' unit counts.
+func AnalyticsManagers(c *fiber.Ctx) error {
+	sort := c.Query("sort", "associations")
+	order, ok := managerLoadOrder[sort]
+	if !ok {
+		return problem.New(http.StatusBadRequest, "sort must be associations, units, declaredUnits or name")
+	}
+	at, err := refreshedAt("analytics_manager_load")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	list, err := loadManagerLoad(order)
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	return c.JSON(fiber.Map{"refreshedAt": at, "managers": list})
+}
+
+// distributionDimensions are what associations can be grouped by.
+var distributionDimensions = []string{"location", "city", "county", "state", "type"}
+
+type distributionBucket struct {
+	// Value is empty for associations where it is unknown.
+	Value         string  `json:"value"`
+	Associations  int     `json:"associations"`
+	DeclaredUnits int     `json:"declaredUnits"`
+	Share         float64 `json:"share"`
+}
+
+// GET /api/admin/analytics/distribution?by=location|city|county|state|type
+// Associations and declared units by the given dimension, largest first,
+// with each bucket's share of all associations. Counties read "County, ST".
+func AnalyticsDistribution(c *fiber.Ctx) error {
+	by := c.Query("by", "location")
+	if !slices.Contains(distributionDimensions, by) {
+		return problem.New(http.StatusBadRequest, "by must be one of "+strings.Join(distributionDimensions, ", "))
+	}
+	at, err := refreshedAt("analytics_distribution")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	buckets := []distributionBucket{}
+	err = db.DB.Table("analytics_distribution").
+		Select("value, associations, declared_units").
+		Where("dimension = ?", by).
+		Order("associations desc, value").
+		Scan(&buckets).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	var total, units int
+	for _, b := range buckets {
+		total += b.Associations
+		units += b.DeclaredUnits
+	}
+	for i := range buckets {
+		buckets[i].Share = ratio(buckets[i].Associations, total)
+	}
+	return c.JSON(fiber.Map{
+		"refreshedAt": at,
+		"by":          by,
+		"total":       fiber.Map{"associations": total, "declaredUnits": units},
+		"buckets":     buckets,
+	})
+}
+
+// ratio is n/total rounded to four places, 0 when total is.
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)/float64(total)*1e4) / 1e4
+}
+
+// spread summarizes how a measure is shared among managers.
+type spread struct {
+	Total  int     `json:"total"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	// CoefficientOfVariation is StdDev/Mean: 0 is perfectly even.
+	CoefficientOfVariation float64 `json:"coefficientOfVariation"`
+}
+
+func spreadOf(values []int) spread {
+	if len(values) == 0 {
+		return spread{}
+	}
+	s := spread{Min: values[0], Max: values[0]}
+	for _, v := range values {
+		s.Total += v
+		s.Min, s.Max = min(s.Min, v), max(s.Max, v)
+	}
+	s.Mean = float64(s.Total) / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (float64(v) - s.Mean) * (float64(v) - s.Mean)
+	}
+	s.StdDev = math.Sqrt(sq / float64(len(values)))
+	if s.Mean > 0 {
+		s.CoefficientOfVariation = s.StdDev / s.Mean
+	}
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	s.Mean, s.StdDev, s.CoefficientOfVariation = round(s.Mean), round(s.StdDev), round(s.CoefficientOfVariation)
+	return s
+}
+
+type managerBalance struct {
+	managerLoad
+	AssociationShare float64 `json:"associationShare"`
+	UnitShare        float64 `json:"unitShare"`
+	// Load is over or under when the manager's declared units are more
+	// than one standard deviation from the mean, balanced otherwise.
+	Load string `json:"load"`
+}
+
+// GET /api/admin/analytics/workload
+// How evenly associations and declared units are spread over managers:
+// totals, mean, standard deviation and range of each, and per manager the
+// share they carry and whether they are over or under loaded.
+func AnalyticsWorkload(c *fiber.Ctx) error {
+	at, err := refreshedAt("analytics_manager_load")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	list, err := loadManagerLoad(managerLoadOrder["declaredUnits"])
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	assocs, units := make([]int, len(list)), make([]int, len(list))
+	for i, m := range list {
+		assocs[i], units[i] = m.Associations, m.DeclaredUnits
+	}
+	assocSpread, unitSpread := spreadOf(assocs), spreadOf(units)
+	perManager := make([]managerBalance, len(list))
+	for i, m := range list {
+		load := "balanced"
+		switch d := float64(m.DeclaredUnits) - unitSpread.Mean; {
+		case unitSpread.StdDev > 0 && d > unitSpread.StdDev:
+			load = "over"
+		case unitSpread.StdDev > 0 && d < -unitSpread.StdDev:
+			load = "under"
+		}
+		perManager[i] = managerBalance{
+			managerLoad:      m,
+			AssociationShare: ratio(m.Associations, assocSpread.Total),
+			UnitShare:        ratio(m.DeclaredUnits, unitSpread.Total),
+			Load:             load,
+		}
+	}
+	return c.JSON(fiber.Map{
+		"refreshedAt":   at,
+		"managers":      len(list),
+		"associations":  assocSpread,
+		"declaredUnits": unitSpread,
+		"perManager":    perManager,
+	})
+}
+
+type recentChange struct {
+	Entity string `json:"entity"`
+	ID     string `json:"id" gorm:"column:entity_id"`
+	// Type is the event type of the change, e.g. "manager.updated".
+	Type      string    `json:"type"`
+	Label     string    `json:"label"`
+	Actor     string    `json:"actor"`
+	ChangedAt time.Time `json:"changedAt"`
+	Role      string    `json:"-"`
+}
+
+// GET /api/admin/analytics/recent?entity=association|manager|user&days=7&limit=50
+// Records changed in the last days (at most 30), most recent first, each
+// with its latest change. Admins only see associations in their scope and
+// users other than super users.
+func AnalyticsRecent(c *fiber.Ctx) error {
+	entity := strings.TrimSpace(c.Query("entity"))
+	days := c.QueryInt("days", 7)
+	limit := c.QueryInt("limit", 50)
+	var v validate.Validator
+	if entity != "" {
+		v.OneOf("entity", entity, "association", "manager", "user")
+	}
+	v.Check(days >= 1 && days <= db.RecentChangesDays, "days", "must be between 1 and 30")
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	_, role := middleware.CurrentUser(c)
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	at, err := refreshedAt("analytics_recent_changes")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+
+	tx := db.DB.Table("analytics_recent_changes").
+		Select("entity, entity_id, type, label, actor, changed_at, role").
+		Where("changed_at >= ?", time.Now().AddDate(0, 0, -days))
+	if entity != "" {
+		tx = tx.Where("entity = ?", entity)
+	}
+	var rows []recentChange
+	if err := tx.Order("changed_at desc").Scan(&rows).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	changes := []recentChange{}
+	for _, r := range rows {
+		if len(changes) == limit {
+			break
+		}
+		if changeVisible(r.Entity, r.ID, r.Role, role, scope) {
+			changes = append(changes, r)
+		}
+	}
+	return c.JSON(fiber.Map{"refreshedAt": at, "changes": changes})
+}
+
+type fieldCompleteness struct {
+	Field   string  `json:"field"`
+	Missing int     `json:"missing"`
+	Rate    float64 `json:"rate"`
+}
+
+type managerCompleteness struct {
+	ManagerID    string  `json:"managerId"`
+	Name         string  `json:"name"`
+	Associations int     `json:"associations"`
+	Score        float64 `json:"score"`
+}
+
+type associationCompleteness struct {
+	AssociationID string            `json:"associationId"`
+	LegalName     string            `json:"legalName"`
+	ManagerID     string            `json:"managerId"`
+	Score         float64           `json:"score"`
+	Missing       models.StringList `json:"missing"`
+}
+
+// GET /api/admin/analytics/completeness?managerId=uuid&limit=20
+// How complete association profiles are: each association scores the share
+// of the fields in db.CompletenessChecks it has filled in, the board
+// counting as filled while it has a current member. Returns the average
+// score, the fill rate of each field, the average per manager and the
+// least complete associations, optionally for one manager.
+func AnalyticsCompleteness(c *fiber.Ctx) error {
+	managerID := strings.TrimSpace(c.Query("managerId"))
+	limit := c.QueryInt("limit", 20)
+	var v validate.Validator
+	if managerID != "" {
+		v.UUID("managerId", managerID)
+	}
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	at, err := refreshedAt("analytics_completeness")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	scoped := func() *gorm.DB {
+		tx := db.DB.Table("analytics_completeness c")
+		if managerID != "" {
+			tx = tx.Where("c.manager_id = ?", managerID)
+		}
+		return tx
+	}
+
+	var overall struct {
+		Associations int
+		Score        float64
+	}
+	if err := scoped().Select("count(*) AS associations, coalesce(round(avg(score), 4), 0) AS score").Scan(&overall).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	var missing []struct {
+		Field string
+		Count int
+	}
+	err = scoped().Select("m.field, count(*) AS count").
+		Joins("CROSS JOIN LATERAL unnest(c.missing) AS m (field)").
+		Group("m.field").Scan(&missing).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	fields := make([]fieldCompleteness, len(db.CompletenessChecks))
+	for i, check := range db.CompletenessChecks {
+		fields[i].Field = check.Field
+		for _, m := range missing {
+			if m.Field == check.Field {
+				fields[i].Missing = m.Count
+			}
+		}
+		fields[i].Rate = 1
+		if overall.Associations > 0 {
+			fields[i].Rate = 1 - ratio(fields[i].Missing, overall.Associations)
+		}
+	}
+	managers := []managerCompleteness{}
+	err = scoped().Select("c.manager_id, coalesce(l.name, '') AS name, count(*) AS associations, round(avg(c.score), 4) AS score").
+		Joins("LEFT JOIN analytics_manager_load l ON l.manager_id = c.manager_id").
+		Group("c.manager_id, l.name").Order("score, name").Scan(&managers).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	least := []associationCompleteness{}
+	err = scoped().Select("c.association_id, c.legal_name, c.manager_id, c.score, to_jsonb(c.missing) AS missing").
+		Where("c.score < 1").Order("c.score, c.legal_name").Limit(limit).Scan(&least).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	return c.JSON(fiber.Map{
+		"refreshedAt":   at,
+		"associations":  overall.Associations,
+		"score":         overall.Score,
+		"fields":        fields,
+		"managers":      managers,
+		"leastComplete": least,
+	})
+}
+
+// period is an inclusive range of days.
+type period struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type changeComparison struct {
+	Entity        string `json:"entity"`
+	Change        string `json:"change"`
+	Events        int    `json:"events"`
+	CompareEvents int    `json:"compareEvents"`
+	Delta         int    `json:"delta"`
+	// PercentChange is null when the comparison period had no events.
+	PercentChange *float64 `json:"percentChange"`
+}
+
+// analyticsPeriods parses the from/to and compareFrom/compareTo queries.
+// The period defaults to the 30 days to today, and the comparison period to
+// the days of the same length right before it.
+func analyticsPeriods(c *fiber.Ctx) (period, period, error) {
+	q := func(name string) string { return strings.TrimSpace(c.Query(name)) }
+	var v validate.Validator
+	for _, name := range []string{"from", "to", "compareFrom", "compareTo"} {
+		if q(name) != "" {
+			v.Date(name, q(name))
+		}
+	}
+	v.Check((q("compareFrom") == "") == (q("compareTo") == ""), "compareFrom", "must be given together with compareTo")
+	if err := v.Err(); err != nil {
+		return period{}, period{}, err
+	}
+	day := func(s string, def time.Time) time.Time {
+		if s == "" {
+			return def
+		}
+		t, _ := time.Parse(time.DateOnly, s)
+		return t
+	}
+	to := day(q("to"), today())
+	from := day(q("from"), to.AddDate(0, 0, -29))
+	days := int(to.Sub(from).Hours()/24) + 1
+	compareTo := day(q("compareTo"), from.AddDate(0, 0, -1))
+	compareFrom := day(q("compareFrom"), compareTo.AddDate(0, 0, 1-days))
+	v.Check(!from.After(to), "from", "must not be after to")
+	v.Check(days <= 366, "to", "must be at most 366 days after from")
+	v.Check(!compareFrom.After(compareTo), "compareFrom", "must not be after compareTo")
+	v.Check(compareTo.Sub(compareFrom).Hours()/24 < 366, "compareTo", "must be at most 366 days after compareFrom")
+	if err := v.Err(); err != nil {
+		return period{}, period{}, err
+	}
+	return period{from.Format(time.DateOnly), to.Format(time.DateOnly)},
+		period{compareFrom.Format(time.DateOnly), compareTo.Format(time.DateOnly)}, nil
+}
+
+// GET /api/admin/analytics/changes?from=YYYY-MM-DD&to=YYYY-MM-DD&compareFrom=YYYY-MM-DD&compareTo=YYYY-MM-DD&entity=association
+// Change activity in a period against a comparison period: per record kind
+// and kind of change (created, updated, deleted, ...) the events in each,
+// the difference and the percentage change, plus the events per day in the
+// period. Days are UTC. By default the last 30 days are compared with the
+// 30 before them.
+func AnalyticsChanges(c *fiber.Ctx) error {
+	entity := strings.TrimSpace(c.Query("entity"))
+	cur, cmp, err := analyticsPeriods(c)
+	if err != nil {
+		return validationError(err)
+	}
+	if entity != "" {
+		var v validate.Validator
+		v.OneOf("entity", entity, "association", "manager", "user")
+		if err := v.Err(); err != nil {
+			return validationError(err)
+		}
+	}
+	at, err := refreshedAt("analytics_daily_changes")
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	daily := func(p period) *gorm.DB {
+		tx := db.DB.Table("analytics_daily_changes").Where("day BETWEEN ? AND ?", p.From, p.To)
+		if entity != "" {
+			tx = tx.Where("entity = ?", entity)
+		}
+		return tx
+	}
+
+	type total struct {
+		Entity, Change string
+		Events         int
+	}
+	var curTotals, cmpTotals []total
+	for _, q := range []struct {
+		p    period
+		dest *[]total
+	}{{cur, &curTotals}, {cmp, &cmpTotals}} {
+		if err := daily(q.p).Select("entity, change, sum(events) AS events").Group("entity, change").Scan(q.dest).Error; err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+		}
+	}
+	byKind := map[[2]string]*changeComparison{}
+	changes := []*changeComparison{}
+	row := func(t total) *changeComparison {
+		k := [2]string{t.Entity, t.Change}
+		if byKind[k] == nil {
+			byKind[k] = &changeComparison{Entity: t.Entity, Change: t.Change}
+			changes = append(changes, byKind[k])
+		}
+		return byKind[k]
+	}
+	for _, t := range curTotals {
+		row(t).Events = t.Events
+	}
+	for _, t := range cmpTotals {
+		row(t).CompareEvents = t.Events
+	}
+	for _, r := range changes {
+		r.Delta = r.Events - r.CompareEvents
+		if r.CompareEvents > 0 {
+			pct := math.Round(float64(r.Delta)/float64(r.CompareEvents)*1e4) / 100
+			r.PercentChange = &pct
+		}
+	}
+	slices.SortFunc(changes, func(a, b *changeComparison) int {
+		return strings.Compare(a.Entity+"."+a.Change, b.Entity+"."+b.Change)
+	})
+
+	perDay := []struct {
+		Day    string `json:"day"`
+		Entity string `json:"entity"`
+		Change string `json:"change"`
+		Events int    `json:"events"`
+	}{}
+	err = daily(cur).Select("to_char(day, 'YYYY-MM-DD') AS day, entity, change, events").
+		Order("day, entity, change").Scan(&perDay).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load analytics")
+	}
+	return c.JSON(fiber.Map{
+		"refreshedAt":   at,
+		"period":        cur,
+		"comparePeriod": cmp,
+		"changes":       changes,
+		"daily":         perDay,
+	})
+}
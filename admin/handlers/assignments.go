@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// assignmentRoles in listing order.
+var assignmentRoles = []string{"primary", "assistant", "accountant"}
+
+// byRole orders assignments by role, then manager.
+const byRole = "array_position(ARRAY['primary','assistant','accountant']::text[], role), manager_id"
+
+// openPrimary is the condition for an association's current primary assignment.
+const openPrimary = "association_id = ? AND role = 'primary' AND effective_to IS NULL"
+
+// replacePrimary makes a.ManagerID the primary manager of a.AssociationID
+// from a.EffectiveFrom: the current primary assignment, if any, ends that
+// day for a.Reason and a is created. Nothing is written when the manager is
+// already primary. The caller keeps Association.ManagerID in step.
+func replacePrimary(tx *gorm.DB, a *models.ManagerAssignment) error {
+	a.Role = "primary"
+	var cur models.ManagerAssignment
+	err := tx.Where(openPrimary, a.AssociationID).First(&cur).Error
+	switch {
+	case err == nil:
+		if cur.ManagerID == a.ManagerID {
+			return nil
+		}
+		err = tx.Model(&cur).Updates(map[string]any{
+			"effective_to": a.EffectiveFrom, "end_reason": a.Reason, "version": nextVersion,
+		}).Error
+		if err != nil {
+			return err
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	return tx.Omit("Association", "Manager").Create(a).Error
+}
+
+// assignPrimary records managerID as primary for associationID from today;
+// see replacePrimary.
+func assignPrimary(tx *gorm.DB, associationID, managerID, reason, who string) error {
+	return replacePrimary(tx, &models.ManagerAssignment{
+		AssociationID: associationID, ManagerID: managerID, EffectiveFrom: today(), Reason: reason, ChangedBy: who,
+	})
+}
+
+// GET /api/admin/data/associations/:id/managers?on=2024-03-01
+// Who managed the association on a date (default today): every assignment
+// in effect that day, primary first, each with its manager.
+func ListAssignments(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var v validate.Validator
+	v.UUID("id", id)
+	on := today()
+	if d := strings.TrimSpace(c.Query("on")); d != "" {
+		v.Date("on", d)
+		on, _ = time.Parse(time.DateOnly, d)
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	var list []models.ManagerAssignment
+	err := db.DB.Preload("Manager", unscoped).
+		Where("association_id = ?", id).
+		Where("effective_from <= ? AND (effective_to IS NULL OR effective_to > ?)", on, on).
+		Order(byRole).
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load assignments")
+	}
+	return c.JSON(list)
+}
+
+// GET /api/admin/data/associations/:id/managers/history
+// Every assignment, most recent first.
+func ListAssignmentHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var list []models.ManagerAssignment
+	err := db.DB.Preload("Manager", unscoped).
+		Where("association_id = ?", id).
+		Order("effective_from desc").Order(byRole).
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load assignment history")
+	}
+	return c.JSON(list)
+}
+
+// overlapping reports whether the manager of a already holds a's role on
+// a's association at some point of the period from..to (nil: open-ended).
+func overlapping(a *models.ManagerAssignment, from time.Time, to *time.Time) (bool, error) {
+	tx := db.DB.Model(&models.ManagerAssignment{}).
+		Where("association_id = ? AND manager_id = ? AND role = ?", a.AssociationID, a.ManagerID, a.Role).
+		Where("(effective_to IS NULL OR effective_to > ?)", from)
+	if to != nil {
+		tx = tx.Where("effective_from < ?", *to)
+	}
+	if a.ID != "" {
+		tx = tx.Where("id <> ?", a.ID)
+	}
+	var n int64
+	err := tx.Count(&n).Error
+	return n > 0, err
+}
+
+// POST /api/admin/data/associations/:id/managers
+// Body: { "managerId": "uuid", "role": "assistant", "effectiveFrom": "2025-01-01", "reason": "..." }
+// effectiveFrom defaults to today. A new primary replaces the current one
+// from that day, which may not be in the future, and becomes the
+// association's managerId.
+func CreateAssignment(c *fiber.Ctx) error {
+	associationID := c.Params("id")
+	var in struct {
+		ManagerID     string `json:"managerId"`
+		Role          string `json:"role"`
+		EffectiveFrom string `json:"effectiveFrom"`
+		Reason        string `json:"reason"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.ManagerID = strings.TrimSpace(in.ManagerID)
+	in.Role = strings.ToLower(strings.TrimSpace(in.Role))
+	in.EffectiveFrom = strings.TrimSpace(in.EffectiveFrom)
+	in.Reason = strings.TrimSpace(in.Reason)
+
+	var v validate.Validator
+	v.UUID("id", associationID)
+	validateAssociation(&v, db.DB, nil, nil, nil, &in.ManagerID)
+	v.Required("role", in.Role)
+	v.OneOf("role", in.Role, assignmentRoles...)
+	from := today()
+	if in.EffectiveFrom != "" {
+		v.Date("effectiveFrom", in.EffectiveFrom)
+		from, _ = time.Parse(time.DateOnly, in.EffectiveFrom)
+	}
+	v.Required("reason", in.Reason)
+	v.MaxLen("reason", in.Reason, 500)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var assoc models.Association
+	if err := db.DB.First(&assoc, "id = ?", associationID).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Association not found")
+	}
+	who, _ := middleware.CurrentUser(c)
+	a := models.ManagerAssignment{
+		AssociationID: assoc.ID, ManagerID: in.ManagerID, Role: in.Role,
+		EffectiveFrom: from, Reason: in.Reason, ChangedBy: who,
+	}
+
+	if a.Role == "primary" {
+		var cur models.ManagerAssignment
+		if err := db.DB.Where(openPrimary, assoc.ID).First(&cur).Error; err == nil {
+			v.Check(cur.ManagerID != a.ManagerID, "managerId", "is already the primary manager")
+			v.Check(!from.Before(cur.EffectiveFrom), "effectiveFrom",
+				"must not be before the current primary assignment began ("+cur.EffectiveFrom.Format(time.DateOnly)+")")
+		}
+		v.Check(!from.After(today()), "effectiveFrom", "must not be in the future for a primary assignment")
+		if err := v.Err(); err != nil {
+			return validationError(err)
+		}
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := replacePrimary(tx, &a); err != nil {
+				return err
+			}
+			err := tx.Model(&models.Association{}).Where("id = ?", assoc.ID).
+				Updates(map[string]any{"manager_id": a.ManagerID, "version": nextVersion}).Error
+			if err != nil {
+				return err
+			}
+			return emit(tx, "association.updated", assoc.ID, who)
+		})
+		if err != nil {
+			return dbError(err, "Assignment failed")
+		}
+		return c.Status(http.StatusCreated).JSON(a)
+	}
+
+	overlap, err := overlapping(&a, from, nil)
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Assignment failed")
+	}
+	if overlap {
+		return validationError(validate.Errors{{Field: "managerId", Message: "already holds this role during that period"}})
+	}
+	if err := db.DB.Omit("Association", "Manager").Create(&a).Error; err != nil {
+		return dbError(err, "Assignment failed")
+	}
+	return c.Status(http.StatusCreated).JSON(a)
+}
+
+// PUT /api/admin/data/assignments/:id
+// Header: If-Match: "<version>"
+// Body (any subset): { "effectiveTo": "2025-06-30", "endReason": "...", "reason": "..." }
+// effectiveTo is the first day the assignment no longer applies; empty
+// reopens it. A primary assignment ends only when another replaces it.
+func UpdateAssignment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in struct {
+		EffectiveTo *string `json:"effectiveTo"`
+		Reason      *string `json:"reason"`
+		EndReason   *string `json:"endReason"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	trimAll(in.EffectiveTo, in.Reason, in.EndReason)
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var a models.ManagerAssignment
+	if err := db.DB.First(&a, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Assignment not found")
+	}
+
+	var v validate.Validator
+	updates := map[string]any{}
+	if in.Reason != nil {
+		v.Required("reason", *in.Reason)
+		v.MaxLen("reason", *in.Reason, 500)
+		updates["reason"] = *in.Reason
+	}
+	if in.EndReason != nil {
+		v.MaxLen("endReason", *in.EndReason, 500)
+		updates["end_reason"] = *in.EndReason
+	}
+	if in.EffectiveTo != nil {
+		var to *time.Time
+		switch {
+		case a.Role == "primary":
+			v.Add("effectiveTo", "a primary assignment ends when a new primary is assigned")
+		case *in.EffectiveTo != "":
+			v.Date("effectiveTo", *in.EffectiveTo)
+			if !v.Has("effectiveTo") {
+				d, _ := time.Parse(time.DateOnly, *in.EffectiveTo)
+				v.Check(d.After(a.EffectiveFrom), "effectiveTo", "must be after effectiveFrom")
+				to = &d
+			}
+		}
+		if v.Err() == nil {
+			overlap, err := overlapping(&a, a.EffectiveFrom, to)
+			if err != nil {
+				return problem.New(http.StatusInternalServerError, "Update failed")
+			}
+			v.Check(!overlap, "effectiveTo", "the manager holds this role again during that period")
+		}
+		updates["effective_to"] = to
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB.Preload("Manager", unscoped), &models.ManagerAssignment{}, id, version, updates)
+}
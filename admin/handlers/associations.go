@@ -2,53 +2,258 @@ package handlers
 
 import (
 	"admin/db"
+	"admin/middleware"
 	"admin/models"
+	"admin/problem"
+	"admin/secret"
+	"admin/validate"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// GET /api/admin/data/associations?q=alpha
-func ListAssociations(c *fiber.Ctx) error {
+// associationQuery applies the list filters (q, includeDeleted, the exact
+// profile filters state, county and type, tags with tagMode=all|any, bbox,
+// and custom fields; see customFilter) shared by ListAssociations and
+// ExportAssociations. With q,
+// only matching rows are returned, most relevant first (see
+// associationSearch).
+func associationQuery(c *fiber.Ctx) (*gorm.DB, error) {
 	q := strings.TrimSpace(c.Query("q"))
-	var list []models.Association
 
-	tx := db.DB.Preload("Manager")
+	tx := db.DB.Preload("Manager").Preload("Tags")
+	if c.QueryBool("includeDeleted") {
+		tx = db.DB.Unscoped().Preload("Manager", unscoped).Preload("Tags")
+	}
 	if q != "" {
-		p := "%" + q + "%"
-		tx = tx.Where(
-			"legal_name ILIKE ? OR filter_name ILIKE ? OR location ILIKE ?",
-			p, p, p,
-		)
+		tx = associationSearch.apply(tx, "associations", q)
+	}
+	if state := c.Query("state"); state != "" {
+		tx = tx.Where("state = ?", strings.ToUpper(state))
+	}
+	if county := c.Query("county"); county != "" {
+		tx = tx.Where("county ILIKE ?", county)
+	}
+	if typ := c.Query("type"); typ != "" {
+		tx = tx.Where("type = ?", strings.ToLower(typ))
+	}
+	if tags := c.Query("tags"); tags != "" {
+		tx = tagFilter(tx, tags, c.Query("tagMode") == "any")
+	}
+	if bbox := c.Query("bbox"); bbox != "" {
+		var err error
+		if tx, err = bboxFilter(tx, bbox); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := customFilter(c, tx, "associations")
+	if err != nil {
+		return nil, err
 	}
+	return tx.Order("legal_name asc"), nil
+}
 
-	if err := tx.Order("legal_name asc").Find(&list).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load associations"})
+// GET /api/admin/data/associations?q=alpha&state=FL&type=condo&tags=high-rise,pool&custom.gate_code=1234&includeDeleted=true
+// bbox=west,south,east,north (degrees) keeps the associations placed within
+// the box; see NearbyAssociations for a radius.
+func ListAssociations(c *fiber.Ctx) error {
+	tx, err := associationQuery(c)
+	if err != nil {
+		return err
+	}
+	var list []models.Association
+	if err := tx.Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load associations")
 	}
 	return c.JSON(list)
 }
 
+// validateAssociation checks the fields of a create or update body; nil
+// fields were not sent and are skipped. managerId must name a live manager
+// in tx.
+func validateAssociation(v *validate.Validator, tx *gorm.DB, legalName, filterName, location, managerID *string) {
+	if legalName != nil {
+		v.Required("legalName", *legalName)
+		v.MaxLen("legalName", *legalName, 200)
+	}
+	if filterName != nil {
+		v.Required("filterName", *filterName)
+		v.MaxLen("filterName", *filterName, 100)
+	}
+	if location != nil {
+		v.Required("location", *location)
+		v.MaxLen("location", *location, 100)
+	}
+	if managerID != nil {
+		v.Required("managerId", *managerID)
+		v.UUID("managerId", *managerID)
+		if !v.Has("managerId") {
+			var m models.Manager
+			v.Check(tx.First(&m, "id = ?", *managerID).Error == nil, "managerId", "manager not found")
+		}
+	}
+}
+
+// associationTypes are the values accepted for an association's type.
+var associationTypes = []string{"condo", "hoa", "coop"}
+
+// associationProfile is the optional part of a create or update body. nil
+// fields were not sent; an empty string clears a field.
+type associationProfile struct {
+	Street            *string `json:"street"`
+	City              *string `json:"city"`
+	County            *string `json:"county"`
+	State             *string `json:"state"`
+	ZIP               *string `json:"zip"`
+	Type              *string `json:"type"`
+	UnitCount         *int    `json:"unitCount"`
+	FiscalYearEnd     *string `json:"fiscalYearEnd"`
+	StateCorpNumber   *string `json:"stateCorpNumber"`
+	EIN               *string `json:"ein"`
+	IncorporationDate *string `json:"incorporationDate"`
+}
+
+// normalize trims every field and puts codes in their canonical case.
+func (p *associationProfile) normalize() {
+	trimAll(p.Street, p.City, p.County, p.State, p.ZIP, p.Type,
+		p.FiscalYearEnd, p.StateCorpNumber, p.EIN, p.IncorporationDate)
+	if p.State != nil {
+		*p.State = strings.ToUpper(*p.State)
+	}
+	if p.Type != nil {
+		*p.Type = strings.ToLower(*p.Type)
+	}
+	if p.EIN != nil && len(*p.EIN) == 9 {
+		*p.EIN = (*p.EIN)[:2] + "-" + (*p.EIN)[2:]
+	}
+}
+
+func (p *associationProfile) validate(v *validate.Validator) {
+	for field, value := range map[string]*string{
+		"street": p.Street, "city": p.City, "county": p.County, "stateCorpNumber": p.StateCorpNumber,
+	} {
+		if value != nil {
+			v.MaxLen(field, *value, 100)
+		}
+	}
+	check := func(field string, value *string, fn func(field, value string)) {
+		if value != nil && *value != "" {
+			fn(field, *value)
+		}
+	}
+	check("state", p.State, v.State)
+	check("zip", p.ZIP, v.ZIP)
+	check("type", p.Type, func(field, value string) { v.OneOf(field, value, associationTypes...) })
+	check("fiscalYearEnd", p.FiscalYearEnd, v.MonthDay)
+	check("ein", p.EIN, v.EIN)
+	check("incorporationDate", p.IncorporationDate, v.Date)
+	if p.IncorporationDate != nil && !v.Has("incorporationDate") {
+		v.Check(*p.IncorporationDate <= time.Now().Format(time.DateOnly), "incorporationDate", "must not be in the future")
+	}
+	if p.UnitCount != nil {
+		v.Check(*p.UnitCount >= 0 && *p.UnitCount <= 100000, "unitCount", "must be between 0 and 100000")
+	}
+}
+
+// date parses a validated incorporationDate; empty clears it.
+func (p *associationProfile) date() *time.Time {
+	if *p.IncorporationDate == "" {
+		return nil
+	}
+	t, _ := time.Parse(time.DateOnly, *p.IncorporationDate)
+	return &t
+}
+
+// apply copies the sent fields onto a.
+func (p *associationProfile) apply(a *models.Association) {
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&a.Street, p.Street)
+	set(&a.City, p.City)
+	set(&a.County, p.County)
+	set(&a.State, p.State)
+	set(&a.ZIP, p.ZIP)
+	set(&a.Type, p.Type)
+	set(&a.FiscalYearEnd, p.FiscalYearEnd)
+	set(&a.StateCorpNumber, p.StateCorpNumber)
+	if p.UnitCount != nil {
+		a.UnitCount = *p.UnitCount
+	}
+	if p.EIN != nil {
+		a.EIN = secret.String(*p.EIN)
+	}
+	if p.IncorporationDate != nil {
+		a.IncorporationDate = p.date()
+	}
+}
+
+// columns returns the sent fields as column updates.
+func (p *associationProfile) columns() map[string]any {
+	updates := map[string]any{}
+	for col, value := range map[string]*string{
+		"street": p.Street, "city": p.City, "county": p.County, "state": p.State, "zip": p.ZIP,
+		"type": p.Type, "fiscal_year_end": p.FiscalYearEnd, "state_corp_number": p.StateCorpNumber,
+	} {
+		if value != nil {
+			updates[col] = *value
+		}
+	}
+	if p.UnitCount != nil {
+		updates["unit_count"] = *p.UnitCount
+	}
+	if p.EIN != nil {
+		updates["ein"] = secret.String(*p.EIN)
+	}
+	if p.IncorporationDate != nil {
+		updates["incorporation_date"] = p.date()
+	}
+	return updates
+}
+
 // POST /api/admin/data/associations
-// Body: { "legalName": "...", "filterName": "...", "location": "...", "managerId": "uuid" }
+// Body: { "legalName": "...", "filterName": "...", "location": "...", "managerId": "uuid",
+// "managerChangeReason": "..." } plus any profile fields: "street", "city", "county", "state": "FL", "zip",
+// "type": "condo"|"hoa"|"coop", "unitCount", "fiscalYearEnd": "12-31",
+// "stateCorpNumber", "ein": "12-3456789", "incorporationDate": "2001-05-01",
+// and "custom": { "<key>": value } for the custom fields defined for associations
 func CreateAssociation(c *fiber.Ctx) error {
 	var in struct {
 		LegalName  string `json:"legalName"`
 		FilterName string `json:"filterName"`
 		Location   string `json:"location"`
 		ManagerID  string `json:"managerId"`
+		// ManagerChangeReason is recorded with the primary assignment.
+		ManagerChangeReason string      `json:"managerChangeReason"`
+		Custom              customInput `json:"custom"`
+		associationProfile
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
-	}
-	if in.LegalName == "" || in.FilterName == "" || in.Location == "" || in.ManagerID == "" {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "All fields required"})
+		return problem.New(http.StatusBadRequest, "Invalid input")
 	}
+	in.LegalName = strings.TrimSpace(in.LegalName)
+	in.FilterName = strings.TrimSpace(in.FilterName)
+	in.Location = strings.TrimSpace(in.Location)
+	in.ManagerID = strings.TrimSpace(in.ManagerID)
+	in.ManagerChangeReason = strings.TrimSpace(in.ManagerChangeReason)
+	in.normalize()
 
-	// ensure manager exists
-	var m models.Manager
-	if err := db.DB.First(&m, "id = ?", in.ManagerID).Error; err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Manager not found"})
+	var v validate.Validator
+	validateAssociation(&v, dbOf(c), &in.LegalName, &in.FilterName, &in.Location, &in.ManagerID)
+	v.MaxLen("managerChangeReason", in.ManagerChangeReason, 500)
+	in.validate(&v)
+	custom, _, err := in.Custom.check(&v, "associations", true)
+	if err != nil {
+		return err
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
 	}
 
 	a := models.Association{
@@ -56,60 +261,148 @@ func CreateAssociation(c *fiber.Ctx) error {
 		FilterName: in.FilterName,
 		Location:   in.Location,
 		ManagerID:  in.ManagerID,
+		Custom:     custom,
+	}
+	in.apply(&a)
+	geocode(&a)
+	if in.ManagerChangeReason == "" {
+		in.ManagerChangeReason = "Assigned when the association was created"
 	}
-	if err := db.DB.Create(&a).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Create failed"})
+	who, _ := middleware.CurrentUser(c)
+	err = dbOf(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&a).Error; err != nil {
+			return err
+		}
+		if err := assignPrimary(tx, a.ID, a.ManagerID, in.ManagerChangeReason, who); err != nil {
+			return err
+		}
+		return emit(tx, "association.created", a.ID, who)
+	})
+	if err != nil {
+		return dbError(err, "Create failed")
 	}
 	return c.Status(http.StatusCreated).JSON(a)
 }
 
+// GET /api/admin/data/associations/:id
+// The ETag header carries the version to send back as If-Match on update.
+func GetAssociation(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var a models.Association
+	if err := db.DB.Preload("Manager").Preload("Tags").First(&a, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Association not found")
+	}
+	setETag(c, &a)
+	return c.JSON(a)
+}
+
 // PUT /api/admin/data/associations/:id
-// Body (any subset): { "legalName": "...", "filterName": "...", "location": "...", "managerId": "uuid" }
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body (any subset): { "legalName": "...", "filterName": "...", "location": "...", "managerId": "uuid",
+// "managerChangeReason": "..." } and the profile fields of CreateAssociation.
+// "custom" values are merged into the stored ones; null clears one.
+// A new managerId becomes the primary assignment from today; see
+// CreateAssignment to backdate it or add assistants.
 func UpdateAssociation(c *fiber.Ctx) error {
 	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
 	var in struct {
 		LegalName  *string `json:"legalName"`
 		FilterName *string `json:"filterName"`
 		Location   *string `json:"location"`
 		ManagerID  *string `json:"managerId"`
+		// ManagerChangeReason is recorded with a new primary assignment.
+		ManagerChangeReason string      `json:"managerChangeReason"`
+		Custom              customInput `json:"custom"`
+		associationProfile
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return problem.New(http.StatusBadRequest, "Invalid input")
 	}
+	trimAll(in.LegalName, in.FilterName, in.Location, in.ManagerID, &in.ManagerChangeReason)
+	in.normalize()
 
-	updates := map[string]any{}
+	var v validate.Validator
+	v.UUID("id", id)
+	validateAssociation(&v, dbOf(c), in.LegalName, in.FilterName, in.Location, in.ManagerID)
+	v.MaxLen("managerChangeReason", in.ManagerChangeReason, 500)
+	in.validate(&v)
+	set, unset, err := in.Custom.check(&v, "associations", false)
+	if err != nil {
+		return err
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	updates := in.columns()
+	if len(in.Custom) > 0 {
+		updates["custom"] = customUpdate(set, unset)
+	}
 	if in.LegalName != nil {
-		updates["legal_name"] = strings.TrimSpace(*in.LegalName)
+		updates["legal_name"] = *in.LegalName
 	}
 	if in.FilterName != nil {
-		updates["filter_name"] = strings.TrimSpace(*in.FilterName)
+		updates["filter_name"] = *in.FilterName
 	}
 	if in.Location != nil {
-		updates["location"] = strings.TrimSpace(*in.Location)
+		updates["location"] = *in.Location
 	}
+	who, _ := middleware.CurrentUser(c)
+	var also []func(tx *gorm.DB) error
 	if in.ManagerID != nil {
-		// verify new manager exists
-		var m models.Manager
-		if err := db.DB.First(&m, "id = ?", *in.ManagerID).Error; err != nil {
-			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Manager not found"})
-		}
 		updates["manager_id"] = *in.ManagerID
+		reason := in.ManagerChangeReason
+		if reason == "" {
+			reason = "Changed through managerId"
+		}
+		also = append(also, func(tx *gorm.DB) error {
+			return assignPrimary(tx, id, *in.ManagerID, reason, who)
+		})
 	}
 	if len(updates) == 0 {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "No changes"})
+		return problem.New(http.StatusBadRequest, "No changes")
 	}
-
-	if err := db.DB.Model(&models.Association{}).Where("id = ?", id).Updates(updates).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Update failed"})
+	if in.Location != nil || in.City != nil || in.County != nil || in.State != nil || in.ZIP != nil {
+		var explicit []string
+		for _, col := range geoFillable {
+			if _, ok := updates[col]; ok {
+				explicit = append(explicit, col)
+			}
+		}
+		also = append(also, geocodingStored(id, explicit...))
 	}
-	return c.JSON(fiber.Map{"message": "Updated"})
+	also = append(also, emitting("association.updated", id, who))
+
+	return versionedUpdate(c, dbOf(c).Preload("Manager"), &models.Association{}, id, version, updates, also...)
 }
 
 // DELETE /api/admin/data/associations/:id
+// Moves the association to the trash; see RestoreTrash.
 func DeleteAssociation(c *fiber.Ctx) error {
 	id := c.Params("id")
-	if err := db.DB.Delete(&models.Association{}, "id = ?", id).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Delete failed"})
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+
+	err := dbOf(c).Transaction(func(tx *gorm.DB) error {
+		if err := softDelete(tx, &models.Association{}, id, who); err != nil {
+			return err
+		}
+		return emit(tx, "association.deleted", id, who)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return problem.New(http.StatusNotFound, "Association not found")
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
 	}
 	return c.JSON(fiber.Map{"message": "Deleted"})
 }
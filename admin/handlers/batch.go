@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/problem"
+	"admin/validate"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
+)
+
+// batchTxKey is the local under which a batch hands its transaction to the
+// handlers running its operations; see dbOf.
+const batchTxKey = "batchTx"
+
+// dbOf is the handle c's writes go through: the transaction of the batch
+// when c is an operation of one, db.DB otherwise. Handlers that a batch
+// can run read the rows they write through it too, as rows created earlier
+// in the batch are only visible inside its transaction.
+func dbOf(c *fiber.Ctx) *gorm.DB {
+	if tx, ok := c.Locals(batchTxKey).(*gorm.DB); ok {
+		return tx
+	}
+	return db.DB
+}
+
+// batchLimit bounds the operations in one batch.
+const batchLimit = 100
+
+// batchPaths are the REST collections a batch operates on, by kind.
+var batchPaths = map[string]string{
+	"associations": "/api/admin/data/associations",
+	"managers":     "/api/admin/data/managers",
+	"users":        "/api/admin/users",
+}
+
+var (
+	batchRefRe       = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+	batchReferenceRe = regexp.MustCompile(`^@\{([A-Za-z0-9_-]{1,50})\}$`)
+)
+
+// batchOperation is one entry of a batch: what the REST request would be.
+type batchOperation struct {
+	Op   string `json:"op"`
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	// Ref names a created record for the operations after it.
+	Ref     string         `json:"ref"`
+	IfMatch string         `json:"ifMatch"`
+	Body    map[string]any `json:"body"`
+}
+
+// batchResult is the outcome of one operation: the status, ETag and body
+// of its REST response, and the id it acted on or created.
+type batchResult struct {
+	Ref    string          `json:"ref,omitempty"`
+	Status int             `json:"status"`
+	ID     string          `json:"id,omitempty"`
+	ETag   string          `json:"etag,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// references calls fn with the ref named by every "@{ref}" string in v.
+func references(v any, fn func(ref string)) {
+	switch v := v.(type) {
+	case string:
+		if m := batchReferenceRe.FindStringSubmatch(v); m != nil {
+			fn(m[1])
+		}
+	case map[string]any:
+		for _, e := range v {
+			references(e, fn)
+		}
+	case []any:
+		for _, e := range v {
+			references(e, fn)
+		}
+	}
+}
+
+// resolve replaces every "@{ref}" string in v with the id created by ref.
+func resolve(v any, ids map[string]string) any {
+	switch v := v.(type) {
+	case string:
+		if m := batchReferenceRe.FindStringSubmatch(v); m != nil {
+			return ids[m[1]]
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = resolve(e, ids)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = resolve(e, ids)
+		}
+		return out
+	}
+	return v
+}
+
+// validateBatch checks the shape of every operation and that each
+// reference names the ref of an earlier create.
+func validateBatch(ops []batchOperation) error {
+	var v validate.Validator
+	v.Check(len(ops) >= 1 && len(ops) <= batchLimit, "operations", fmt.Sprintf("must hold 1 to %d operations", batchLimit))
+	refs := map[string]bool{}
+	for i, op := range ops {
+		field := func(name string) string { return fmt.Sprintf("operations[%d].%s", i, name) }
+		v.OneOf(field("op"), op.Op, "create", "update", "delete")
+		if _, ok := batchPaths[op.Kind]; !ok {
+			v.Add(field("kind"), "must be associations, managers or users")
+		}
+		if op.Op == "create" {
+			v.Check(op.ID == "", field("id"), "must not be set on create")
+		} else {
+			v.Required(field("id"), op.ID)
+			if op.ID != "" && !batchReferenceRe.MatchString(op.ID) {
+				v.UUID(field("id"), op.ID)
+			}
+			v.Check(op.Ref == "", field("ref"), "is only allowed on create")
+		}
+		references(op.ID, func(ref string) {
+			v.Check(refs[ref], field("id"), "references "+ref+", which no earlier operation creates")
+		})
+		references(op.Body, func(ref string) {
+			v.Check(refs[ref], field("body"), "references "+ref+", which no earlier operation creates")
+		})
+		if op.Ref != "" {
+			v.Check(batchRefRe.MatchString(op.Ref), field("ref"), "must be 1 to 50 letters, digits, _ or -")
+			v.Check(!refs[op.Ref], field("ref"), "is already used by an earlier operation")
+			refs[op.Ref] = true
+		}
+	}
+	return v.Err()
+}
+
+// POST /api/admin/batch
+// Body: { "operations": [
+//
+//	{ "op": "create", "kind": "managers", "ref": "jane", "body": { "name": "...", ... } },
+//	{ "op": "create", "kind": "associations", "body": { "managerId": "@{jane}", ... } },
+//	{ "op": "update", "kind": "associations", "id": "uuid", "ifMatch": "\"3\"", "body": { ... } },
+//	{ "op": "delete", "kind": "users", "id": "uuid" } ] }
+//
+// Runs create, update and delete operations on associations, managers and
+// users in order, in one transaction. Each runs as the REST request it
+// stands for (POST, PUT or DELETE on the kind's collection; a user update
+// is PUT /users/:id/role), with the caller's credentials, so the same
+// validation and permissions apply. "@{ref}" anywhere in a later id or body
+// stands for the id created by the operation with that ref. On success the
+// response lists each operation's result; the first failure rolls every
+// operation back and is returned with its status, its index under
+// "operation" and the results up to it under "results".
+func Batch(c *fiber.Ctx) error {
+	var in struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if err := validateBatch(in.Operations); err != nil {
+		return validationError(err)
+	}
+
+	handler := c.App().Handler()
+	results := make([]batchResult, 0, len(in.Operations))
+	var failed *problem.Problem
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		ids := map[string]string{}
+		for i, op := range in.Operations {
+			res, err := runBatchOperation(c, handler, tx, op, ids)
+			if err != nil {
+				return err
+			}
+			results = append(results, res)
+			if res.Status >= http.StatusBadRequest {
+				failed = problem.New(res.Status, fmt.Sprintf("Operation %d failed; no operation was applied", i)).
+					With("operation", i).With("results", results)
+				return failed
+			}
+			if op.Ref != "" {
+				ids[op.Ref] = res.ID
+			}
+		}
+		return nil
+	})
+	if failed != nil {
+		return failed
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Batch failed")
+	}
+	return c.JSON(fiber.Map{"results": results})
+}
+
+// runBatchOperation sends op through handler as its REST request, with
+// references resolved against ids, and writing through tx.
+func runBatchOperation(c *fiber.Ctx, handler fasthttp.RequestHandler, tx *gorm.DB, op batchOperation, ids map[string]string) (batchResult, error) {
+	id, _ := resolve(op.ID, ids).(string)
+	method, path := http.MethodPost, batchPaths[op.Kind]
+	switch op.Op {
+	case "update":
+		method, path = http.MethodPut, path+"/"+id
+		if op.Kind == "users" {
+			path += "/role"
+		}
+	case "delete":
+		method, path = http.MethodDelete, path+"/"+id
+	}
+	var body []byte
+	if op.Body != nil || op.Op != "delete" {
+		var err error
+		if body, err = json.Marshal(resolve(op.Body, ids)); err != nil {
+			return batchResult{}, err
+		}
+		if op.Body == nil {
+			body = []byte("{}")
+		}
+	}
+
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(path)
+	// The caller's credentials, and nothing else of their request.
+	for _, h := range []string{fiber.HeaderCookie, fiber.HeaderAuthorization} {
+		if v := c.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	if op.IfMatch != "" {
+		req.Header.Set(fiber.HeaderIfMatch, op.IfMatch)
+	}
+	if body != nil {
+		req.Header.SetContentType(fiber.MIMEApplicationJSON)
+		req.SetBody(body)
+	}
+	var sub fasthttp.RequestCtx
+	sub.Init(&req, c.Context().RemoteAddr(), nil)
+	sub.SetUserValue(batchTxKey, tx)
+	handler(&sub)
+
+	res := batchResult{Ref: op.Ref, Status: sub.Response.StatusCode(), ID: id}
+	if b := sub.Response.Body(); len(b) > 0 {
+		res.Body = json.RawMessage(append([]byte(nil), b...))
+	}
+	if etag := sub.Response.Header.Peek(fiber.HeaderETag); len(etag) > 0 {
+		res.ETag = string(etag)
+	}
+	if op.Op == "create" && res.Status < http.StatusBadRequest {
+		var created struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(res.Body, &created)
+		res.ID = created.ID
+	}
+	return res, nil
+}
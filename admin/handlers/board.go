@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// boardOffices in precedence order, which is also the order boards list in.
+// Every office but director is held by one person at a time.
+var boardOffices = []string{"president", "vice-president", "secretary", "treasurer", "director"}
+
+// byOffice orders board members by office precedence.
+const byOffice = "array_position(ARRAY['president','vice-president','secretary','treasurer','director']::text[], office), name"
+
+// today is the current date at midnight UTC, as dates come back from the database.
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// hideBoardContacts blanks the contact data of members whose association is
+// outside the caller's scope.
+func hideBoardContacts(c *fiber.Ctx, list []models.BoardMember) error {
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	scope.hideBoardContacts(list)
+	return nil
+}
+
+// hideBoardContacts blanks the contact data of members whose association is
+// outside s and marks them ContactHidden.
+func (s associationScope) hideBoardContacts(list []models.BoardMember) {
+	for i := range list {
+		m := &list[i]
+		if !s.allows(m.AssociationID) {
+			m.Email, m.Phone, m.MailingAddress = "", "", ""
+			m.ContactHidden = true
+		}
+	}
+}
+
+// GET /api/admin/data/associations/:id/board
+// The members whose term covers today, in office order.
+func ListBoard(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var list []models.BoardMember
+	err := db.DB.Where("association_id = ?", id).
+		Where("term_start <= ? AND (term_end IS NULL OR term_end >= ?)", today(), today()).
+		Order(byOffice).
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load board")
+	}
+	if err := hideBoardContacts(c, list); err != nil {
+		return err
+	}
+	return c.JSON(list)
+}
+
+// GET /api/admin/data/associations/:id/board/history
+// Every term, most recent first.
+func ListBoardHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var list []models.BoardMember
+	err := db.DB.Where("association_id = ?", id).
+		Order("term_start desc").Order(byOffice).
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load board history")
+	}
+	if err := hideBoardContacts(c, list); err != nil {
+		return err
+	}
+	return c.JSON(list)
+}
+
+// GET /api/admin/data/board/expiring?days=60&associationId=uuid
+// Terms ending between today and today+days (default 60), soonest first,
+// each with its association.
+func ListExpiringTerms(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 60)
+	if days < 0 || days > 3660 {
+		return problem.New(http.StatusBadRequest, "days must be between 0 and 3660")
+	}
+	tx := db.DB.Preload("Association").
+		Where("term_end BETWEEN ? AND ?", today(), today().AddDate(0, 0, days))
+	if a := c.Query("associationId"); a != "" {
+		if err := validate.ID("associationId", a); err != nil {
+			return validationError(err)
+		}
+		tx = tx.Where("association_id = ?", a)
+	}
+	var list []models.BoardMember
+	if err := tx.Order("term_end asc").Order(byOffice).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load expiring terms")
+	}
+	if err := hideBoardContacts(c, list); err != nil {
+		return err
+	}
+	return c.JSON(list)
+}
+
+// boardMemberInput is a create or update body; nil fields were not sent.
+type boardMemberInput struct {
+	Name           *string `json:"name"`
+	Office         *string `json:"office"`
+	TermStart      *string `json:"termStart"`
+	TermEnd        *string `json:"termEnd"`
+	Email          *string `json:"email"`
+	Phone          *string `json:"phone"`
+	MailingAddress *string `json:"mailingAddress"`
+}
+
+// validate checks the sent fields and fills in m, the member as it will be
+// saved, so the term can be checked as a whole.
+func (in *boardMemberInput) validate(v *validate.Validator, m *models.BoardMember) {
+	trimAll(in.Name, in.Office, in.TermStart, in.TermEnd, in.Email, in.Phone, in.MailingAddress)
+	if in.Office != nil {
+		*in.Office = strings.ToLower(*in.Office)
+	}
+	contact := ownerInput{Name: in.Name, Email: in.Email, Phone: in.Phone, MailingAddress: in.MailingAddress}
+	contact.validate(v)
+	if in.Office != nil {
+		v.OneOf("office", *in.Office, boardOffices...)
+	}
+	if in.TermStart != nil {
+		v.Required("termStart", *in.TermStart)
+		v.Date("termStart", *in.TermStart)
+	}
+	if in.TermEnd != nil && *in.TermEnd != "" {
+		v.Date("termEnd", *in.TermEnd)
+	}
+	if v.Err() != nil {
+		return
+	}
+
+	for dst, src := range map[*string]*string{
+		&m.Name: in.Name, &m.Office: in.Office, &m.Email: in.Email, &m.Phone: in.Phone, &m.MailingAddress: in.MailingAddress,
+	} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if in.TermStart != nil {
+		m.TermStart, _ = time.Parse(time.DateOnly, *in.TermStart)
+	}
+	if in.TermEnd != nil {
+		m.TermEnd = nil
+		if *in.TermEnd != "" {
+			end, _ := time.Parse(time.DateOnly, *in.TermEnd)
+			m.TermEnd = &end
+		}
+	}
+	if m.TermEnd != nil && m.TermEnd.Before(m.TermStart) {
+		v.Add("termEnd", "must not be before termStart")
+		return
+	}
+
+	// One person per office at a time, directors excepted.
+	if m.Office != "director" {
+		end := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+		if m.TermEnd != nil {
+			end = *m.TermEnd
+		}
+		tx := db.DB.Where("association_id = ? AND office = ?", m.AssociationID, m.Office).
+			Where("term_start <= ? AND (term_end IS NULL OR term_end >= ?)", end, m.TermStart)
+		if m.ID != "" {
+			tx = tx.Where("id <> ?", m.ID)
+		}
+		var holder models.BoardMember
+		if err := tx.First(&holder).Error; err == nil {
+			v.Add("office", holder.Name+" holds this office during that period")
+		}
+	}
+}
+
+// columns returns the sent fields of m, as validated, as column updates.
+func (in *boardMemberInput) columns(m *models.BoardMember) map[string]any {
+	updates := map[string]any{}
+	set := func(col string, sent *string, value any) {
+		if sent != nil {
+			updates[col] = value
+		}
+	}
+	set("name", in.Name, m.Name)
+	set("office", in.Office, m.Office)
+	set("term_start", in.TermStart, m.TermStart)
+	set("term_end", in.TermEnd, m.TermEnd)
+	set("email", in.Email, m.Email)
+	set("phone", in.Phone, m.Phone)
+	set("mailing_address", in.MailingAddress, m.MailingAddress)
+	return updates
+}
+
+// POST /api/admin/data/associations/:id/board
+// Body: { "name": "...", "office": "president", "termStart": "2025-01-01", "termEnd": "2026-12-31",
+// "email": "...", "phone": "...", "mailingAddress": "..." }
+func CreateBoardMember(c *fiber.Ctx) error {
+	associationID := c.Params("id")
+	var in boardMemberInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	for _, f := range []**string{&in.Name, &in.Office, &in.TermStart} {
+		if *f == nil {
+			*f = new(string)
+		}
+	}
+	if err := validate.ID("id", associationID); err != nil {
+		return validationError(err)
+	}
+	var a models.Association
+	if err := db.DB.First(&a, "id = ?", associationID).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Association not found")
+	}
+	if err := requireScope(c, a.ID, in.Email, in.Phone, in.MailingAddress); err != nil {
+		return err
+	}
+
+	m := models.BoardMember{AssociationID: a.ID}
+	var v validate.Validator
+	in.validate(&v, &m)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if err := db.DB.Omit("Association").Create(&m).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(m)
+}
+
+// requireScope refuses contact changes for an association outside the
+// caller's scope.
+func requireScope(c *fiber.Ctx, associationID string, contact ...*string) error {
+	sent := false
+	for _, f := range contact {
+		sent = sent || f != nil
+	}
+	if !sent {
+		return nil
+	}
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	if !scope.allows(associationID) {
+		return problem.New(http.StatusForbidden, "Contact data for this association is outside your scope")
+	}
+	return nil
+}
+
+// PUT /api/admin/data/board/:id
+// Header: If-Match: "<version>"
+// Body (any subset): the fields of CreateBoardMember; an empty termEnd makes
+// the term open-ended.
+func UpdateBoardMember(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in boardMemberInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var m models.BoardMember
+	if err := db.DB.First(&m, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Board member not found")
+	}
+	if err := requireScope(c, m.AssociationID, in.Email, in.Phone, in.MailingAddress); err != nil {
+		return err
+	}
+
+	var v validate.Validator
+	in.validate(&v, &m)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	updates := in.columns(&m)
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	reload := db.DB
+	if scope, err := scopeOf(c); err != nil {
+		return err
+	} else if !scope.allows(m.AssociationID) {
+		reload = db.DB.Omit("email", "phone", "mailing_address")
+	}
+	return versionedUpdate(c, reload, &models.BoardMember{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/board/:id
+// Removes a term entered in error; to end a term early, set termEnd instead.
+func DeleteBoardMember(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.BoardMember{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Board member not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
@@ -0,0 +1,427 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// customEntities are the tables that carry custom fields.
+var customEntities = []string{"associations", "managers"}
+
+var customFieldTypes = []string{"text", "number", "date", "enum", "boolean"}
+
+var customKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// customTextMax bounds text values when a field sets no Max.
+const customTextMax = 1000
+
+// customFields loads the field definitions of entity by key.
+func customFields(entity string) (map[string]models.CustomField, error) {
+	var list []models.CustomField
+	if err := db.DB.Where("entity = ?", entity).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	defs := make(map[string]models.CustomField, len(list))
+	for _, f := range list {
+		defs[f.Key] = f
+	}
+	return defs, nil
+}
+
+// customInput is the "custom" member of a create or update body: values by
+// field key, where null (or an empty string) clears a value.
+type customInput map[string]any
+
+// check validates in against the entity's field definitions and returns the
+// values to set and the keys to clear. When creating, every required field
+// must be given.
+func (in customInput) check(v *validate.Validator, entity string, creating bool) (models.CustomValues, []string, error) {
+	defs, err := customFields(entity)
+	if err != nil {
+		return nil, nil, problem.New(http.StatusInternalServerError, "Failed to load custom fields")
+	}
+	set := models.CustomValues{}
+	var unset []string
+	for key, value := range in {
+		field := "custom." + key
+		f, ok := defs[key]
+		if !ok {
+			v.Add(field, "unknown custom field")
+			continue
+		}
+		if s, isString := value.(string); isString {
+			value = strings.TrimSpace(s)
+		}
+		if value == nil || value == "" {
+			v.Check(!f.Required, field, "is required")
+			unset = append(unset, key)
+			continue
+		}
+		if value, ok = customValue(v, f, field, value); ok {
+			set[key] = value
+		}
+	}
+	if creating {
+		for key, f := range defs {
+			if _, given := set[key]; f.Required && !given && !v.Has("custom."+key) {
+				v.Add("custom."+key, "is required")
+			}
+		}
+	}
+	return set, unset, nil
+}
+
+// customValue checks one value against its field and returns it as stored.
+func customValue(v *validate.Validator, f models.CustomField, field string, value any) (any, bool) {
+	switch f.Type {
+	case "number":
+		n, ok := value.(float64)
+		if !ok {
+			v.Add(field, "must be a number")
+			return nil, false
+		}
+		if f.Min != nil && n < *f.Min || f.Max != nil && n > *f.Max {
+			v.Add(field, "must be between "+bound(f.Min, "-∞")+" and "+bound(f.Max, "∞"))
+			return nil, false
+		}
+		return n, true
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			v.Add(field, "must be true or false")
+		}
+		return b, ok
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		v.Add(field, "must be a string")
+		return nil, false
+	}
+	switch f.Type {
+	case "date":
+		v.Date(field, s)
+	case "enum":
+		v.OneOf(field, s, f.Options...)
+	default:
+		max := customTextMax
+		if f.Max != nil {
+			max = int(*f.Max)
+		}
+		v.MaxLen(field, s, max)
+		if f.Min != nil {
+			v.MinLen(field, s, int(*f.Min))
+		}
+	}
+	return s, !v.Has(field)
+}
+
+func bound(b *float64, none string) string {
+	if b == nil {
+		return none
+	}
+	return strconv.FormatFloat(*b, 'f', -1, 64)
+}
+
+// customUpdate is the column update that merges set into custom and removes
+// the unset keys.
+func customUpdate(set models.CustomValues, unset []string) any {
+	expr := "custom || ?::jsonb"
+	args := []any{set}
+	for _, key := range unset {
+		expr += " - ?::text"
+		args = append(args, key)
+	}
+	return gorm.Expr(expr, args...)
+}
+
+// customFilter applies the custom.<key>=value query parameters of c to tx.
+// text values match case-insensitively, the other types exactly; number and
+// date fields also take custom.<key>.min and custom.<key>.max bounds.
+func customFilter(c *fiber.Ctx, tx *gorm.DB, entity string) (*gorm.DB, error) {
+	var params []string
+	for name := range c.Queries() {
+		if strings.HasPrefix(name, "custom.") {
+			params = append(params, name)
+		}
+	}
+	if len(params) == 0 {
+		return tx, nil
+	}
+	defs, err := customFields(entity)
+	if err != nil {
+		return nil, problem.New(http.StatusInternalServerError, "Failed to load custom fields")
+	}
+
+	col := entity + ".custom"
+	var v validate.Validator
+	for _, name := range params {
+		value := c.Query(name)
+		key, op, _ := strings.Cut(strings.TrimPrefix(name, "custom."), ".")
+		f, ok := defs[key]
+		if !ok {
+			v.Add(name, "unknown custom field")
+			continue
+		}
+		if op != "" {
+			if op != "min" && op != "max" || f.Type != "number" && f.Type != "date" {
+				v.Add(name, "only number and date fields take min and max")
+				continue
+			}
+			cmp := map[string]string{"min": ">=", "max": "<="}[op]
+			cast := map[string]string{"number": "numeric", "date": "date"}[f.Type]
+			if f.Type == "number" {
+				_, err := strconv.ParseFloat(value, 64)
+				v.Check(err == nil, name, "must be a number")
+			} else {
+				v.Date(name, value)
+			}
+			tx = tx.Where(fmt.Sprintf("(%s->>?)::%s %s ?", col, cast, cmp), key, value)
+			continue
+		}
+
+		var want any = value
+		switch f.Type {
+		case "text":
+			tx = tx.Where("lower("+col+"->>?) = lower(?)", key, value)
+			continue
+		case "number":
+			n, err := strconv.ParseFloat(value, 64)
+			v.Check(err == nil, name, "must be a number")
+			want = n
+		case "boolean":
+			b, err := strconv.ParseBool(value)
+			v.Check(err == nil, name, "must be true or false")
+			want = b
+		case "date":
+			v.Date(name, value)
+		}
+		contains, _ := json.Marshal(map[string]any{key: want})
+		tx = tx.Where(col+" @> ?::jsonb", string(contains))
+	}
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+	return tx, nil
+}
+
+// GET /api/admin/custom-fields?entity=associations
+func ListCustomFields(c *fiber.Ctx) error {
+	tx := db.DB.Order("entity asc, label asc")
+	if e := c.Query("entity"); e != "" {
+		tx = tx.Where("entity = ?", e)
+	}
+	var list []models.CustomField
+	if err := tx.Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load custom fields")
+	}
+	return c.JSON(list)
+}
+
+// customFieldInput is a create or update body; nil fields were not sent,
+// nor were min and max unless Sent. Entity, key and type are fixed once
+// created.
+type customFieldInput struct {
+	Entity   *string        `json:"entity"`
+	Key      *string        `json:"key"`
+	Type     *string        `json:"type"`
+	Label    *string        `json:"label"`
+	Options  []string       `json:"options"`
+	Required *bool          `json:"required"`
+	Min      optionalNumber `json:"min"`
+	Max      optionalNumber `json:"max"`
+}
+
+// optionalNumber is a number that null clears; Sent tells a null from a
+// missing key.
+type optionalNumber struct {
+	Sent  bool
+	Value *float64
+}
+
+func (n *optionalNumber) UnmarshalJSON(b []byte) error {
+	n.Sent = true
+	return json.Unmarshal(b, &n.Value)
+}
+
+// validate checks the sent fields of f, the field as it will be saved.
+func (in *customFieldInput) validate(v *validate.Validator, f *models.CustomField) {
+	trimAll(in.Entity, in.Key, in.Type, in.Label)
+	if in.Entity != nil {
+		v.OneOf("entity", *in.Entity, customEntities...)
+		f.Entity = *in.Entity
+	}
+	if in.Key != nil {
+		v.Check(customKeyPattern.MatchString(*in.Key), "key",
+			"must be a lower-case letter followed by up to 39 lower-case letters, digits or underscores")
+		f.Key = *in.Key
+	}
+	if in.Type != nil {
+		v.OneOf("type", *in.Type, customFieldTypes...)
+		f.Type = *in.Type
+	}
+	if in.Label != nil {
+		v.Required("label", *in.Label)
+		v.MaxLen("label", *in.Label, 100)
+		f.Label = *in.Label
+	}
+	if in.Options != nil {
+		options := models.StringList{}
+		for _, o := range in.Options {
+			if o = strings.TrimSpace(o); o != "" && !slices.Contains(options, o) {
+				options = append(options, o)
+			}
+		}
+		f.Options = options
+	}
+	if in.Required != nil {
+		f.Required = *in.Required
+	}
+	if in.Min.Sent {
+		f.Min = in.Min.Value
+	}
+	if in.Max.Sent {
+		f.Max = in.Max.Value
+	}
+
+	v.Check(f.Type != "enum" || len(f.Options) > 0, "options", "an enum field needs at least one option")
+	v.Check(f.Type == "enum" || len(f.Options) == 0, "options", "only enum fields take options")
+	bounded := f.Type == "number" || f.Type == "text"
+	v.Check(bounded || f.Min == nil && f.Max == nil, "min", "only number and text fields take min and max")
+	v.Check(f.Min == nil || f.Max == nil || *f.Min <= *f.Max, "max", "must not be below min")
+	v.Check(f.Type != "text" || f.Min == nil || *f.Min >= 0, "min", "must not be negative")
+}
+
+// POST /api/admin/custom-fields   (super only)
+// Body: { "entity": "associations", "key": "gate_code", "label": "Gate code", "type": "text",
+// "options": ["..."], "required": false, "min": 0, "max": 20 }
+// options apply to enum fields; min and max bound numbers and text length.
+func CreateCustomField(c *fiber.Ctx) error {
+	var in customFieldInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	for _, f := range []**string{&in.Entity, &in.Key, &in.Type, &in.Label} {
+		if *f == nil {
+			*f = new(string)
+		}
+	}
+	who, _ := middleware.CurrentUser(c)
+	f := models.CustomField{CreatedBy: who}
+	var v validate.Validator
+	in.validate(&v, &f)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if err := db.DB.Create(&f).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(f)
+}
+
+// PUT /api/admin/custom-fields/:id   (super only)
+// Header: If-Match: "<version>"
+// Body (any subset): { "label": "...", "options": [...], "required": true, "min": 0, "max": 20 }
+// A null min or max removes that bound. New rules apply to values written
+// from now on; stored values are kept.
+func UpdateCustomField(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in customFieldInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var f models.CustomField
+	if err := db.DB.First(&f, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Custom field not found")
+	}
+
+	var v validate.Validator
+	v.Check(in.Entity == nil || *in.Entity == f.Entity, "entity", "cannot be changed")
+	v.Check(in.Key == nil || *in.Key == f.Key, "key", "cannot be changed")
+	v.Check(in.Type == nil || *in.Type == f.Type, "type", "cannot be changed")
+	in.validate(&v, &f)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	updates := map[string]any{}
+	if in.Label != nil {
+		updates["label"] = f.Label
+	}
+	if in.Options != nil {
+		updates["options"] = f.Options
+	}
+	if in.Required != nil {
+		updates["required"] = f.Required
+	}
+	if in.Min.Sent {
+		updates["min"] = f.Min
+	}
+	if in.Max.Sent {
+		updates["max"] = f.Max
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.CustomField{}, id, version, updates)
+}
+
+// DELETE /api/admin/custom-fields/:id   (super only)
+// Removes the field and its value from every record, trashed ones included,
+// and announces the change of each live record that had a value.
+func DeleteCustomField(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var f models.CustomField
+	if err := db.DB.First(&f, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Custom field not found")
+	}
+	who, _ := middleware.CurrentUser(c)
+	entity := strings.TrimSuffix(f.Entity, "s")
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var changed []string
+		err := tx.Table(f.Entity).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("custom->? IS NOT NULL AND deleted_at IS NULL", f.Key).Order("id").Pluck("id", &changed).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Table(f.Entity).Where("custom->? IS NOT NULL", f.Key).
+			Updates(map[string]any{"custom": gorm.Expr("custom - ?::text", f.Key), "version": nextVersion}).Error
+		if err != nil {
+			return err
+		}
+		for _, id := range changed {
+			if err := emit(tx, entity+".updated", id, who); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&f).Error
+	})
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
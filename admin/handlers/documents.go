@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/storage"
+	"admin/validate"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// documentTypes are the kinds of association documents.
+var documentTypes = []string{"governing", "budget", "minutes", "financial", "insurance", "contract", "other"}
+
+// maxDocumentSize bounds one upload; main raises the body limit to fit it.
+const maxDocumentSize = 25 << 20
+
+// newestFirst orders preloaded document versions.
+func newestFirst(tx *gorm.DB) *gorm.DB { return tx.Order("number desc") }
+
+// loadDocument loads document id with its versions and checks the caller's
+// scope covers its association.
+func loadDocument(c *fiber.Ctx, id string) (models.Document, error) {
+	var d models.Document
+	if err := validate.ID("id", id); err != nil {
+		return d, validationError(err)
+	}
+	if err := db.DB.Preload("Versions", newestFirst).First(&d, "id = ?", id).Error; err != nil {
+		return d, problem.New(http.StatusNotFound, "Document not found")
+	}
+	return d, requireAssociation(c, d.AssociationID)
+}
+
+// storeUpload streams the form's file to storage under a fresh key and
+// describes it as a version; nothing is written to the database. The caller
+// removes the blob if the version is not saved.
+func storeUpload(c *fiber.Ctx, fh *multipart.FileHeader, associationID string) (models.DocumentVersion, error) {
+	var v models.DocumentVersion
+	if fh.Size > maxDocumentSize {
+		return v, problem.New(http.StatusRequestEntityTooLarge, "File exceeds "+strconv.Itoa(maxDocumentSize>>20)+" MB")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return v, problem.New(http.StatusBadRequest, "Could not read file")
+	}
+	defer f.Close()
+
+	name := filepath.Base(strings.ReplaceAll(fh.Filename, `\`, "/"))
+	ctype := fh.Header.Get(fiber.HeaderContentType)
+	if ctype == "" || ctype == fiber.MIMEOctetStream {
+		ctype = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if ctype == "" {
+		ctype = fiber.MIMEOctetStream
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return v, err
+	}
+	v = models.DocumentVersion{
+		FileName: name, ContentType: ctype, Size: fh.Size,
+		StorageKey: "documents/" + associationID + "/" + hex.EncodeToString(random),
+	}
+
+	sum := sha256.New()
+	if err := storage.Blobs.Put(c.Context(), v.StorageKey, io.TeeReader(f, sum), fh.Size, ctype); err != nil {
+		log.Println("Document upload failed:", err)
+		return v, problem.New(http.StatusBadGateway, "Could not store the file")
+	}
+	v.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return v, nil
+}
+
+// removeBlobs deletes stored files whose rows are gone, logging failures:
+// an orphaned blob costs space but loses nothing.
+func removeBlobs(keys ...string) {
+	for _, k := range keys {
+		if err := storage.Blobs.Delete(context.Background(), k); err != nil {
+			log.Println("Failed to remove document blob", k+":", err)
+		}
+	}
+}
+
+// GET /api/admin/data/associations/:id/documents?type=minutes&q=annual
+// The association's documents, most recently effective first, each with
+// its versions, newest first.
+func ListDocuments(c *fiber.Ctx) error {
+	id := c.Params("id")
+	typ := strings.ToLower(strings.TrimSpace(c.Query("type")))
+	var v validate.Validator
+	v.UUID("id", id)
+	if typ != "" {
+		v.OneOf("type", typ, documentTypes...)
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if err := requireAssociation(c, id); err != nil {
+		return err
+	}
+
+	tx := db.DB.Preload("Versions", newestFirst).Where("association_id = ?", id)
+	if typ != "" {
+		tx = tx.Where("type = ?", typ)
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		tx = tx.Where("title ILIKE ?", "%"+q+"%")
+	}
+	var list []models.Document
+	if err := tx.Order("effective_date desc nulls last").Order("title asc").Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load documents")
+	}
+	return c.JSON(list)
+}
+
+// POST /api/admin/data/associations/:id/documents   (multipart/form-data)
+// Fields: file, title, type, effectiveDate (YYYY-MM-DD), note. The file
+// becomes version 1; title defaults to the file name.
+func CreateDocument(c *fiber.Ctx) error {
+	associationID := c.Params("id")
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "File required")
+	}
+	title := strings.TrimSpace(c.FormValue("title"))
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(fh.Filename), filepath.Ext(fh.Filename))
+	}
+	d := models.Document{AssociationID: associationID, Title: title, Type: strings.ToLower(strings.TrimSpace(c.FormValue("type")))}
+	note := strings.TrimSpace(c.FormValue("note"))
+
+	var v validate.Validator
+	v.UUID("id", associationID)
+	v.Required("title", d.Title)
+	v.MaxLen("title", d.Title, 200)
+	v.Required("type", d.Type)
+	v.OneOf("type", d.Type, documentTypes...)
+	if s := strings.TrimSpace(c.FormValue("effectiveDate")); s != "" {
+		v.Date("effectiveDate", s)
+		if t, err := time.Parse(time.DateOnly, s); err == nil {
+			d.EffectiveDate = &t
+		}
+	}
+	v.MaxLen("note", note, 500)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	var a models.Association
+	if err := db.DB.First(&a, "id = ?", associationID).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Association not found")
+	}
+	if err := requireAssociation(c, associationID); err != nil {
+		return err
+	}
+
+	ver, err := storeUpload(c, fh, associationID)
+	if err != nil {
+		return err
+	}
+	who, _ := middleware.CurrentUser(c)
+	d.CreatedBy = who
+	ver.Number, ver.Note, ver.UploadedBy = 1, note, who
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Association", "Versions").Create(&d).Error; err != nil {
+			return err
+		}
+		ver.DocumentID = d.ID
+		return tx.Omit("Document").Create(&ver).Error
+	})
+	if err != nil {
+		removeBlobs(ver.StorageKey)
+		return dbError(err, "Upload failed")
+	}
+	d.Versions = []models.DocumentVersion{ver}
+	return c.Status(http.StatusCreated).JSON(d)
+}
+
+// GET /api/admin/data/documents/:id
+// The document with its versions, newest first.
+func GetDocument(c *fiber.Ctx) error {
+	d, err := loadDocument(c, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	setETag(c, &d)
+	return c.JSON(d)
+}
+
+// PUT /api/admin/data/documents/:id
+// Header: If-Match: "<version>"
+// Body (any subset): { "title": "...", "type": "minutes", "effectiveDate": "2025-03-01" };
+// an empty effectiveDate clears it. Upload a new file as a version instead.
+func UpdateDocument(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in struct {
+		Title         *string `json:"title"`
+		Type          *string `json:"type"`
+		EffectiveDate *string `json:"effectiveDate"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	trimAll(in.Title, in.Type, in.EffectiveDate)
+	d, err := loadDocument(c, id)
+	if err != nil {
+		return err
+	}
+
+	var v validate.Validator
+	updates := map[string]any{}
+	if in.Title != nil {
+		v.Required("title", *in.Title)
+		v.MaxLen("title", *in.Title, 200)
+		updates["title"] = *in.Title
+	}
+	if in.Type != nil {
+		*in.Type = strings.ToLower(*in.Type)
+		v.Required("type", *in.Type)
+		v.OneOf("type", *in.Type, documentTypes...)
+		updates["type"] = *in.Type
+	}
+	optionalDate(&v, "effectiveDate", in.EffectiveDate, &d.EffectiveDate)
+	if in.EffectiveDate != nil {
+		updates["effective_date"] = d.EffectiveDate
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB.Preload("Versions", newestFirst), &models.Document{}, id, version, updates)
+}
+
+// POST /api/admin/data/documents/:id/versions   (multipart/form-data)
+// Fields: file, note. The file becomes the document's current version;
+// earlier versions stay downloadable.
+func CreateDocumentVersion(c *fiber.Ctx) error {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "File required")
+	}
+	note := strings.TrimSpace(c.FormValue("note"))
+	if len(note) > 500 {
+		return validationError(validate.Errors{{Field: "note", Message: "must be at most 500 characters"}})
+	}
+	d, err := loadDocument(c, c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	ver, err := storeUpload(c, fh, d.AssociationID)
+	if err != nil {
+		return err
+	}
+	who, _ := middleware.CurrentUser(c)
+	ver.DocumentID, ver.Note, ver.UploadedBy = d.ID, note, who
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		// Bumping the document first locks it, so concurrent uploads number in turn.
+		res := tx.Model(&models.Document{}).Where("id = ?", d.ID).Updates(map[string]any{"version": nextVersion})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err := tx.Model(&models.DocumentVersion{}).Where("document_id = ?", d.ID).
+			Select("coalesce(max(number), 0) + 1").Scan(&ver.Number).Error; err != nil {
+			return err
+		}
+		return tx.Omit("Document").Create(&ver).Error
+	})
+	if err != nil {
+		removeBlobs(ver.StorageKey)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return problem.New(http.StatusNotFound, "Document not found")
+		}
+		return dbError(err, "Upload failed")
+	}
+	return c.Status(http.StatusCreated).JSON(ver)
+}
+
+// GET /api/admin/data/documents/:id/download?version=2
+// Streams the file of a version, the current one by default.
+func DownloadDocument(c *fiber.Ctx) error {
+	d, err := loadDocument(c, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if len(d.Versions) == 0 {
+		return problem.New(http.StatusNotFound, "Document has no file")
+	}
+	ver := d.Versions[0]
+	if n := c.Query("version"); n != "" {
+		number, err := strconv.Atoi(n)
+		if err != nil || number < 1 {
+			return validationError(validate.Errors{{Field: "version", Message: "must be a positive integer"}})
+		}
+		found := false
+		for _, v := range d.Versions {
+			if v.Number == number {
+				ver, found = v, true
+			}
+		}
+		if !found {
+			return problem.New(http.StatusNotFound, "Version not found")
+		}
+	}
+
+	r, err := storage.Blobs.Get(c.Context(), ver.StorageKey)
+	if err != nil {
+		log.Println("Document download failed:", err)
+		if errors.Is(err, storage.ErrNotFound) {
+			return problem.New(http.StatusNotFound, "File is missing from storage")
+		}
+		return problem.New(http.StatusBadGateway, "Could not read the file")
+	}
+	c.Set(fiber.HeaderContentType, ver.ContentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": ver.FileName}))
+	return c.SendStream(r, int(ver.Size))
+}
+
+// DELETE /api/admin/data/documents/:id
+// Deletes the document and every version, files included.
+func DeleteDocument(c *fiber.Ctx) error {
+	d, err := loadDocument(c, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if err := db.DB.Delete(&models.Document{}, "id = ?", d.ID).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	keys := make([]string, len(d.Versions))
+	for i, v := range d.Versions {
+		keys[i] = v.StorageKey
+	}
+	removeBlobs(keys...)
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
@@ -0,0 +1,495 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Duplicate candidates are scored by the similarity of their names, compared
+// as db dedupe_name has them, plus a bonus for each other sign they are the
+// same: an email in common, and for associations the same street address.
+const (
+	sameEmailBonus   = 0.3
+	sameAddressBonus = 0.3
+)
+
+// sameAddress is the SQL condition that associations a and b share a street
+// address, as db dedupe_street has them, in the same ZIP or city. The
+// equality join uses the index on dedupe_street.
+const sameAddress = `dedupe_street(a.street) <> '' AND dedupe_street(a.street) = dedupe_street(b.street)
+	AND ((a.zip <> '' AND a.zip = b.zip) OR (a.city <> '' AND lower(a.city) = lower(b.city)))`
+
+// sameBoardEmail is the SQL condition that associations a and b have a
+// board member email in common.
+const sameBoardEmail = `EXISTS (SELECT 1 FROM board_members x JOIN board_members y ON lower(x.email) = lower(y.email)
+	WHERE x.association_id = a.id AND y.association_id = b.id AND x.email <> '')`
+
+// duplicateQueries find the candidate pairs of each kind, each pair once
+// with the lower id first, with their name similarity and other signs.
+var duplicateQueries = map[string]string{
+	"associations": `WITH pairs AS (
+		SELECT a.id AS a, b.id AS b FROM associations a
+		JOIN associations b ON a.id < b.id AND dedupe_name(a.legal_name) % dedupe_name(b.legal_name)
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+		UNION
+		SELECT a.id, b.id FROM associations a
+		JOIN associations b ON a.id < b.id AND ` + sameAddress + `
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+		UNION
+		SELECT x.association_id, y.association_id FROM board_members x
+		JOIN board_members y ON x.association_id < y.association_id AND lower(x.email) = lower(y.email)
+		WHERE x.email <> ''
+	)
+	SELECT a.id AS a_id, a.legal_name AS a_name, a.location AS a_detail,
+		b.id AS b_id, b.legal_name AS b_name, b.location AS b_detail,
+		similarity(dedupe_name(a.legal_name), dedupe_name(b.legal_name)) AS name_similarity,
+		` + sameBoardEmail + ` AS same_email,
+		(` + sameAddress + `) AS same_address
+	FROM pairs p
+	JOIN associations a ON a.id = p.a AND a.deleted_at IS NULL
+	JOIN associations b ON b.id = p.b AND b.deleted_at IS NULL`,
+
+	"managers": `WITH pairs AS (
+		SELECT a.id AS a, b.id AS b FROM managers a
+		JOIN managers b ON a.id < b.id AND dedupe_name(a.name) % dedupe_name(b.name)
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+		UNION
+		SELECT a.id, b.id FROM managers a
+		JOIN managers b ON a.id < b.id AND lower(a.email) = lower(b.email)
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+	)
+	SELECT a.id AS a_id, a.name AS a_name, a.email AS a_detail,
+		b.id AS b_id, b.name AS b_name, b.email AS b_detail,
+		similarity(dedupe_name(a.name), dedupe_name(b.name)) AS name_similarity,
+		lower(a.email) = lower(b.email) AS same_email,
+		false AS same_address
+	FROM pairs p
+	JOIN managers a ON a.id = p.a
+	JOIN managers b ON b.id = p.b`,
+}
+
+// duplicateRecord is one side of a candidate pair. Detail is the
+// association's location label or the manager's email.
+type duplicateRecord struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+type duplicateCandidate struct {
+	A              duplicateRecord `json:"a"`
+	B              duplicateRecord `json:"b"`
+	Score          float64         `json:"score"`
+	NameSimilarity float64         `json:"nameSimilarity"`
+	SameEmail      bool            `json:"sameEmail"`
+	SameAddress    bool            `json:"sameAddress"`
+}
+
+// GET /api/admin/data/duplicates?kind=associations&minScore=0.6&limit=50
+// Pairs of live associations (or managers, kind=managers) that may be the
+// same record, best first. A pair is a candidate when the names are alike
+// ignoring words such as "Condo" and "Assoc.", when the two share an email
+// (a board member's, for associations), or when two associations share a
+// street address. Score is the name similarity from 0 to 1 plus 0.3 for
+// each shared email or address, at most 1. Review a pair, then merge it
+// with MergeRecords.
+func ListDuplicates(c *fiber.Ctx) error {
+	kind := c.Query("kind", "associations")
+	minScore, scoreErr := strconv.ParseFloat(c.Query("minScore", "0.6"), 64)
+	limit := c.QueryInt("limit", 50)
+	var v validate.Validator
+	v.OneOf("kind", kind, "associations", "managers")
+	v.Check(scoreErr == nil && minScore >= 0 && minScore <= 1, "minScore", "must be a number from 0 to 1")
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var rows []struct {
+		AID, AName, ADetail    string
+		BID, BName, BDetail    string
+		NameSimilarity         float64
+		SameEmail, SameAddress bool
+		Score                  float64
+	}
+	err := db.DB.Raw(`SELECT * FROM (SELECT c.*, least(1, name_similarity
+			+ CASE WHEN same_email THEN ? ELSE 0 END
+			+ CASE WHEN same_address THEN ? ELSE 0 END) AS score
+		FROM (`+duplicateQueries[kind]+`) c) s
+		WHERE score >= ?
+		ORDER BY score DESC, a_name, b_name
+		LIMIT ?`, sameEmailBonus, sameAddressBonus, minScore, limit).
+		Scan(&rows).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to find duplicates")
+	}
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	list := make([]duplicateCandidate, len(rows))
+	for i, r := range rows {
+		list[i] = duplicateCandidate{
+			A:              duplicateRecord{r.AID, r.AName, r.ADetail},
+			B:              duplicateRecord{r.BID, r.BName, r.BDetail},
+			Score:          round(r.Score),
+			NameSimilarity: round(r.NameSimilarity),
+			SameEmail:      r.SameEmail,
+			SameAddress:    r.SameAddress,
+		}
+	}
+	return c.JSON(fiber.Map{"kind": kind, "candidates": list})
+}
+
+// mergeFields are the fields a merge picks between the two records, by kind,
+// each with the model field it sets. Custom values are combined instead.
+var mergeFields = map[string]map[string]string{
+	"associations": {
+		"legalName": "LegalName", "filterName": "FilterName", "location": "Location", "managerId": "ManagerID",
+		"street": "Street", "city": "City", "county": "County", "state": "State", "zip": "ZIP",
+		"type": "Type", "unitCount": "UnitCount", "fiscalYearEnd": "FiscalYearEnd",
+		"stateCorpNumber": "StateCorpNumber", "ein": "EIN", "incorporationDate": "IncorporationDate",
+	},
+	"managers": {"email": "Email", "name": "Name", "titles": "Titles", "initials": "Initials"},
+}
+
+// addressFields are the association fields geocode reads.
+var addressFields = []string{"location", "street", "city", "county", "state", "zip"}
+
+// errDryRun rolls back a merge that was only previewed.
+var errDryRun = errors.New("dry run")
+
+type mergeReport struct {
+	DryRun     bool              `json:"dryRun"`
+	MergeID    string            `json:"mergeId,omitempty"`
+	Kind       string            `json:"kind"`
+	SurvivorID string            `json:"survivorId"`
+	MergedID   string            `json:"mergedId"`
+	Fields     map[string]string `json:"fields"`
+	Repointed  map[string]int64  `json:"repointed"`
+	// Survivor is the survivor as the merge leaves it.
+	Survivor any `json:"survivor"`
+}
+
+// POST /api/admin/data/merges?dryRun=false   (super only)
+// Body: { "kind": "associations", "survivorId": "uuid", "mergedId": "uuid",
+// "fields": { "<field>": "survivor" | "merged" }, "survivorVersion": 3, "mergedVersion": 1, "reason": "..." }
+// Folds mergedId into survivorId: the survivor takes the merged record's
+// value of each field picked "merged", and of each field not picked that it
+// lacks itself; it keeps its own otherwise. Custom values are combined, the
+// survivor's winning. Everything that pointed at the merged record moves to
+// the survivor: for associations its units, board, documents, contracts,
+// tags, grants and assignment history (its current primary manager
+// assignment ends today); for managers their associations and assignment
+// history. The merged record then goes to the trash. Versions, when given,
+// must still be current (412). Unless dryRun=false only the preview is
+// returned. Applying records the merge (see ListMerges) atomically.
+func MergeRecords(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dryRun", true)
+	var in struct {
+		Kind            string            `json:"kind"`
+		SurvivorID      string            `json:"survivorId"`
+		MergedID        string            `json:"mergedId"`
+		Fields          map[string]string `json:"fields"`
+		SurvivorVersion int               `json:"survivorVersion"`
+		MergedVersion   int               `json:"mergedVersion"`
+		Reason          string            `json:"reason"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+
+	var v validate.Validator
+	v.OneOf("kind", in.Kind, "associations", "managers")
+	v.UUID("survivorId", in.SurvivorID)
+	v.UUID("mergedId", in.MergedID)
+	v.Check(in.SurvivorID != in.MergedID, "mergedId", "must differ from survivorId")
+	v.Required("reason", in.Reason)
+	v.MaxLen("reason", in.Reason, 500)
+	for field, pick := range in.Fields {
+		if _, ok := mergeFields[in.Kind][field]; !ok && !v.Has("kind") {
+			v.Add("fields."+field, "is not a field of "+in.Kind)
+		}
+		v.OneOf("fields."+field, pick, "survivor", "merged")
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	entity := strings.TrimSuffix(in.Kind, "s")
+	who, _ := middleware.CurrentUser(c)
+	report := mergeReport{DryRun: dryRun, Kind: in.Kind, SurvivorID: in.SurvivorID, MergedID: in.MergedID,
+		Fields: map[string]string{}, Repointed: map[string]int64{}}
+	err := dbOf(c).Transaction(func(tx *gorm.DB) error {
+		survivor, _ := trashModel(in.Kind)
+		merged, _ := trashModel(in.Kind)
+		// Lock both in id order, so two merges of the same pair cannot deadlock.
+		var locked []string
+		err := tx.Model(survivor).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []string{in.SurvivorID, in.MergedID}).Order("id").Pluck("id", &locked).Error
+		if err != nil {
+			return err
+		}
+		for _, r := range []struct {
+			model   any
+			id      string
+			version int
+			field   string
+		}{{survivor, in.SurvivorID, in.SurvivorVersion, "survivorId"}, {merged, in.MergedID, in.MergedVersion, "mergedId"}} {
+			if err := tx.First(r.model, "id = ?", r.id).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return problem.New(http.StatusNotFound, strings.ToUpper(entity[:1])+entity[1:]+" not found").With("field", r.field)
+				}
+				return err
+			}
+			if r.version > 0 && rowVersion(r.model) != r.version {
+				return problem.New(http.StatusPreconditionFailed, "Modified since your copy was loaded; review the merge again").
+					With("field", r.field).With("current", r.model)
+			}
+		}
+
+		audit := models.Merge{Kind: in.Kind, SurvivorID: in.SurvivorID, MergedID: in.MergedID, Reason: in.Reason, MergedBy: who}
+		audit.Survivor, audit.Merged = mergeSnapshot(survivor), mergeSnapshot(merged)
+
+		s, m := reflect.ValueOf(survivor).Elem(), reflect.ValueOf(merged).Elem()
+		updates := map[string]any{"version": nextVersion}
+		for field, name := range mergeFields[in.Kind] {
+			pick := in.Fields[field]
+			if pick == "" {
+				pick = "survivor"
+				if s.FieldByName(name).IsZero() && !m.FieldByName(name).IsZero() {
+					pick = "merged"
+				}
+			}
+			report.Fields[field] = pick
+			if pick == "merged" && !reflect.DeepEqual(s.FieldByName(name).Interface(), m.FieldByName(name).Interface()) {
+				updates[name] = m.FieldByName(name).Interface()
+			}
+		}
+		updates["custom"] = gorm.Expr("(SELECT custom FROM "+in.Kind+" WHERE id = ?) || custom", in.MergedID)
+
+		var moved []string
+		if in.Kind == "associations" {
+			err = repointAssociation(tx, in.SurvivorID, in.MergedID, in.Reason, report.Repointed)
+		} else {
+			moved, err = repointManager(tx, in.SurvivorID, in.MergedID, report.Repointed)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The merged record leaves first, so the survivor can take its email.
+		if err := softDelete(tx, merged, in.MergedID, who); err != nil {
+			return err
+		}
+		if err := tx.Model(survivor).Where("id = ?", in.SurvivorID).Updates(updates).Error; err != nil {
+			return err
+		}
+		if in.Kind == "associations" {
+			if _, ok := updates["ManagerID"]; ok {
+				err := assignPrimary(tx, in.SurvivorID, m.FieldByName("ManagerID").String(), "Merged: "+in.Reason, who)
+				if err != nil {
+					return err
+				}
+			}
+			var readdressed bool
+			var explicit []string
+			for _, f := range addressFields {
+				if _, ok := updates[mergeFields[in.Kind][f]]; ok {
+					readdressed = true
+					if slices.Contains(geoFillable, f) {
+						explicit = append(explicit, f)
+					}
+				}
+			}
+			if readdressed {
+				if err := geocodeStored(tx, in.SurvivorID, explicit...); err != nil {
+					return err
+				}
+			}
+		}
+
+		fields, _ := json.Marshal(report.Fields)
+		repointed, _ := json.Marshal(report.Repointed)
+		audit.Fields, audit.Repointed = string(fields), string(repointed)
+		if err := tx.Create(&audit).Error; err != nil {
+			return err
+		}
+		report.MergeID = audit.ID
+
+		if err := emit(tx, entity+".deleted", in.MergedID, who); err != nil {
+			return err
+		}
+		if err := emit(tx, entity+".updated", in.SurvivorID, who); err != nil {
+			return err
+		}
+		for _, id := range moved {
+			if err := emit(tx, "association.updated", id, who); err != nil {
+				return err
+			}
+		}
+
+		result, _ := trashModel(in.Kind)
+		q := tx.Preload("Associations")
+		if in.Kind == "associations" {
+			q = tx.Preload("Manager").Preload("Tags")
+		}
+		if err := q.First(result, "id = ?", in.SurvivorID).Error; err != nil {
+			return err
+		}
+		report.Survivor = result
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		return dbError(err, "Merge failed")
+	}
+	if dryRun {
+		report.MergeID = ""
+	}
+	return c.JSON(report)
+}
+
+// mergeSnapshot is model as JSON for the audit trail, without its EIN.
+func mergeSnapshot(model any) string {
+	snapshot := reflect.New(reflect.TypeOf(model).Elem())
+	snapshot.Elem().Set(reflect.ValueOf(model).Elem())
+	if ein := snapshot.Elem().FieldByName("EIN"); ein.IsValid() {
+		ein.SetString("")
+	}
+	b, _ := json.Marshal(snapshot.Interface())
+	return string(b)
+}
+
+// associationChildren are the tables whose rows belong to one association
+// and simply move with a merge.
+var associationChildren = []string{"units", "board_members", "documents", "vendor_contracts"}
+
+// repointAssociation moves everything of association mergedID to
+// survivorID, counting the rows moved by table. Units numbered alike in
+// both are refused (409), as an association numbers each unit once.
+func repointAssociation(tx *gorm.DB, survivorID, mergedID, reason string, counts map[string]int64) error {
+	var clashes []string
+	err := tx.Model(&models.Unit{}).
+		Where("association_id = ? AND number IN (?)", mergedID,
+			tx.Model(&models.Unit{}).Select("number").Where("association_id = ?", survivorID)).
+		Order("number").Pluck("number", &clashes).Error
+	if err != nil {
+		return err
+	}
+	if len(clashes) > 0 {
+		return problem.New(http.StatusConflict, "Both associations have units numbered alike; renumber or remove them first").
+			With("units", clashes)
+	}
+
+	for _, table := range associationChildren {
+		res := tx.Exec("UPDATE "+table+" SET association_id = ?, version = version + 1 WHERE association_id = ?", survivorID, mergedID)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts[table] = res.RowsAffected
+	}
+
+	// The merged association's current primary ends today; its history,
+	// and its assistants, join the survivor's.
+	err = tx.Model(&models.ManagerAssignment{}).Where(openPrimary, mergedID).
+		Updates(map[string]any{"effective_to": today(), "end_reason": "Merged: " + reason, "version": nextVersion}).Error
+	if err != nil {
+		return err
+	}
+	res := tx.Model(&models.ManagerAssignment{}).Where("association_id = ?", mergedID).
+		Updates(map[string]any{"association_id": survivorID, "version": nextVersion})
+	if res.Error != nil {
+		return res.Error
+	}
+	counts["manager_assignments"] = res.RowsAffected
+
+	// Tags and grants are sets: the survivor gains those it lacks.
+	for _, link := range []struct{ table, key, by string }{
+		{"association_tags", "tag_id", "tagged_by"},
+		{"association_grants", "user_id", "granted_by"},
+	} {
+		res := tx.Exec("INSERT INTO "+link.table+" (association_id, "+link.key+", "+link.by+", created_at) "+
+			"SELECT ?, "+link.key+", "+link.by+", created_at FROM "+link.table+" WHERE association_id = ? ON CONFLICT DO NOTHING",
+			survivorID, mergedID)
+		if res.Error != nil {
+			return res.Error
+		}
+		counts[link.table] = res.RowsAffected
+		if err := tx.Exec("DELETE FROM "+link.table+" WHERE association_id = ?", mergedID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repointManager moves the associations and assignment history of manager
+// mergedID to survivorID, counting the rows moved by table. It returns the
+// live associations moved, whose change is announced.
+func repointManager(tx *gorm.DB, survivorID, mergedID string, counts map[string]int64) ([]string, error) {
+	var moved []string
+	if err := tx.Model(&models.Association{}).Where("manager_id = ?", mergedID).Order("id").Pluck("id", &moved).Error; err != nil {
+		return nil, err
+	}
+	// Trashed associations move too, so the merged manager can be purged.
+	res := tx.Unscoped().Model(&models.Association{}).Where("manager_id = ?", mergedID).
+		Updates(map[string]any{"manager_id": survivorID, "version": nextVersion})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	counts["associations"] = res.RowsAffected
+
+	res = tx.Model(&models.ManagerAssignment{}).Where("manager_id = ?", mergedID).
+		Updates(map[string]any{"manager_id": survivorID, "version": nextVersion})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	counts["manager_assignments"] = res.RowsAffected
+	sort.Strings(moved)
+	return moved, nil
+}
+
+// GET /api/admin/data/merges?kind=associations&limit=50
+// The audit trail of merges, newest first: which record survived, the field
+// picks, both records as they were, and the rows moved.
+func ListMerges(c *fiber.Ctx) error {
+	kind := c.Query("kind")
+	limit := c.QueryInt("limit", 50)
+	var v validate.Validator
+	if kind != "" {
+		v.OneOf("kind", kind, "associations", "managers")
+	}
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	q := db.DB.Order("created_at desc").Limit(limit)
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var list []models.Merge
+	if err := q.Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load merges")
+	}
+	return c.JSON(list)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"admin/problem"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// nextVersion is the update expression every write to a versioned row uses.
+var nextVersion = gorm.Expr("version + 1")
+
+// rowVersion reads the Version field of a loaded model.
+func rowVersion(model any) int {
+	return int(reflect.Indirect(reflect.ValueOf(model)).FieldByName("Version").Int())
+}
+
+// setETag sets the ETag header to the row's version.
+func setETag(c *fiber.Ctx, model any) {
+	c.Set(fiber.HeaderETag, `"`+strconv.Itoa(rowVersion(model))+`"`)
+}
+
+// ifMatch returns the version named by the If-Match header. "*" matches any
+// version and yields 0. Updates refuse to run without the header (428) so a
+// client cannot overwrite a change it never saw.
+func ifMatch(c *fiber.Ctx) (int, error) {
+	h := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	switch h {
+	case "":
+		return 0, problem.New(http.StatusPreconditionRequired, "If-Match header required; send the ETag from the last GET")
+	case "*":
+		return 0, nil
+	}
+	v, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(h, "W/"), `"`))
+	if err != nil || v < 1 {
+		return 0, problem.New(http.StatusBadRequest, "If-Match must be an ETag returned by this API")
+	}
+	return v, nil
+}
+
+// versionedUpdate applies updates to the live row id of model's type only if
+// it is still at version (0 skips the check), bumping the version. Each of
+// also then runs in the same transaction, e.g. to record history, and only
+// when the row was written. query loads the row for the response, e.g. with
+// its preloads. On success the new ETag is set; if someone else wrote first
+// the 412 carries their version of the row under "current".
+func versionedUpdate(c *fiber.Ctx, query *gorm.DB, model any, id string, version int, updates map[string]any, also ...func(tx *gorm.DB) error) error {
+	updates["version"] = nextVersion
+	var written bool
+	err := dbOf(c).Transaction(func(tx *gorm.DB) error {
+		q := tx.Model(model).Where("id = ?", id)
+		if version > 0 {
+			q = q.Where("version = ?", version)
+		}
+		res := q.Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		written = true
+		for _, f := range also {
+			if err := f(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		return dbError(err, "Update failed")
+	}
+
+	if err := query.First(model, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return problem.New(http.StatusNotFound, "Not found")
+		}
+		return problem.New(http.StatusInternalServerError, "Update failed")
+	}
+	setETag(c, model)
+	if !written {
+		return problem.New(http.StatusPreconditionFailed, "Modified since your copy was loaded; reapply your changes to the current version").
+			With("current", model)
+	}
+	return c.JSON(fiber.Map{"message": "Updated"})
+}
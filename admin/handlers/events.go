@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"admin/models"
+	"admin/outbox"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// emit records event typ (e.g. "association.updated") for id in tx. The
+// payload is the entity as it now stands in tx, trashed rows included, and
+// without secrets: no association EIN or user password hash.
+func emit(tx *gorm.DB, typ, id, actor string) error {
+	var data any
+	var err error
+	switch entity, _, _ := strings.Cut(typ, "."); entity {
+	case "association":
+		var a models.Association
+		err = tx.Unscoped().First(&a, "id = ?", id).Error
+		a.EIN = ""
+		data = a
+	case "manager":
+		var m models.Manager
+		err = tx.Unscoped().First(&m, "id = ?", id).Error
+		data = m
+	case "user":
+		var u models.User
+		err = tx.Unscoped().Select("id", "username", "role", "deleted_at", "deleted_by", "version").First(&u, "id = ?", id).Error
+		data = u
+	}
+	if err != nil {
+		return err
+	}
+	return outbox.Record(tx, typ, id, actor, data)
+}
+
+// emitting is emit as a versionedUpdate hook.
+func emitting(typ, id, actor string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error { return emit(tx, typ, id, actor) }
+}
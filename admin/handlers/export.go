@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"admin/models"
+	"admin/problem"
+	"admin/tabular"
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize bounds how many rows an export holds in memory at once.
+const exportBatchSize = 500
+
+type exportColumn[T any] struct {
+	name  string
+	value func(*T) any
+}
+
+func deletedAt(d gorm.DeletedAt) any {
+	if !d.Valid {
+		return nil
+	}
+	return d.Time
+}
+
+// tagNames is a comma-separated list of the tags' names.
+func tagNames(tags []models.Tag) any {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// customJSON is custom field values as one JSON cell.
+func customJSON(v models.CustomValues) any {
+	s, _ := v.Value()
+	return s
+}
+
+// coordinate is a latitude or longitude, or nil when unknown.
+func coordinate(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+func dateOnly(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.DateOnly)
+}
+
+// associationExportColumns leaves out the EIN, which stays encrypted outside
+// the API.
+var associationExportColumns = []exportColumn[models.Association]{
+	{"id", func(a *models.Association) any { return a.ID }},
+	{"legalName", func(a *models.Association) any { return a.LegalName }},
+	{"filterName", func(a *models.Association) any { return a.FilterName }},
+	{"location", func(a *models.Association) any { return a.Location }},
+	{"managerId", func(a *models.Association) any { return a.ManagerID }},
+	{"managerName", func(a *models.Association) any { return a.Manager.Name }},
+	{"managerEmail", func(a *models.Association) any { return a.Manager.Email }},
+	{"street", func(a *models.Association) any { return a.Street }},
+	{"city", func(a *models.Association) any { return a.City }},
+	{"county", func(a *models.Association) any { return a.County }},
+	{"state", func(a *models.Association) any { return a.State }},
+	{"zip", func(a *models.Association) any { return a.ZIP }},
+	{"type", func(a *models.Association) any { return a.Type }},
+	{"unitCount", func(a *models.Association) any { return a.UnitCount }},
+	{"fiscalYearEnd", func(a *models.Association) any { return a.FiscalYearEnd }},
+	{"stateCorpNumber", func(a *models.Association) any { return a.StateCorpNumber }},
+	{"incorporationDate", func(a *models.Association) any { return dateOnly(a.IncorporationDate) }},
+	{"latitude", func(a *models.Association) any { return coordinate(a.Latitude) }},
+	{"longitude", func(a *models.Association) any { return coordinate(a.Longitude) }},
+	{"geoPrecision", func(a *models.Association) any { return a.GeoPrecision }},
+	{"tags", func(a *models.Association) any { return tagNames(a.Tags) }},
+	{"custom", func(a *models.Association) any { return customJSON(a.Custom) }},
+	{"deletedAt", func(a *models.Association) any { return deletedAt(a.DeletedAt) }},
+	{"deletedBy", func(a *models.Association) any { return a.DeletedBy }},
+}
+
+var managerExportColumns = []exportColumn[models.Manager]{
+	{"id", func(m *models.Manager) any { return m.ID }},
+	{"name", func(m *models.Manager) any { return m.Name }},
+	{"email", func(m *models.Manager) any { return m.Email }},
+	{"titles", func(m *models.Manager) any { return m.Titles }},
+	{"initials", func(m *models.Manager) any { return m.Initials }},
+	{"associationCount", func(m *models.Manager) any { return len(m.Associations) }},
+	{"custom", func(m *models.Manager) any { return customJSON(m.Custom) }},
+	{"deletedAt", func(m *models.Manager) any { return deletedAt(m.DeletedAt) }},
+	{"deletedBy", func(m *models.Manager) any { return m.DeletedBy }},
+}
+
+var userExportColumns = []exportColumn[models.User]{
+	{"id", func(u *models.User) any { return u.ID }},
+	{"username", func(u *models.User) any { return u.Username }},
+	{"role", func(u *models.User) any { return u.Role }},
+}
+
+// GET /api/admin/data/associations/export?format=csv&columns=legalName,managerName&q=...
+func ExportAssociations(c *fiber.Ctx) error {
+	tx, err := associationQuery(c)
+	if err != nil {
+		return err
+	}
+	return streamExport(c, "associations", tx, associationExportColumns)
+}
+
+// GET /api/admin/data/managers/export?format=xlsx&q=...
+func ExportManagers(c *fiber.Ctx) error {
+	tx, err := managerQuery(c)
+	if err != nil {
+		return err
+	}
+	return streamExport(c, "managers", tx, managerExportColumns)
+}
+
+// GET /api/admin/users/export?format=json
+func ExportUsers(c *fiber.Ctx) error {
+	return streamExport(c, "users", userQuery(), userExportColumns)
+}
+
+// streamExport writes query's rows in the requested format (csv, xlsx or json;
+// default csv) restricted to the requested columns (default all). Rows are
+// fetched and written in batches after the handler returns, so the response
+// status is committed before the first query runs.
+func streamExport[T any](c *fiber.Ctx, kind string, query *gorm.DB, all []exportColumn[T]) error {
+	format := strings.ToLower(c.Query("format", tabular.CSV))
+	if format != tabular.CSV && format != tabular.XLSX && format != tabular.JSON {
+		return problem.New(http.StatusBadRequest, "format must be csv, xlsx or json")
+	}
+
+	cols := all
+	if want := c.Query("columns"); want != "" {
+		byName := make(map[string]exportColumn[T], len(all))
+		for _, col := range all {
+			byName[col.name] = col
+		}
+		cols = nil
+		for _, name := range strings.Split(want, ",") {
+			col, ok := byName[strings.TrimSpace(name)]
+			if !ok {
+				return problem.New(http.StatusBadRequest, "Unknown column: "+name)
+			}
+			cols = append(cols, col)
+		}
+	}
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.name
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", kind, time.Now().Format("2006-01-02"), format)
+	c.Set(fiber.HeaderContentType, tabular.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	// id breaks ties so offset batches neither skip nor repeat rows.
+	base := query.Order("id asc").Session(&gorm.Session{})
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		w, err := tabular.NewWriter(bw, format, names)
+		if err != nil {
+			log.Println("Export failed:", err)
+			return
+		}
+		values := make([]any, len(cols))
+		for offset := 0; ; offset += exportBatchSize {
+			var batch []T
+			if err := base.Limit(exportBatchSize).Offset(offset).Find(&batch).Error; err != nil {
+				log.Println("Export failed:", err)
+				return
+			}
+			for i := range batch {
+				for j, col := range cols {
+					values[j] = col.value(&batch[i])
+				}
+				if err := w.Write(values); err != nil {
+					log.Println("Export failed:", err)
+					return
+				}
+			}
+			if len(batch) < exportBatchSize {
+				break
+			}
+			bw.Flush()
+		}
+		if err := w.Close(); err != nil {
+			log.Println("Export failed:", err)
+		}
+	})
+	return nil
+}
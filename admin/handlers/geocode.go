@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/geo"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// geoFillable are the address fields geocode may fill in, by column.
+var geoFillable = []string{"city", "county", "state", "zip"}
+
+// geocode places a by its address, falling back on its location label for
+// the parts left empty, e.g. "Tampa, FL". The city, county, state and ZIP
+// the gazetteer confirms fill in those still empty, and are recorded in
+// GeoFilled. Fields a user set are kept; those filled in by an earlier
+// geocode are worked out again, so they follow a changed label. It returns
+// the columns that changed.
+func geocode(a *models.Association) map[string]any {
+	fields := map[string]*string{"city": &a.City, "county": &a.County, "state": &a.State, "zip": &a.ZIP}
+	before := map[string]string{"geo_filled": a.GeoFilled}
+	for _, col := range geoFillable {
+		before[col] = *fields[col]
+	}
+	for _, col := range strings.Split(a.GeoFilled, ",") {
+		if f, ok := fields[col]; ok {
+			*f = ""
+		}
+	}
+
+	label := geo.Parse(a.Location)
+	or := func(v, fallback string) string {
+		if v != "" {
+			return v
+		}
+		return fallback
+	}
+	m, ok := geo.Resolve(geo.Location{
+		City:   or(a.City, label.City),
+		County: or(a.County, label.County),
+		State:  or(a.State, label.State),
+		ZIP:    or(a.ZIP, label.ZIP),
+	})
+
+	found := map[string]string{"city": m.City, "county": m.County, "state": m.State, "zip": m.ZIP}
+	var filled []string
+	for _, col := range geoFillable {
+		if *fields[col] == "" && found[col] != "" {
+			*fields[col] = found[col]
+			filled = append(filled, col)
+		}
+	}
+	a.GeoFilled = strings.Join(filled, ",")
+
+	changed := map[string]any{}
+	for _, col := range geoFillable {
+		if *fields[col] != before[col] {
+			changed[col] = *fields[col]
+		}
+	}
+	if a.GeoFilled != before["geo_filled"] {
+		changed["geo_filled"] = a.GeoFilled
+	}
+
+	var lat, lon *float64
+	if ok {
+		lat, lon = &m.Lat, &m.Lon
+	}
+	if !sameCoordinate(a.Latitude, lat) || !sameCoordinate(a.Longitude, lon) || a.GeoPrecision != m.Precision {
+		a.Latitude, a.Longitude, a.GeoPrecision = lat, lon, m.Precision
+		changed["latitude"], changed["longitude"], changed["geo_precision"] = lat, lon, m.Precision
+	}
+	return changed
+}
+
+func sameCoordinate(a, b *float64) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+// geocodeStored geocodes association id as stored in tx and writes back
+// what changed; for writes that set the address column by column. explicit
+// are the columns the write set, which no longer count as filled in.
+func geocodeStored(tx *gorm.DB, id string, explicit ...string) error {
+	var a models.Association
+	if err := tx.First(&a, "id = ?", id).Error; err != nil {
+		return err
+	}
+	stored := a.GeoFilled
+	var kept []string
+	for _, col := range strings.Split(a.GeoFilled, ",") {
+		if col != "" && !slices.Contains(explicit, col) {
+			kept = append(kept, col)
+		}
+	}
+	a.GeoFilled = strings.Join(kept, ",")
+	changed := geocode(&a)
+	if a.GeoFilled != stored {
+		changed["geo_filled"] = a.GeoFilled
+	}
+	if len(changed) > 0 {
+		return tx.Model(&models.Association{}).Where("id = ?", id).Updates(changed).Error
+	}
+	return nil
+}
+
+// geocodingStored is geocodeStored for versionedUpdate's also.
+func geocodingStored(id string, explicit ...string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error { return geocodeStored(tx, id, explicit...) }
+}
+
+// bboxFilter restricts tx to associations placed within bbox,
+// "west,south,east,north" in degrees.
+func bboxFilter(tx *gorm.DB, bbox string) (*gorm.DB, error) {
+	parts := strings.Split(bbox, ",")
+	var box [4]float64
+	ok := len(parts) == 4
+	for i := 0; ok && i < 4; i++ {
+		var err error
+		box[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+		ok = err == nil
+	}
+	west, south, east, north := box[0], box[1], box[2], box[3]
+	var v validate.Validator
+	v.Check(ok, "bbox", "must be west,south,east,north in degrees")
+	if ok {
+		v.Check(south >= -90 && north <= 90 && south <= north, "bbox", "south and north must be latitudes, south first")
+		v.Check(west >= -180 && east <= 180 && west <= east, "bbox", "west and east must be longitudes, west first")
+	}
+	if err := v.Err(); err != nil {
+		return nil, validationError(err)
+	}
+	return tx.Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", south, north, west, east), nil
+}
+
+// nearbyAssociation is an association and how far it is from the center of
+// a radius search.
+type nearbyAssociation struct {
+	Association models.Association `json:"association"`
+	Distance    float64            `json:"distance"`
+}
+
+// GET /api/admin/data/associations/nearby?near=Tampa, FL&radius=20&unit=mi&limit=100
+// Also ?lat=27.95&lon=-82.46 or ?associationId=uuid instead of near.
+// Associations within radius (default 20) miles, or kilometers with
+// unit=km, of the center, nearest first, with their distance in that unit.
+// near is a city ("Tampa", "Brandon, FL") or ZIP placed with the gazetteer.
+// Associations that could not be placed are never included; see
+// GeocodeAssociations.
+func NearbyAssociations(c *fiber.Ctx) error {
+	near := strings.TrimSpace(c.Query("near"))
+	associationID := strings.TrimSpace(c.Query("associationId"))
+	unit := c.Query("unit", "mi")
+	radius, radiusErr := strconv.ParseFloat(c.Query("radius", "20"), 64)
+	limit := c.QueryInt("limit", 100)
+
+	var v validate.Validator
+	v.OneOf("unit", unit, "mi", "km")
+	v.Check(radiusErr == nil && radius > 0 && radius <= 500, "radius", "must be a number above 0, at most 500")
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	given := 0
+	for _, s := range []string{near, associationID, c.Query("lat")} {
+		if s != "" {
+			given++
+		}
+	}
+	v.Check(given == 1, "near", "give exactly one of near, lat and lon, or associationId")
+
+	center := fiber.Map{}
+	var lat, lon float64
+	switch {
+	case v.Has("near"):
+	case near != "":
+		m, ok := geo.Resolve(geo.Parse(near))
+		v.Check(ok, "near", "is not a city or ZIP code in the gazetteer")
+		lat, lon = m.Lat, m.Lon
+		center = fiber.Map{"city": m.City, "county": m.County, "state": m.State, "zip": m.ZIP, "precision": m.Precision}
+	case associationID != "":
+		v.UUID("associationId", associationID)
+		if !v.Has("associationId") {
+			var a models.Association
+			if err := db.DB.First(&a, "id = ?", associationID).Error; err != nil {
+				return problem.New(http.StatusNotFound, "Association not found")
+			}
+			v.Check(a.Latitude != nil && a.Longitude != nil, "associationId", "has no known position")
+			if a.Latitude != nil && a.Longitude != nil {
+				lat, lon = *a.Latitude, *a.Longitude
+			}
+			center = fiber.Map{"associationId": a.ID, "legalName": a.LegalName, "precision": a.GeoPrecision}
+		}
+	default:
+		var err1, err2 error
+		lat, err1 = strconv.ParseFloat(c.Query("lat"), 64)
+		lon, err2 = strconv.ParseFloat(c.Query("lon"), 64)
+		v.Check(err1 == nil && lat >= -90 && lat <= 90, "lat", "must be a latitude in degrees")
+		v.Check(err2 == nil && lon >= -180 && lon <= 180, "lon", "must be a longitude in degrees")
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	meters := radius * geo.MetersPerMile
+	if unit == "km" {
+		meters = radius * 1000
+	}
+	const distance = "earth_distance(ll_to_earth(?, ?), ll_to_earth(latitude, longitude))"
+	var hits []struct {
+		ID       string
+		Distance float64
+	}
+	err := db.DB.Model(&models.Association{}).
+		Select("id, "+distance+" AS distance", lat, lon).
+		Where("latitude IS NOT NULL AND longitude IS NOT NULL").
+		// The box lets the gist index narrow the rows; the distance trims its corners.
+		Where("earth_box(ll_to_earth(?, ?), ?) @> ll_to_earth(latitude, longitude)", lat, lon, meters).
+		Where(distance+" <= ?", lat, lon, meters).
+		Order("distance").Limit(limit).
+		Scan(&hits).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to search nearby associations")
+	}
+
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	var list []models.Association
+	if err := db.DB.Preload("Manager").Preload("Tags").Where("id IN ?", ids).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load associations")
+	}
+	byID := make(map[string]models.Association, len(list))
+	for _, a := range list {
+		byID[a.ID] = a
+	}
+	results := make([]nearbyAssociation, 0, len(hits))
+	for _, h := range hits {
+		d := h.Distance / geo.MetersPerMile
+		if unit == "km" {
+			d = h.Distance / 1000
+		}
+		results = append(results, nearbyAssociation{byID[h.ID], math.Round(d*100) / 100})
+	}
+	center["lat"], center["lon"] = lat, lon
+	return c.JSON(fiber.Map{"center": center, "radius": radius, "unit": unit, "results": results})
+}
+
+// geocodeChange is an association whose position or address GeocodeAssociations changes.
+type geocodeChange struct {
+	AssociationID string         `json:"associationId"`
+	LegalName     string         `json:"legalName"`
+	Location      string         `json:"location"`
+	Changes       map[string]any `json:"changes"`
+}
+
+// POST /api/admin/data/associations/geocode?dryRun=false   (super only)
+// Places every live association again, e.g. after loading a larger
+// gazetteer: fills in the city, county, state and ZIP the gazetteer
+// confirms where empty, and sets or clears the position. Unless
+// dryRun=false only the preview is returned. Applying locks and re-reads
+// every association in one transaction, so the changes are computed from
+// the rows they are written to, and updates each changed association as one
+// write, bumping its version.
+// Unplaced lists the associations neither their address nor their
+// location label could place.
+func GeocodeAssociations(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dryRun", true)
+	var list []models.Association
+	changes := []geocodeChange{}
+	unplaced := []fiber.Map{}
+	// plan loads the associations through q and works out their changes.
+	plan := func(q *gorm.DB) error {
+		if err := q.Order("legal_name asc").Find(&list).Error; err != nil {
+			return err
+		}
+		for i := range list {
+			a := &list[i]
+			if changed := geocode(a); len(changed) > 0 {
+				changes = append(changes, geocodeChange{a.ID, a.LegalName, a.Location, changed})
+			}
+			if a.GeoPrecision == "" {
+				unplaced = append(unplaced, fiber.Map{"associationId": a.ID, "legalName": a.LegalName, "location": a.Location})
+			}
+		}
+		return nil
+	}
+
+	if dryRun {
+		if err := plan(db.DB); err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to load associations")
+		}
+	} else {
+		who, _ := middleware.CurrentUser(c)
+		err := db.DB.Transaction(func(tx *gorm.DB) error {
+			if err := plan(tx.Clauses(clause.Locking{Strength: "UPDATE"})); err != nil {
+				return err
+			}
+			for _, ch := range changes {
+				updates := map[string]any{"version": nextVersion}
+				for col, v := range ch.Changes {
+					updates[col] = v
+				}
+				if err := tx.Model(&models.Association{}).Where("id = ?", ch.AssociationID).Updates(updates).Error; err != nil {
+					return err
+				}
+				if err := emit(tx, "association.updated", ch.AssociationID, who); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return problem.New(http.StatusInternalServerError, "Geocoding failed")
+		}
+	}
+	return c.JSON(fiber.Map{"dryRun": dryRun, "associations": len(list), "changes": changes, "unplaced": unplaced})
+}
@@ -0,0 +1,584 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"gorm.io/gorm"
+)
+
+const (
+	// gqlMaxDepth and gqlMaxCost bound a query before it runs; see queryCost.
+	gqlMaxDepth = 8
+	gqlMaxCost  = 10000
+	// gqlDefaultLimit and gqlMaxLimit apply to the top-level lists.
+	gqlDefaultLimit = 50
+	gqlMaxLimit     = 200
+)
+
+// gqlListSizes is how many items queryCost expects from the nested lists,
+// which take no limit.
+var gqlListSizes = map[string]int{"associations": 25, "boardMembers": 10, "documents": 20, "versions": 3, "tags": 5}
+
+// batch loads values by key for one request. Resolvers ask for a key with
+// get, which returns a thunk; the executor runs thunks breadth first, once
+// every field at the current depth has resolved, so all the keys asked for
+// by then are loaded with one query instead of one query per parent.
+type batch[V any] struct {
+	load    func(keys []string) (map[string]V, error)
+	pending map[string]bool
+	loaded  map[string]V
+	err     error
+}
+
+func newBatch[V any](load func(keys []string) (map[string]V, error)) *batch[V] {
+	return &batch[V]{load: load, pending: map[string]bool{}, loaded: map[string]V{}}
+}
+
+func (b *batch[V]) get(key string) func() (any, error) {
+	if _, ok := b.loaded[key]; !ok {
+		b.pending[key] = true
+	}
+	return func() (any, error) {
+		if len(b.pending) > 0 {
+			keys := make([]string, 0, len(b.pending))
+			for k := range b.pending {
+				keys = append(keys, k)
+			}
+			clear(b.pending)
+			values, err := b.load(keys)
+			if err != nil {
+				b.err = err
+			} else {
+				for _, k := range keys {
+					b.loaded[k] = values[k]
+				}
+			}
+		}
+		v, ok := b.loaded[key]
+		if !ok {
+			return nil, b.err
+		}
+		return v, nil
+	}
+}
+
+// groupBy indexes list by key, with an empty slice for keys without items.
+func groupBy[T any](keys []string, list []T, key func(T) string) map[string][]T {
+	out := make(map[string][]T, len(keys))
+	for _, k := range keys {
+		out[k] = []T{}
+	}
+	for _, item := range list {
+		out[key(item)] = append(out[key(item)], item)
+	}
+	return out
+}
+
+// gqlRequest is what the resolvers of one request share: the caller's
+// association scope and the batch loaders.
+type gqlRequest struct {
+	scope associationScope
+	// managers by id; associations, by manager id; the rest by association id.
+	managers     *batch[*models.Manager]
+	associations *batch[[]models.Association]
+	tags         *batch[[]models.Tag]
+	board        *batch[[]models.BoardMember]
+	boardHistory *batch[[]models.BoardMember]
+	documents    *batch[[]models.Document]
+}
+
+type gqlRequestKey struct{}
+
+func newGQLRequest(scope associationScope) *gqlRequest {
+	boardLoader := func(current bool) func([]string) (map[string][]models.BoardMember, error) {
+		return func(ids []string) (map[string][]models.BoardMember, error) {
+			tx := db.DB.Where("association_id IN ?", ids)
+			if current {
+				tx = tx.Where("term_start <= ? AND (term_end IS NULL OR term_end >= ?)", today(), today())
+			} else {
+				tx = tx.Order("term_start desc")
+			}
+			var list []models.BoardMember
+			if err := tx.Order(byOffice).Find(&list).Error; err != nil {
+				return nil, errors.New("Failed to load board")
+			}
+			scope.hideBoardContacts(list)
+			return groupBy(ids, list, func(m models.BoardMember) string { return m.AssociationID }), nil
+		}
+	}
+	return &gqlRequest{
+		scope: scope,
+		managers: newBatch(func(ids []string) (map[string]*models.Manager, error) {
+			var list []models.Manager
+			if err := db.DB.Where("id IN ?", ids).Find(&list).Error; err != nil {
+				return nil, errors.New("Failed to load managers")
+			}
+			out := make(map[string]*models.Manager, len(list))
+			for i := range list {
+				out[list[i].ID] = &list[i]
+			}
+			return out, nil
+		}),
+		associations: newBatch(func(ids []string) (map[string][]models.Association, error) {
+			var list []models.Association
+			if err := db.DB.Where("manager_id IN ?", ids).Order("legal_name asc").Find(&list).Error; err != nil {
+				return nil, errors.New("Failed to load associations")
+			}
+			return groupBy(ids, list, func(a models.Association) string { return a.ManagerID }), nil
+		}),
+		tags: newBatch(func(ids []string) (map[string][]models.Tag, error) {
+			var rows []struct {
+				models.Tag
+				AssociationID string
+			}
+			err := db.DB.Model(&models.Tag{}).
+				Select("tags.*, association_tags.association_id").
+				Joins("JOIN association_tags ON association_tags.tag_id = tags.id").
+				Where("association_tags.association_id IN ?", ids).
+				Order("tags.name asc").
+				Scan(&rows).Error
+			if err != nil {
+				return nil, errors.New("Failed to load tags")
+			}
+			out := make(map[string][]models.Tag, len(ids))
+			for _, id := range ids {
+				out[id] = []models.Tag{}
+			}
+			for _, r := range rows {
+				out[r.AssociationID] = append(out[r.AssociationID], r.Tag)
+			}
+			return out, nil
+		}),
+		board:        newBatch(boardLoader(true)),
+		boardHistory: newBatch(boardLoader(false)),
+		documents: newBatch(func(ids []string) (map[string][]models.Document, error) {
+			var list []models.Document
+			err := db.DB.Preload("Versions", newestFirst).
+				Where("association_id IN ?", ids).
+				Order("effective_date desc nulls last").Order("title asc").
+				Find(&list).Error
+			if err != nil {
+				return nil, errors.New("Failed to load documents")
+			}
+			return groupBy(ids, list, func(d models.Document) string { return d.AssociationID }), nil
+		}),
+	}
+}
+
+func gqlRequestOf(p graphql.ResolveParams) *gqlRequest {
+	return p.Context.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// sourceOf is the object a field is resolved on; lists hand their items
+// over by value, single objects by pointer.
+func sourceOf[S any](p graphql.ResolveParams) S {
+	switch s := p.Source.(type) {
+	case *S:
+		return *s
+	case S:
+		return s
+	}
+	var zero S
+	return zero
+}
+
+// gqlDate formats a date column as YYYY-MM-DD, or null.
+func gqlDate(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.DateOnly)
+}
+
+// gqlPage checks the limit and offset arguments and applies them to tx.
+func gqlPage(p graphql.ResolveParams, tx *gorm.DB) (*gorm.DB, error) {
+	limit, _ := p.Args["limit"].(int)
+	offset, _ := p.Args["offset"].(int)
+	var v validate.Validator
+	v.Check(limit >= 1 && limit <= gqlMaxLimit, "limit", fmt.Sprintf("must be between 1 and %d", gqlMaxLimit))
+	v.Check(offset >= 0, "offset", "must not be negative")
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+	return tx.Limit(limit).Offset(offset), nil
+}
+
+// gqlFind loads the T with the id argument from tx, or null if there is none.
+func gqlFind[T any](p graphql.ResolveParams, tx *gorm.DB, what string) (any, error) {
+	id, _ := p.Args["id"].(string)
+	if err := validate.ID("id", id); err != nil {
+		return nil, err
+	}
+	var row T
+	err := tx.First(&row, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("Failed to load " + what)
+	}
+	return &row, nil
+}
+
+var gqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value; used for the custom field values of a record.",
+	Serialize:   func(v any) any { return v },
+})
+
+// gqlSchema is the read-only graph over managers, associations and users.
+// Nested fields go through the request's batch loaders, and association
+// data restricted by scope is hidden or refused as it is over REST.
+var gqlSchema = newGQLSchema()
+
+func newGQLSchema() graphql.Schema {
+	str, id, num := graphql.NewNonNull(graphql.String), graphql.NewNonNull(graphql.ID), graphql.NewNonNull(graphql.Int)
+	nonNullList := func(t graphql.Type) *graphql.List { return graphql.NewList(graphql.NewNonNull(t)) }
+	field := func(t graphql.Output) *graphql.Field { return &graphql.Field{Type: t} }
+
+	tagType := graphql.NewObject(graphql.ObjectConfig{Name: "Tag", Fields: graphql.Fields{
+		"id": field(id), "name": field(str),
+	}})
+	versionType := graphql.NewObject(graphql.ObjectConfig{Name: "DocumentVersion", Fields: graphql.Fields{
+		"id": field(id), "number": field(num), "fileName": field(str), "contentType": field(str),
+		"size": field(num), "sha256": field(str), "note": field(str), "uploadedBy": field(str),
+		"createdAt": field(graphql.NewNonNull(graphql.DateTime)),
+	}})
+	documentType := graphql.NewObject(graphql.ObjectConfig{Name: "Document", Fields: graphql.Fields{
+		"id": field(id), "associationId": field(id), "title": field(str), "type": field(str),
+		"effectiveDate": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlDate(sourceOf[models.Document](p).EffectiveDate), nil
+		}},
+		"versions":  field(graphql.NewNonNull(nonNullList(versionType))),
+		"createdBy": field(str), "createdAt": field(graphql.NewNonNull(graphql.DateTime)),
+		"updatedAt": field(graphql.NewNonNull(graphql.DateTime)), "version": field(num),
+	}})
+	boardMemberType := graphql.NewObject(graphql.ObjectConfig{Name: "BoardMember", Fields: graphql.Fields{
+		"id": field(id), "associationId": field(id), "name": field(str), "office": field(str),
+		"termStart": {Type: str, Resolve: func(p graphql.ResolveParams) (any, error) {
+			start := sourceOf[models.BoardMember](p).TermStart
+			return gqlDate(&start), nil
+		}},
+		"termEnd": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlDate(sourceOf[models.BoardMember](p).TermEnd), nil
+		}},
+		"email": field(str), "phone": field(str), "mailingAddress": field(str),
+		"contactHidden": field(graphql.NewNonNull(graphql.Boolean)), "version": field(num),
+	}})
+	userType := graphql.NewObject(graphql.ObjectConfig{Name: "User", Fields: graphql.Fields{
+		"id": field(id), "username": field(str), "role": field(str), "version": field(num),
+	}})
+	managerType := graphql.NewObject(graphql.ObjectConfig{Name: "Manager", Fields: graphql.Fields{
+		"id": field(id), "email": field(str), "name": field(str), "titles": field(str),
+		"initials": field(str), "custom": field(graphql.NewNonNull(gqlJSON)), "version": field(num),
+	}})
+	associationType := graphql.NewObject(graphql.ObjectConfig{Name: "Association", Fields: graphql.Fields{
+		"id": field(id), "legalName": field(str), "filterName": field(str), "location": field(str),
+		"managerId": field(id), "street": field(str), "city": field(str), "county": field(str),
+		"state": field(str), "zip": field(str), "type": field(str), "unitCount": field(num),
+		"fiscalYearEnd": field(str), "stateCorpNumber": field(str),
+		"latitude": field(graphql.Float), "longitude": field(graphql.Float), "geoPrecision": field(str),
+		"ein": {Type: str, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return string(sourceOf[models.Association](p).EIN), nil
+		}},
+		"incorporationDate": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlDate(sourceOf[models.Association](p).IncorporationDate), nil
+		}},
+		"custom": field(graphql.NewNonNull(gqlJSON)), "version": field(num),
+		"manager": {Type: managerType, Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlRequestOf(p).managers.get(sourceOf[models.Association](p).ManagerID), nil
+		}},
+		"tags": {Type: nonNullList(tagType), Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlRequestOf(p).tags.get(sourceOf[models.Association](p).ID), nil
+		}},
+		"boardMembers": {
+			Type:        nonNullList(boardMemberType),
+			Description: "The current board in office order, or with history every term, most recent first. Contact data outside your scope is hidden.",
+			Args: graphql.FieldConfigArgument{
+				"history": {Type: graphql.Boolean, DefaultValue: false},
+			},
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				r, a := gqlRequestOf(p), sourceOf[models.Association](p)
+				if history, _ := p.Args["history"].(bool); history {
+					return r.boardHistory.get(a.ID), nil
+				}
+				return r.board.get(a.ID), nil
+			},
+		},
+		"documents": {
+			Type:        nonNullList(documentType),
+			Description: "Newest first; an error outside your scope.",
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				r, a := gqlRequestOf(p), sourceOf[models.Association](p)
+				if !r.scope.allows(a.ID) {
+					return nil, errors.New("This association is outside your scope")
+				}
+				return r.documents.get(a.ID), nil
+			},
+		},
+	}})
+	managerType.AddFieldConfig("associations", &graphql.Field{
+		Type: nonNullList(associationType),
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return gqlRequestOf(p).associations.get(sourceOf[models.Manager](p).ID), nil
+		},
+	})
+
+	page := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		args["limit"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: gqlDefaultLimit}
+		args["offset"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0}
+		return args
+	}
+	byID := graphql.FieldConfigArgument{"id": {Type: id}}
+	query := graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"managers": {
+			Type: graphql.NewNonNull(nonNullList(managerType)),
+			Args: page(graphql.FieldConfigArgument{"q": {Type: graphql.String}}),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				tx := db.DB
+				if q, _ := p.Args["q"].(string); strings.TrimSpace(q) != "" {
+					tx = managerSearch.apply(tx, "managers", strings.TrimSpace(q))
+				}
+				tx, err := gqlPage(p, tx.Order("name asc"))
+				if err != nil {
+					return nil, err
+				}
+				var list []models.Manager
+				if err := tx.Find(&list).Error; err != nil {
+					return nil, errors.New("Failed to load managers")
+				}
+				return list, nil
+			},
+		},
+		"manager": {
+			Type: managerType,
+			Args: byID,
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return gqlFind[models.Manager](p, db.DB, "manager")
+			},
+		},
+		"associations": {
+			Type: graphql.NewNonNull(nonNullList(associationType)),
+			Args: page(graphql.FieldConfigArgument{
+				"q": {Type: graphql.String}, "state": {Type: graphql.String},
+				"type": {Type: graphql.String}, "managerId": {Type: graphql.ID},
+			}),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				tx := db.DB
+				if q, _ := p.Args["q"].(string); strings.TrimSpace(q) != "" {
+					tx = associationSearch.apply(tx, "associations", strings.TrimSpace(q))
+				}
+				if state, _ := p.Args["state"].(string); state != "" {
+					tx = tx.Where("state = ?", strings.ToUpper(state))
+				}
+				if typ, _ := p.Args["type"].(string); typ != "" {
+					tx = tx.Where("type = ?", strings.ToLower(typ))
+				}
+				if managerID, _ := p.Args["managerId"].(string); managerID != "" {
+					if err := validate.ID("managerId", managerID); err != nil {
+						return nil, err
+					}
+					tx = tx.Where("manager_id = ?", managerID)
+				}
+				tx, err := gqlPage(p, tx.Order("legal_name asc"))
+				if err != nil {
+					return nil, err
+				}
+				var list []models.Association
+				if err := tx.Find(&list).Error; err != nil {
+					return nil, errors.New("Failed to load associations")
+				}
+				return list, nil
+			},
+		},
+		"association": {
+			Type: associationType,
+			Args: byID,
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return gqlFind[models.Association](p, db.DB, "association")
+			},
+		},
+		"users": {
+			Type: graphql.NewNonNull(nonNullList(userType)),
+			Args: page(graphql.FieldConfigArgument{}),
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				tx, err := gqlPage(p, userQuery().Order("username asc"))
+				if err != nil {
+					return nil, err
+				}
+				var list []models.User
+				if err := tx.Find(&list).Error; err != nil {
+					return nil, errors.New("Failed to fetch users")
+				}
+				return list, nil
+			},
+		},
+		"user": {
+			Type: userType,
+			Args: byID,
+			Resolve: func(p graphql.ResolveParams) (any, error) {
+				return gqlFind[models.User](p, userQuery(), "user")
+			},
+		},
+	}})
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: query})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// queryCost estimates the work of the operation in doc before it runs:
+// each field costs 1, and the fields below a list cost once per expected
+// item, which is the limit argument (or gqlDefaultLimit) for top-level
+// lists and gqlListSizes below them. It also returns how deeply fields
+// nest. Introspection fields are free.
+func queryCost(doc *ast.Document, operationName string, vars map[string]any) (cost, depth int) {
+	fragments := map[string]*ast.FragmentDefinition{}
+	var op *ast.OperationDefinition
+	for _, d := range doc.Definitions {
+		switch d := d.(type) {
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		case *ast.OperationDefinition:
+			if op == nil && (operationName == "" || d.Name != nil && d.Name.Value == operationName) {
+				op = d
+			}
+		}
+	}
+	if op == nil {
+		return 0, 0
+	}
+	defaults := map[string]ast.Value{}
+	for _, vd := range op.VariableDefinitions {
+		if vd.DefaultValue != nil {
+			defaults[vd.Variable.Name.Value] = vd.DefaultValue
+		}
+	}
+	limitOf := func(f *ast.Field) int {
+		for _, a := range f.Arguments {
+			if a.Name.Value != "limit" {
+				continue
+			}
+			value := a.Value
+			if v, ok := value.(*ast.Variable); ok {
+				if n, ok := vars[v.Name.Value].(float64); ok {
+					return int(n)
+				}
+				value = defaults[v.Name.Value]
+			}
+			if v, ok := value.(*ast.IntValue); ok {
+				if n, err := strconv.Atoi(v.Value); err == nil {
+					return n
+				}
+			}
+		}
+		return gqlDefaultLimit
+	}
+
+	var walk func(set *ast.SelectionSet, level int) int
+	walk = func(set *ast.SelectionSet, level int) int {
+		if set == nil {
+			return 0
+		}
+		total := 0
+		for _, s := range set.Selections {
+			switch s := s.(type) {
+			case *ast.Field:
+				name := s.Name.Value
+				if strings.HasPrefix(name, "__") {
+					continue
+				}
+				depth = max(depth, level)
+				items := 1
+				switch {
+				case level == 1 && (name == "managers" || name == "associations" || name == "users"):
+					items = max(limitOf(s), 1)
+				case level > 1 && gqlListSizes[name] > 0:
+					items = gqlListSizes[name]
+				}
+				total += 1 + items*walk(s.SelectionSet, level+1)
+			case *ast.InlineFragment:
+				total += walk(s.SelectionSet, level)
+			case *ast.FragmentSpread:
+				if f := fragments[s.Name.Value]; f != nil {
+					total += walk(f.SelectionSet, level)
+				}
+			}
+		}
+		return total
+	}
+	return walk(op.SelectionSet, 1), depth
+}
+
+// POST /api/admin/graphql
+// Body: { "query": "{ manager(id: \"uuid\") { name associations { legalName boardMembers { name office } } } }",
+// "variables": { ... }, "operationName": "..." }
+// Read-only queries over managers, associations (with their manager, tags,
+// board and documents) and users. Nested fields are loaded in one query
+// per level, not per parent. Queries nested deeper than gqlMaxDepth or
+// costing more than gqlMaxCost (see queryCost) are refused before they run.
+// Errors while resolving, e.g. documents outside your scope, come back in
+// "errors" next to the data that did resolve.
+func GraphQL(c *fiber.Ctx) error {
+	var in struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	var v validate.Validator
+	v.Required("query", in.Query)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: in.Query})
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid query").
+			With("queryErrors", []gqlerrors.FormattedError{gqlerrors.FormatError(err)})
+	}
+	if res := graphql.ValidateDocument(&gqlSchema, doc, nil); !res.IsValid {
+		return problem.New(http.StatusBadRequest, "Invalid query").With("queryErrors", res.Errors)
+	}
+	cost, depth := queryCost(doc, in.OperationName, in.Variables)
+	if depth > gqlMaxDepth {
+		return problem.New(http.StatusBadRequest, "Query is nested too deeply").
+			With("depth", depth).With("maxDepth", gqlMaxDepth)
+	}
+	if cost > gqlMaxCost {
+		return problem.New(http.StatusBadRequest, "Query is too costly; ask for fewer items or fields").
+			With("cost", cost).With("maxCost", gqlMaxCost)
+	}
+
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	ctx := context.WithValue(c.UserContext(), gqlRequestKey{}, newGQLRequest(scope))
+	res := graphql.Execute(graphql.ExecuteParams{
+		Schema:        gqlSchema,
+		AST:           doc,
+		OperationName: in.OperationName,
+		Args:          in.Variables,
+		Context:       ctx,
+	})
+	return c.JSON(res)
+}
@@ -0,0 +1,624 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/tabular"
+	"admin/validate"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// importRow is one data row after column mapping, keyed by field name.
+type importRow map[string]string
+
+type importChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// importResult is the plan for one row. Row numbers match the spreadsheet,
+// so the header is row 1 and the first data row is row 2.
+type importResult struct {
+	Row     int                     `json:"row"`
+	Key     string                  `json:"key"`
+	Action  string                  `json:"action"` // create | update | unchanged | invalid
+	Errors  validate.Errors         `json:"errors,omitempty"`
+	Changes map[string]importChange `json:"changes,omitempty"`
+
+	apply func(tx *gorm.DB) error
+}
+
+// check records v's errors on r, reporting whether the row is still valid.
+func (r *importResult) check(v *validate.Validator) bool {
+	if v.Err() == nil {
+		return true
+	}
+	r.Action, r.Errors = "invalid", v.Errors()
+	return false
+}
+
+type importReport struct {
+	Kind    string         `json:"kind"`
+	DryRun  bool           `json:"dryRun"`
+	Summary map[string]int `json:"summary"`
+	Rows    []importResult `json:"rows"`
+}
+
+var (
+	managerImportFields     = []string{"email", "name", "titles", "initials"}
+	associationImportFields = []string{"legalName", "filterName", "location", "managerEmail"}
+	unitImportFields        = []string{"number", "association", "street", "city", "state", "zip", "type"}
+	ownerImportFields       = []string{"name", "email", "phone", "mailingAddress", "association", "unit", "role", "startDate", "endDate"}
+)
+
+// POST /api/admin/data/import/:kind?dryRun=false   (kind = associations | managers | units | owners)
+// Multipart form: file (.csv or .xlsx), optional mapping (JSON object of field -> column header).
+// Managers are matched by email, associations by legal name, units by association
+// legal name and number, and owners by email (or name when there is none); an owner
+// row naming a unit also records the ownership period. Unless dryRun=false
+// only the per-row report is returned; a commit applies every row in one transaction
+// and is refused with 422 if any row is invalid.
+func ImportData(c *fiber.Ctx) error {
+	kind := c.Params("kind")
+	dryRun := c.QueryBool("dryRun", true)
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "File required")
+	}
+	format, err := tabular.FormatOf(fh.Filename)
+	if err != nil {
+		return problem.New(http.StatusBadRequest, err.Error())
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "Could not read file")
+	}
+	defer f.Close()
+	rows, err := tabular.ReadAll(f, format)
+	if err != nil {
+		return problem.New(http.StatusBadRequest, "Could not read file")
+	}
+	if len(rows) < 2 {
+		return problem.New(http.StatusBadRequest, "File has no data rows")
+	}
+
+	var mapping map[string]string
+	if m := c.FormValue("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			return problem.New(http.StatusBadRequest, "Invalid mapping")
+		}
+	}
+
+	var fields []string
+	var plan func([]importRow) ([]importResult, error)
+	switch kind {
+	case "managers":
+		who, _ := middleware.CurrentUser(c)
+		fields = managerImportFields
+		plan = func(rows []importRow) ([]importResult, error) { return planManagerImport(rows, who) }
+	case "associations":
+		who, _ := middleware.CurrentUser(c)
+		fields = associationImportFields
+		plan = func(rows []importRow) ([]importResult, error) { return planAssociationImport(rows, who) }
+	case "units":
+		fields, plan = unitImportFields, planUnitImport
+	case "owners":
+		scope, err := scopeOf(c)
+		if err != nil {
+			return err
+		}
+		fields = ownerImportFields
+		plan = func(rows []importRow) ([]importResult, error) { return planOwnerImport(rows, scope) }
+	default:
+		return problem.New(http.StatusNotFound, "Unknown import kind")
+	}
+
+	cols, err := tabular.Columns(rows[0], fields, mapping)
+	if err != nil {
+		return problem.New(http.StatusBadRequest, err.Error())
+	}
+	if _, ok := cols[fields[0]]; !ok {
+		return problem.New(http.StatusBadRequest, "Missing "+fields[0]+" column")
+	}
+
+	results, err := plan(mapRows(rows[1:], cols))
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load existing records")
+	}
+	report := importReport{Kind: kind, DryRun: dryRun, Summary: map[string]int{}, Rows: results}
+	for _, r := range results {
+		report.Summary[r.Action]++
+	}
+
+	if dryRun {
+		return c.JSON(report)
+	}
+	if report.Summary["invalid"] > 0 {
+		return problem.New(http.StatusUnprocessableEntity, fmt.Sprintf("%d rows are invalid; nothing was applied", report.Summary["invalid"])).
+			With("report", report)
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, r := range results {
+			if r.apply == nil {
+				continue
+			}
+			if err := r.apply(tx); err != nil {
+				return fmt.Errorf("row %d: %w", r.Row, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Import failed:", err)
+		return problem.New(http.StatusInternalServerError, "Import failed; nothing was applied")
+	}
+	return c.JSON(report)
+}
+
+// mapRows turns raw data rows into field-keyed rows using cols.
+func mapRows(rows [][]string, cols map[string]int) []importRow {
+	out := make([]importRow, len(rows))
+	for n, row := range rows {
+		r := importRow{}
+		for field, i := range cols {
+			if i < len(row) {
+				r[field] = strings.TrimSpace(row[i])
+			}
+		}
+		out[n] = r
+	}
+	return out
+}
+
+// diffRow records each non-empty incoming value that differs from current.
+func diffRow(in importRow, current map[string]string) map[string]importChange {
+	changes := map[string]importChange{}
+	for field, from := range current {
+		if to := in[field]; to != "" && to != from {
+			changes[field] = importChange{From: from, To: to}
+		}
+	}
+	return changes
+}
+
+// cell returns row[field] for validation, or nil when the cell is blank and
+// blankOK is set (an update keeps the current value for blank cells).
+func cell(row importRow, field string, blankOK bool) *string {
+	v := row[field]
+	if v == "" && blankOK {
+		return nil
+	}
+	return &v
+}
+
+// checkKey validates a row's match key and flags repeats within the file.
+func checkKey(v *validate.Validator, seen map[string]int, field, key string, row int) {
+	v.Required(field, key)
+	if first, dup := seen[strings.ToLower(key)]; dup {
+		v.Add(field, fmt.Sprintf("duplicate of row %d", first))
+	} else if key != "" {
+		seen[strings.ToLower(key)] = row
+	}
+}
+
+// planManagerImport matches managers by email; who is the actor of the
+// events recorded for each change.
+func planManagerImport(rows []importRow, who string) ([]importResult, error) {
+	var existing []models.Manager
+	if err := db.DB.Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byEmail := make(map[string]models.Manager, len(existing))
+	for _, m := range existing {
+		byEmail[strings.ToLower(m.Email)] = m
+	}
+
+	columns := map[string]string{"name": "name", "titles": "titles", "initials": "initials"}
+	seen := map[string]int{}
+	results := make([]importResult, len(rows))
+	for n, row := range rows {
+		r := &results[n]
+		r.Row, r.Key = n+2, row["email"]
+		row["initials"] = strings.ToUpper(row["initials"])
+		m, exists := byEmail[strings.ToLower(r.Key)]
+
+		var v validate.Validator
+		checkKey(&v, seen, "email", r.Key, r.Row)
+		v.Email("email", r.Key)
+		validateManager(&v, cell(row, "name", exists), nil, cell(row, "titles", exists), cell(row, "initials", exists))
+		if !r.check(&v) {
+			continue
+		}
+
+		if exists {
+			r.Changes = diffRow(row, map[string]string{"name": m.Name, "titles": m.Titles, "initials": m.Initials})
+			if len(r.Changes) == 0 {
+				r.Action = "unchanged"
+				continue
+			}
+			r.Action = "update"
+			updates := map[string]any{"version": nextVersion}
+			for field, ch := range r.Changes {
+				updates[columns[field]] = ch.To
+			}
+			id := m.ID
+			r.apply = func(tx *gorm.DB) error {
+				if err := tx.Model(&models.Manager{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+					return err
+				}
+				return emit(tx, "manager.updated", id, who)
+			}
+			continue
+		}
+
+		r.Action = "create"
+		r.Changes = diffRow(row, map[string]string{"email": "", "name": "", "titles": "", "initials": ""})
+		m = models.Manager{Email: row["email"], Name: row["name"], Titles: row["titles"], Initials: row["initials"]}
+		r.apply = func(tx *gorm.DB) error {
+			if err := tx.Create(&m).Error; err != nil {
+				return err
+			}
+			return emit(tx, "manager.created", m.ID, who)
+		}
+	}
+	return results, nil
+}
+
+// planAssociationImport records a changed or new manager as the primary
+// assignment, changed by who.
+func planAssociationImport(rows []importRow, who string) ([]importResult, error) {
+	var managers []models.Manager
+	if err := db.DB.Find(&managers).Error; err != nil {
+		return nil, err
+	}
+	managerIDs := make(map[string]string, len(managers))
+	for _, m := range managers {
+		managerIDs[strings.ToLower(m.Email)] = m.ID
+	}
+
+	var existing []models.Association
+	if err := db.DB.Preload("Manager").Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byName := make(map[string]models.Association, len(existing))
+	for _, a := range existing {
+		byName[strings.ToLower(a.LegalName)] = a
+	}
+
+	columns := map[string]string{"filterName": "filter_name", "location": "location"}
+	seen := map[string]int{}
+	results := make([]importResult, len(rows))
+	for n, row := range rows {
+		r := &results[n]
+		r.Row, r.Key = n+2, row["legalName"]
+		a, exists := byName[strings.ToLower(r.Key)]
+
+		var v validate.Validator
+		checkKey(&v, seen, "legalName", r.Key, r.Row)
+		validateAssociation(&v, db.DB, &r.Key, cell(row, "filterName", exists), cell(row, "location", exists), nil)
+		managerID := ""
+		if email := row["managerEmail"]; email != "" {
+			managerID = managerIDs[strings.ToLower(email)]
+			v.Check(managerID != "", "managerEmail", "manager not found")
+		} else {
+			v.Check(exists, "managerEmail", "is required")
+		}
+		if !r.check(&v) {
+			continue
+		}
+
+		if exists {
+			current := map[string]string{"filterName": a.FilterName, "location": a.Location, "managerEmail": a.Manager.Email}
+			if managerID == a.ManagerID {
+				delete(current, "managerEmail") // same manager, possibly different email case
+			}
+			r.Changes = diffRow(row, current)
+			if len(r.Changes) == 0 {
+				r.Action = "unchanged"
+				continue
+			}
+			r.Action = "update"
+			updates := map[string]any{"version": nextVersion}
+			for field, ch := range r.Changes {
+				if field == "managerEmail" {
+					updates["manager_id"] = managerID
+				} else {
+					updates[columns[field]] = ch.To
+				}
+			}
+			id := a.ID
+			_, reassigned := updates["manager_id"]
+			_, relocated := updates["location"]
+			r.apply = func(tx *gorm.DB) error {
+				if err := tx.Model(&models.Association{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+					return err
+				}
+				if relocated {
+					if err := geocodeStored(tx, id); err != nil {
+						return err
+					}
+				}
+				if reassigned {
+					if err := assignPrimary(tx, id, managerID, "Changed by import", who); err != nil {
+						return err
+					}
+				}
+				return emit(tx, "association.updated", id, who)
+			}
+			continue
+		}
+
+		r.Action = "create"
+		r.Changes = diffRow(row, map[string]string{"legalName": "", "filterName": "", "location": "", "managerEmail": ""})
+		a = models.Association{LegalName: row["legalName"], FilterName: row["filterName"], Location: row["location"], ManagerID: managerID}
+		geocode(&a)
+		r.apply = func(tx *gorm.DB) error {
+			if err := tx.Create(&a).Error; err != nil {
+				return err
+			}
+			if err := assignPrimary(tx, a.ID, a.ManagerID, "Assigned when the association was imported", who); err != nil {
+				return err
+			}
+			return emit(tx, "association.created", a.ID, who)
+		}
+	}
+	return results, nil
+}
+
+// liveUnits indexes live units by lower-case "association legal name/number"
+// and live associations by lower-case legal name.
+func liveUnits() (map[string]models.Unit, map[string]models.Association, error) {
+	var associations []models.Association
+	if err := db.DB.Find(&associations).Error; err != nil {
+		return nil, nil, err
+	}
+	byName := make(map[string]models.Association, len(associations))
+	names := make(map[string]string, len(associations))
+	for _, a := range associations {
+		byName[strings.ToLower(a.LegalName)] = a
+		names[a.ID] = strings.ToLower(a.LegalName)
+	}
+	var units []models.Unit
+	if err := db.DB.Find(&units).Error; err != nil {
+		return nil, nil, err
+	}
+	byKey := make(map[string]models.Unit, len(units))
+	for _, u := range units {
+		byKey[names[u.AssociationID]+"/"+strings.ToLower(u.Number)] = u
+	}
+	return byKey, byName, nil
+}
+
+func planUnitImport(rows []importRow) ([]importResult, error) {
+	byKey, associations, err := liveUnits()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]string{"street": "street", "city": "city", "state": "state", "zip": "zip", "type": "type"}
+	seen := map[string]int{}
+	results := make([]importResult, len(rows))
+	for n, row := range rows {
+		r := &results[n]
+		r.Row = n + 2
+		r.Key = row["association"] + "/" + row["number"]
+		row["state"] = strings.ToUpper(row["state"])
+		row["type"] = strings.ToLower(row["type"])
+		a, known := associations[strings.ToLower(row["association"])]
+		u, exists := byKey[strings.ToLower(r.Key)]
+
+		var v validate.Validator
+		v.Required("association", row["association"])
+		v.Check(known, "association", "association not found")
+		checkKey(&v, seen, "number", r.Key, r.Row)
+		in := unitInput{
+			Number: cell(row, "number", false),
+			Street: cell(row, "street", true),
+			City:   cell(row, "city", true),
+			State:  cell(row, "state", true),
+			ZIP:    cell(row, "zip", true),
+			Type:   cell(row, "type", true),
+		}
+		in.validate(&v)
+		if !r.check(&v) {
+			continue
+		}
+
+		if exists {
+			r.Changes = diffRow(row, map[string]string{"street": u.Street, "city": u.City, "state": u.State, "zip": u.ZIP, "type": u.Type})
+			if len(r.Changes) == 0 {
+				r.Action = "unchanged"
+				continue
+			}
+			r.Action = "update"
+			updates := map[string]any{"version": nextVersion}
+			for field, ch := range r.Changes {
+				updates[columns[field]] = ch.To
+			}
+			id := u.ID
+			r.apply = func(tx *gorm.DB) error {
+				return tx.Model(&models.Unit{}).Where("id = ?", id).Updates(updates).Error
+			}
+			continue
+		}
+
+		r.Action = "create"
+		r.Changes = diffRow(row, map[string]string{"number": "", "street": "", "city": "", "state": "", "zip": "", "type": ""})
+		u = models.Unit{AssociationID: a.ID, Number: row["number"], Street: row["street"], City: row["city"],
+			State: row["state"], ZIP: row["zip"], Type: row["type"]}
+		if u.Type == "" {
+			u.Type = unitTypes[0]
+		}
+		r.apply = func(tx *gorm.DB) error { return tx.Omit("Association").Create(&u).Error }
+	}
+	return results, nil
+}
+
+// planOwnerImport matches owners by email, or by name when the row has no
+// email. Several rows may name the same owner, one per unit. Owners outside
+// scope are not matched, so a row naming one creates a new owner just as an
+// unknown email does, without revealing the owner exists. Rows touching
+// associations outside scope are invalid.
+func planOwnerImport(rows []importRow, scope associationScope) ([]importResult, error) {
+	units, _, err := liveUnits()
+	if err != nil {
+		return nil, err
+	}
+	var existing []models.Owner
+	if err := db.DB.Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(existing))
+	for i, o := range existing {
+		ids[i] = o.ID
+	}
+	visible, err := scope.ownerContactVisible(ids)
+	if err != nil {
+		return nil, err
+	}
+	byEmail := map[string][]*models.Owner{}
+	byName := map[string][]*models.Owner{}
+	for i := range existing {
+		o := &existing[i]
+		if !visible[o.ID] {
+			continue
+		}
+		if o.Email != "" {
+			byEmail[strings.ToLower(o.Email)] = append(byEmail[strings.ToLower(o.Email)], o)
+		}
+		byName[strings.ToLower(o.Name)] = append(byName[strings.ToLower(o.Name)], o)
+	}
+	var links []models.Ownership
+	if err := db.DB.Where("end_date IS NULL").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	linked := map[string]bool{}
+	for _, l := range links {
+		linked[l.OwnerID+"/"+l.UnitID+"/"+l.Role] = true
+	}
+
+	columns := map[string]string{"name": "name", "email": "email", "phone": "phone", "mailingAddress": "mailing_address"}
+	pending := map[string]*models.Owner{} // owners created by an earlier row
+	seen := map[string]int{}
+	results := make([]importResult, len(rows))
+	for n, row := range rows {
+		r := &results[n]
+		r.Row = n + 2
+		ownerKey := strings.ToLower(row["email"])
+		matches := byEmail[ownerKey]
+		if ownerKey == "" {
+			ownerKey = "name:" + strings.ToLower(row["name"])
+			matches = byName[strings.ToLower(row["name"])]
+		}
+		r.Key = row["name"]
+		if row["email"] != "" {
+			r.Key = row["email"]
+		}
+		unitKey := row["association"] + "/" + row["unit"]
+		if row["unit"] != "" {
+			r.Key += " @ " + unitKey
+		}
+
+		var v validate.Validator
+		checkKey(&v, seen, "name", ownerKey+"@"+strings.ToLower(unitKey), r.Row)
+		in := ownerInput{
+			Name:           cell(row, "name", false),
+			Email:          cell(row, "email", true),
+			Phone:          cell(row, "phone", true),
+			MailingAddress: cell(row, "mailingAddress", true),
+		}
+		in.validate(&v)
+		v.Check(len(matches) <= 1, "email", "matches several owners; fix duplicates first")
+		var o *models.Owner
+		if len(matches) == 1 {
+			o = matches[0]
+		}
+
+		var link *models.Ownership
+		if row["association"] != "" || row["unit"] != "" {
+			u, ok := units[strings.ToLower(unitKey)]
+			v.Check(ok, "unit", "unit not found")
+			v.Check(!ok || scope.allows(u.AssociationID), "association", "association is outside your scope")
+			li := ownershipInput{Role: cell(row, "role", true), StartDate: cell(row, "startDate", false), EndDate: cell(row, "endDate", true)}
+			if li.Role == nil {
+				owner := ownershipRoles[0]
+				li.Role = &owner
+			}
+			var start, end time.Time
+			li.validate(&v, &start, &end)
+			if ok && !v.Has("startDate") {
+				link = &models.Ownership{UnitID: u.ID, Role: *li.Role, StartDate: start}
+				if !end.IsZero() {
+					link.EndDate = &end
+				}
+			}
+		}
+		if !r.check(&v) {
+			continue
+		}
+
+		if o == nil {
+			o = pending[ownerKey]
+		}
+		r.Changes = map[string]importChange{}
+		var updates map[string]any
+		switch {
+		case o == nil:
+			r.Action = "create"
+			r.Changes = diffRow(row, map[string]string{"name": "", "email": "", "phone": "", "mailingAddress": ""})
+			o = &models.Owner{Name: row["name"], Email: row["email"], Phone: row["phone"], MailingAddress: row["mailingAddress"]}
+			pending[ownerKey] = o
+		case o.ID == "":
+			r.Action = "update" // another unit for an owner created above
+		default:
+			r.Changes = diffRow(row, map[string]string{"name": o.Name, "email": o.Email, "phone": o.Phone, "mailingAddress": o.MailingAddress})
+			r.Action = "update"
+			if len(r.Changes) > 0 {
+				updates = map[string]any{"version": nextVersion}
+				for field, ch := range r.Changes {
+					updates[columns[field]] = ch.To
+				}
+			}
+		}
+		if link != nil && (o.ID == "" || !linked[o.ID+"/"+link.UnitID+"/"+link.Role]) {
+			r.Changes["unit"] = importChange{From: "", To: unitKey + " (" + link.Role + ")"}
+		} else {
+			link = nil
+		}
+		if len(r.Changes) == 0 {
+			r.Action = "unchanged"
+			continue
+		}
+
+		creates := r.Action == "create"
+		r.apply = func(tx *gorm.DB) error {
+			if creates {
+				if err := tx.Create(o).Error; err != nil {
+					return err
+				}
+			} else if updates != nil {
+				if err := tx.Model(&models.Owner{}).Where("id = ?", o.ID).Updates(updates).Error; err != nil {
+					return err
+				}
+			}
+			if link == nil {
+				return nil
+			}
+			link.OwnerID = o.ID
+			return tx.Omit("Unit", "Owner").Create(link).Error
+		}
+	}
+	return results, nil
+}
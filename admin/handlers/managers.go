@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"admin/db"
+	"admin/middleware"
 	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -10,42 +14,88 @@ import (
 	"gorm.io/gorm"
 )
 
-// GET /api/admin/data/managers?q=jane
-func ListManagers(c *fiber.Ctx) error {
+// managerQuery applies the list filters (q, includeDeleted, custom fields;
+// see customFilter) shared by ListManagers and ExportManagers. With q, only
+// matching rows are returned, most relevant first (see managerSearch).
+func managerQuery(c *fiber.Ctx) (*gorm.DB, error) {
 	q := strings.TrimSpace(c.Query("q"))
-	var list []models.Manager
 
 	tx := db.DB.Preload("Associations")
+	if c.QueryBool("includeDeleted") {
+		tx = db.DB.Unscoped().Preload("Associations", unscoped)
+	}
 	if q != "" {
-		p := "%" + q + "%"
-		tx = tx.Where("name ILIKE ? OR email ILIKE ? OR initials ILIKE ?", p, p, p)
+		tx = managerSearch.apply(tx, "managers", q)
 	}
+	tx, err := customFilter(c, tx, "managers")
+	if err != nil {
+		return nil, err
+	}
+	return tx.Order("name asc"), nil
+}
 
-	if err := tx.Order("name asc").Find(&list).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load managers"})
+// GET /api/admin/data/managers?q=jane&custom.preferred_bank=First%20Federal&includeDeleted=true
+func ListManagers(c *fiber.Ctx) error {
+	tx, err := managerQuery(c)
+	if err != nil {
+		return err
+	}
+	var list []models.Manager
+	if err := tx.Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load managers")
 	}
 	return c.JSON(list)
 }
 
+// validateManager checks the fields of a create or update body; nil fields
+// were not sent and are skipped. Email uniqueness is left to the database.
+func validateManager(v *validate.Validator, name, email, titles, initials *string) {
+	if name != nil {
+		v.Required("name", *name)
+		v.MaxLen("name", *name, 100)
+	}
+	if email != nil {
+		v.Required("email", *email)
+		v.Email("email", *email)
+		v.MaxLen("email", *email, 254)
+	}
+	if titles != nil {
+		v.Required("titles", *titles)
+		v.MaxLen("titles", *titles, 200)
+	}
+	if initials != nil {
+		v.Required("initials", *initials)
+		v.Initials("initials", *initials)
+	}
+}
+
 // POST /api/admin/data/managers
-// Body: { "name": "...", "email": "...", "titles": "...", "initials": "JD" }
+// Body: { "name": "...", "email": "...", "titles": "...", "initials": "JD",
+// "custom": { "<key>": value } }
 func CreateManager(c *fiber.Ctx) error {
 	var in struct {
-		Name     string `json:"name"`
-		Email    string `json:"email"`
-		Titles   string `json:"titles"`
-		Initials string `json:"initials"`
+		Name     string      `json:"name"`
+		Email    string      `json:"email"`
+		Titles   string      `json:"titles"`
+		Initials string      `json:"initials"`
+		Custom   customInput `json:"custom"`
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return problem.New(http.StatusBadRequest, "Invalid input")
 	}
 	in.Name = strings.TrimSpace(in.Name)
 	in.Email = strings.TrimSpace(in.Email)
 	in.Titles = strings.TrimSpace(in.Titles)
-	in.Initials = strings.TrimSpace(in.Initials)
+	in.Initials = strings.ToUpper(strings.TrimSpace(in.Initials))
 
-	if in.Name == "" || in.Email == "" || in.Titles == "" || in.Initials == "" {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "All fields required"})
+	var v validate.Validator
+	validateManager(&v, &in.Name, &in.Email, &in.Titles, &in.Initials)
+	custom, _, err := in.Custom.check(&v, "managers", true)
+	if err != nil {
+		return err
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
 	}
 
 	m := models.Manager{
@@ -53,60 +103,113 @@ func CreateManager(c *fiber.Ctx) error {
 		Email:    in.Email,
 		Titles:   in.Titles,
 		Initials: in.Initials,
+		Custom:   custom,
 	}
-	if err := db.DB.Create(&m).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Create failed"})
+	who, _ := middleware.CurrentUser(c)
+	err = dbOf(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&m).Error; err != nil {
+			return err
+		}
+		return emit(tx, "manager.created", m.ID, who)
+	})
+	if err != nil {
+		return dbError(err, "Create failed")
 	}
 	return c.Status(http.StatusCreated).JSON(m)
 }
 
+// GET /api/admin/data/managers/:id
+// The ETag header carries the version to send back as If-Match on update.
+func GetManager(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var m models.Manager
+	if err := db.DB.Preload("Associations").First(&m, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Manager not found")
+	}
+	setETag(c, &m)
+	return c.JSON(m)
+}
+
 // PUT /api/admin/data/managers/:id
-// Body (any subset): { "name": "...", "email": "...", "titles": "...", "initials": "XX" }
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body (any subset): { "name": "...", "email": "...", "titles": "...", "initials": "XX",
+// "custom": { "<key>": value } }; custom values are merged, and null clears one.
 func UpdateManager(c *fiber.Ctx) error {
 	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
 	var in struct {
-		Name     *string `json:"name"`
-		Email    *string `json:"email"`
-		Titles   *string `json:"titles"`
-		Initials *string `json:"initials"`
+		Name     *string     `json:"name"`
+		Email    *string     `json:"email"`
+		Titles   *string     `json:"titles"`
+		Initials *string     `json:"initials"`
+		Custom   customInput `json:"custom"`
 	}
 	if err := c.BodyParser(&in); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	trimAll(in.Name, in.Email, in.Titles, in.Initials)
+	if in.Initials != nil {
+		*in.Initials = strings.ToUpper(*in.Initials)
+	}
+
+	var v validate.Validator
+	v.UUID("id", id)
+	validateManager(&v, in.Name, in.Email, in.Titles, in.Initials)
+	set, unset, err := in.Custom.check(&v, "managers", false)
+	if err != nil {
+		return err
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
 	}
 
 	updates := map[string]any{}
+	if len(in.Custom) > 0 {
+		updates["custom"] = customUpdate(set, unset)
+	}
 	if in.Name != nil {
-		updates["name"] = strings.TrimSpace(*in.Name)
+		updates["name"] = *in.Name
 	}
 	if in.Email != nil {
-		updates["email"] = strings.TrimSpace(*in.Email)
+		updates["email"] = *in.Email
 	}
 	if in.Titles != nil {
-		updates["titles"] = strings.TrimSpace(*in.Titles)
+		updates["titles"] = *in.Titles
 	}
 	if in.Initials != nil {
-		updates["initials"] = strings.TrimSpace(*in.Initials)
+		updates["initials"] = *in.Initials
 	}
 	if len(updates) == 0 {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "No changes"})
+		return problem.New(http.StatusBadRequest, "No changes")
 	}
 
-	if err := db.DB.Model(&models.Manager{}).Where("id = ?", id).Updates(updates).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Update failed"})
-	}
-	return c.JSON(fiber.Map{"message": "Updated"})
+	who, _ := middleware.CurrentUser(c)
+	return versionedUpdate(c, dbOf(c).Preload("Associations"), &models.Manager{}, id, version, updates,
+		emitting("manager.updated", id, who))
 }
 
 // DELETE /api/admin/data/managers/:id
-// Optional body: { "reassignTo": "uuid" } to reassign owned associations before delete
+// Optional body: { "reassignTo": "uuid" } to reassign owned associations before delete.
+// To spread them across several managers, use POST /api/admin/data/reassignments first.
+// The manager is moved to the trash; see RestoreTrash.
 func DeleteManager(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
 
 	var owned int64
-	if err := db.DB.Model(&models.Association{}).
+	if err := dbOf(c).Model(&models.Association{}).
 		Where("manager_id = ?", id).
 		Count(&owned).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Count failed"})
+		return problem.New(http.StatusInternalServerError, "Count failed")
 	}
 
 	var in struct{ ReassignTo *string `json:"reassignTo"` }
@@ -114,38 +217,65 @@ func DeleteManager(c *fiber.Ctx) error {
 
 	// If manager owns associations, require reassignment (RESTRICT semantics)
 	if owned > 0 && (in.ReassignTo == nil || *in.ReassignTo == "") {
-		return c.Status(http.StatusConflict).JSON(fiber.Map{
-			"error": "Manager has associations; provide reassignTo",
-			"owned": owned,
-		})
+		return problem.New(http.StatusConflict, "Manager has associations; provide reassignTo").
+			With("owned", owned)
+	}
+	if owned > 0 {
+		var v validate.Validator
+		v.UUID("reassignTo", *in.ReassignTo)
+		v.Check(*in.ReassignTo != id, "reassignTo", "must be a different manager")
+		if err := v.Err(); err != nil {
+			return validationError(err)
+		}
 	}
 
-	err := db.DB.Transaction(func(tx *gorm.DB) error {
+	err := dbOf(c).Transaction(func(tx *gorm.DB) error {
 		if owned > 0 {
 			// Validate target manager
 			var target models.Manager
 			if err := tx.First(&target, "id = ?", *in.ReassignTo).Error; err != nil {
-				return fiber.NewError(http.StatusBadRequest, "reassignTo manager not found")
+				return problem.New(http.StatusBadRequest, "reassignTo manager not found")
+			}
+			// Reassign, recording the handover in each association's history
+			var moved []string
+			if err := tx.Model(&models.Association{}).Where("manager_id = ?", id).Pluck("id", &moved).Error; err != nil {
+				return problem.New(http.StatusInternalServerError, "Reassign failed")
 			}
-			// Reassign
 			if err := tx.Model(&models.Association{}).
 				Where("manager_id = ?", id).
-				Update("manager_id", *in.ReassignTo).Error; err != nil {
-				return fiber.NewError(http.StatusInternalServerError, "Reassign failed")
+				Updates(map[string]any{"manager_id": *in.ReassignTo, "version": nextVersion}).Error; err != nil {
+				return problem.New(http.StatusInternalServerError, "Reassign failed")
+			}
+			for _, a := range moved {
+				if err := assignPrimary(tx, a, *in.ReassignTo, "Reassigned when the previous manager was deleted", who); err != nil {
+					return problem.New(http.StatusInternalServerError, "Reassign failed")
+				}
+				if err := emit(tx, "association.updated", a, who); err != nil {
+					return problem.New(http.StatusInternalServerError, "Reassign failed")
+				}
 			}
 		}
+		// End the manager's other assignments
+		if err := tx.Model(&models.ManagerAssignment{}).
+			Where("manager_id = ? AND role <> 'primary' AND effective_to IS NULL", id).
+			Updates(map[string]any{"effective_to": today(), "end_reason": "Manager deleted", "version": nextVersion}).Error; err != nil {
+			return problem.New(http.StatusInternalServerError, "Delete failed")
+		}
 		// Delete original manager
-		if err := tx.Delete(&models.Manager{}, "id = ?", id).Error; err != nil {
-			return fiber.NewError(http.StatusInternalServerError, "Delete failed")
+		if err := softDelete(tx, &models.Manager{}, id, who); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return problem.New(http.StatusNotFound, "Manager not found")
+			}
+			return problem.New(http.StatusInternalServerError, "Delete failed")
 		}
-		return nil
+		return emit(tx, "manager.deleted", id, who)
 	})
 	if err != nil {
-		fe, ok := err.(*fiber.Error)
-		if ok {
-			return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
 		}
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Transaction failed"})
+		return problem.New(http.StatusInternalServerError, "Transaction failed")
 	}
 
 	return c.JSON(fiber.Map{"message": "Deleted"})
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ownerQuery applies the list filters (associationId, q, includeDeleted).
+// q matches names only, so contact data cannot be probed through search.
+func ownerQuery(c *fiber.Ctx) *gorm.DB {
+	q := strings.TrimSpace(c.Query("q"))
+
+	tx := db.DB.Model(&models.Owner{})
+	if c.QueryBool("includeDeleted") {
+		tx = tx.Unscoped()
+	}
+	if a := c.Query("associationId"); a != "" {
+		tx = tx.Where("id IN (?)", db.DB.Model(&models.Ownership{}).
+			Select("ownerships.owner_id").
+			Joins("JOIN units ON units.id = ownerships.unit_id").
+			Where("units.association_id = ?", a))
+	}
+	if q != "" {
+		tx = ownerSearch.apply(tx, "owners", q)
+	}
+	return tx.Order("name asc")
+}
+
+// GET /api/admin/data/owners?associationId=uuid&q=smith&includeDeleted=true
+// Contact data is withheld for owners outside the caller's association scope.
+func ListOwners(c *fiber.Ctx) error {
+	if a := c.Query("associationId"); a != "" {
+		if err := validate.ID("associationId", a); err != nil {
+			return validationError(err)
+		}
+	}
+	var list []models.Owner
+	if err := ownerQuery(c).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load owners")
+	}
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	owners := make([]*models.Owner, len(list))
+	for i := range list {
+		owners[i] = &list[i]
+	}
+	if err := scope.hideContacts(owners...); err != nil {
+		return err
+	}
+	return c.JSON(list)
+}
+
+// ownerInput is a create or update body; nil fields were not sent.
+type ownerInput struct {
+	Name           *string `json:"name"`
+	Email          *string `json:"email"`
+	Phone          *string `json:"phone"`
+	MailingAddress *string `json:"mailingAddress"`
+}
+
+func (in *ownerInput) validate(v *validate.Validator) {
+	trimAll(in.Name, in.Email, in.Phone, in.MailingAddress)
+	if in.Name != nil {
+		v.Required("name", *in.Name)
+		v.MaxLen("name", *in.Name, 200)
+	}
+	if in.Email != nil && *in.Email != "" {
+		v.MaxLen("email", *in.Email, 254)
+		v.Email("email", *in.Email)
+	}
+	if in.Phone != nil {
+		v.MaxLen("phone", *in.Phone, 30)
+	}
+	if in.MailingAddress != nil {
+		v.MaxLen("mailingAddress", *in.MailingAddress, 300)
+	}
+}
+
+// touchesContact reports whether the body changes contact data.
+func (in *ownerInput) touchesContact() bool {
+	return in.Email != nil || in.Phone != nil || in.MailingAddress != nil
+}
+
+// POST /api/admin/data/owners
+// Body: { "name": "...", "email": "...", "phone": "...", "mailingAddress": "..." }
+// Link the owner to units with POST /api/admin/data/units/:id/ownerships.
+func CreateOwner(c *fiber.Ctx) error {
+	var in ownerInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if in.Name == nil {
+		in.Name = new(string)
+	}
+
+	var v validate.Validator
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	o := models.Owner{Name: *in.Name}
+	for dst, src := range map[*string]*string{&o.Email: in.Email, &o.Phone: in.Phone, &o.MailingAddress: in.MailingAddress} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if err := db.DB.Create(&o).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(o)
+}
+
+// GET /api/admin/data/owners/:id
+// Includes the owner's units, most recent first.
+func GetOwner(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var o models.Owner
+	err := db.DB.Preload("Ownerships", func(tx *gorm.DB) *gorm.DB { return tx.Order("start_date desc") }).
+		Preload("Ownerships.Unit").
+		First(&o, "id = ?", id).Error
+	if err != nil {
+		return problem.New(http.StatusNotFound, "Owner not found")
+	}
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	if err := scope.hideContacts(&o); err != nil {
+		return err
+	}
+	setETag(c, &o)
+	return c.JSON(o)
+}
+
+// PUT /api/admin/data/owners/:id
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body (any subset): the fields of CreateOwner. Contact fields can only be
+// changed within the caller's association scope.
+func UpdateOwner(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in ownerInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+
+	var v validate.Validator
+	v.UUID("id", id)
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	visible, err := scope.ownerContactVisible([]string{id})
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load access scope")
+	}
+	// The reloaded row goes back in a 412, so leave contact data out of it
+	// when the caller may not see it.
+	reload := db.DB
+	if !visible[id] {
+		if in.touchesContact() {
+			return problem.New(http.StatusForbidden, "Owner contact data is outside your association scope")
+		}
+		reload = db.DB.Omit("email", "phone", "mailing_address")
+	}
+
+	updates := map[string]any{}
+	for col, value := range map[string]*string{
+		"name": in.Name, "email": in.Email, "phone": in.Phone, "mailing_address": in.MailingAddress,
+	} {
+		if value != nil {
+			updates[col] = *value
+		}
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, reload, &models.Owner{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/owners/:id
+// Moves the owner to the trash; their ownership history is kept.
+func DeleteOwner(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return softDelete(tx, &models.Owner{}, id, who)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return problem.New(http.StatusNotFound, "Owner not found")
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
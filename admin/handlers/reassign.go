@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// reassignRule moves every association of From to the manager named for the
+// value of its By field (case-insensitive), or to Default when no value
+// matches.
+type reassignRule struct {
+	From    string            `json:"from"`
+	By      string            `json:"by"`
+	To      map[string]string `json:"to"`
+	Default string            `json:"default,omitempty"`
+}
+
+// reassignRuleFields are the association columns a rule can group by.
+var reassignRuleFields = map[string]string{"location": "location", "city": "city", "county": "county", "state": "state"}
+
+type reassignMove struct {
+	AssociationID string `json:"associationId"`
+	LegalName     string `json:"legalName"`
+	FromManagerID string `json:"fromManagerId"`
+	FromManager   string `json:"fromManager"`
+	ToManagerID   string `json:"toManagerId"`
+	ToManager     string `json:"toManager"`
+}
+
+type reassignUnmatched struct {
+	AssociationID string `json:"associationId"`
+	LegalName     string `json:"legalName"`
+	Value         string `json:"value"`
+}
+
+// managerWorkload is a manager's count of primary associations before and
+// after the moves.
+type managerWorkload struct {
+	ManagerID string `json:"managerId"`
+	Name      string `json:"name"`
+	Before    int64  `json:"before"`
+	After     int64  `json:"after"`
+}
+
+type reassignReport struct {
+	DryRun         bool                `json:"dryRun"`
+	ReassignmentID string              `json:"reassignmentId,omitempty"`
+	Moves          []reassignMove      `json:"moves"`
+	Unmatched      []reassignUnmatched `json:"unmatched"`
+	Workload       []managerWorkload   `json:"workload"`
+}
+
+// POST /api/admin/data/reassignments?dryRun=false
+// Body: { "reason": "...", "moves": [{ "associationId": "uuid", "managerId": "uuid" }],
+// "rule": { "from": "uuid", "by": "location", "to": { "Miami": "uuid" }, "default": "uuid" } }
+// Moves associations between primary managers, from explicit moves, a rule
+// (by location, city, county or state), or both; explicit moves win. Unless
+// dryRun=false only the preview is returned: each move and every affected
+// manager's workload. Applying records each move in the association's
+// assignment history and the whole batch as one Reassignment, atomically.
+func Reassign(c *fiber.Ctx) error {
+	dryRun := c.QueryBool("dryRun", true)
+	var in struct {
+		Reason string `json:"reason"`
+		Moves  []struct {
+			AssociationID string `json:"associationId"`
+			ManagerID     string `json:"managerId"`
+		} `json:"moves"`
+		Rule *reassignRule `json:"rule"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.Reason = strings.TrimSpace(in.Reason)
+
+	var v validate.Validator
+	v.Required("reason", in.Reason)
+	v.MaxLen("reason", in.Reason, 500)
+	v.Check(len(in.Moves) > 0 || in.Rule != nil, "moves", "give moves, a rule, or both")
+	targets := map[string]string{} // association id -> manager id
+	for _, m := range in.Moves {
+		v.UUID("moves.associationId", m.AssociationID)
+		v.UUID("moves.managerId", m.ManagerID)
+		if _, dup := targets[m.AssociationID]; dup {
+			v.Add("moves.associationId", "duplicate association "+m.AssociationID)
+		}
+		targets[m.AssociationID] = m.ManagerID
+	}
+	if r := in.Rule; r != nil {
+		v.UUID("rule.from", r.From)
+		_, ok := reassignRuleFields[r.By]
+		v.Check(ok, "rule.by", "must be one of location, city, county, state")
+		v.Check(len(r.To) > 0 || r.Default != "", "rule.to", "name at least one target manager")
+		for _, m := range r.To {
+			v.UUID("rule.to", m)
+		}
+		if r.Default != "" {
+			v.UUID("rule.default", r.Default)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var managers []models.Manager
+	if err := db.DB.Find(&managers).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load managers")
+	}
+	names := make(map[string]string, len(managers))
+	for _, m := range managers {
+		names[m.ID] = m.Name
+	}
+
+	// Expand the rule, then overlay the explicit moves.
+	report := reassignReport{DryRun: dryRun, Moves: []reassignMove{}, Unmatched: []reassignUnmatched{}}
+	var associations []models.Association
+	byID := map[string]models.Association{}
+	if r := in.Rule; r != nil {
+		if _, ok := names[r.From]; !ok {
+			v.Add("rule.from", "manager not found")
+		}
+		to := make(map[string]string, len(r.To))
+		for value, m := range r.To {
+			to[strings.ToLower(strings.TrimSpace(value))] = m
+		}
+		if err := db.DB.Where("manager_id = ?", r.From).Order("legal_name asc").Find(&associations).Error; err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to load associations")
+		}
+		for _, a := range associations {
+			byID[a.ID] = a
+			if _, explicit := targets[a.ID]; explicit {
+				continue
+			}
+			value := associationField(a, r.By)
+			m, ok := to[strings.ToLower(value)]
+			if !ok {
+				m = r.Default
+			}
+			if m == "" {
+				report.Unmatched = append(report.Unmatched, reassignUnmatched{a.ID, a.LegalName, value})
+				continue
+			}
+			targets[a.ID] = m
+		}
+	}
+	var explicit []string
+	for id := range targets {
+		if _, ok := byID[id]; !ok {
+			explicit = append(explicit, id)
+		}
+	}
+	if len(explicit) > 0 {
+		var more []models.Association
+		if err := db.DB.Where("id IN ?", explicit).Find(&more).Error; err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to load associations")
+		}
+		for _, a := range more {
+			byID[a.ID] = a
+		}
+	}
+
+	workload := map[string]int64{}
+	for id, m := range targets {
+		a, ok := byID[id]
+		if !ok {
+			v.Add("moves.associationId", "association not found: "+id)
+			continue
+		}
+		if _, ok := names[m]; !ok {
+			v.Add("moves.managerId", "manager not found: "+m)
+			continue
+		}
+		if a.ManagerID == m {
+			continue
+		}
+		report.Moves = append(report.Moves, reassignMove{a.ID, a.LegalName, a.ManagerID, names[a.ManagerID], m, names[m]})
+		workload[a.ManagerID]--
+		workload[m]++
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	sort.Slice(report.Moves, func(i, j int) bool { return report.Moves[i].LegalName < report.Moves[j].LegalName })
+
+	ids := make([]string, 0, len(workload))
+	for id := range workload {
+		ids = append(ids, id)
+	}
+	var counts []struct {
+		ManagerID string
+		N         int64
+	}
+	if len(ids) > 0 {
+		err := db.DB.Model(&models.Association{}).Select("manager_id, count(*) AS n").
+			Where("manager_id IN ?", ids).Group("manager_id").Scan(&counts).Error
+		if err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to count workload")
+		}
+	}
+	before := map[string]int64{}
+	for _, n := range counts {
+		before[n.ManagerID] = n.N
+	}
+	report.Workload = []managerWorkload{}
+	for _, id := range ids {
+		report.Workload = append(report.Workload, managerWorkload{id, names[id], before[id], before[id] + workload[id]})
+	}
+	sort.Slice(report.Workload, func(i, j int) bool { return report.Workload[i].Name < report.Workload[j].Name })
+
+	if dryRun {
+		return c.JSON(report)
+	}
+	if len(report.Moves) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+
+	who, _ := middleware.CurrentUser(c)
+	audit := models.Reassignment{Reason: in.Reason, AppliedBy: who}
+	if in.Rule != nil {
+		rule, _ := json.Marshal(in.Rule)
+		audit.Rule = string(rule)
+	}
+	for _, m := range report.Moves {
+		audit.Moves = append(audit.Moves, models.ReassignmentMove{
+			AssociationID: m.AssociationID, LegalName: m.LegalName, FromManagerID: m.FromManagerID, ToManagerID: m.ToManagerID,
+		})
+	}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, m := range report.Moves {
+			// Only move what the preview saw, so a concurrent change is not overwritten.
+			res := tx.Model(&models.Association{}).
+				Where("id = ? AND manager_id = ?", m.AssociationID, m.FromManagerID).
+				Updates(map[string]any{"manager_id": m.ToManagerID, "version": nextVersion})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return problem.New(http.StatusConflict, m.LegalName+" changed since the preview; preview again").
+					With("associationId", m.AssociationID)
+			}
+			if err := assignPrimary(tx, m.AssociationID, m.ToManagerID, in.Reason, who); err != nil {
+				return err
+			}
+			if err := emit(tx, "association.updated", m.AssociationID, who); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&audit).Error
+	})
+	if err != nil {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		return dbError(err, "Reassignment failed")
+	}
+	report.ReassignmentID = audit.ID
+	return c.JSON(report)
+}
+
+// associationField is the value of a reassignRuleFields column of a.
+func associationField(a models.Association, field string) string {
+	switch field {
+	case "city":
+		return a.City
+	case "county":
+		return a.County
+	case "state":
+		return a.State
+	}
+	return a.Location
+}
+
+// GET /api/admin/data/reassignments?limit=50
+// The audit trail of applied reassignments, newest first, with their moves.
+func ListReassignments(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		return problem.New(http.StatusBadRequest, "limit must be between 1 and 500")
+	}
+	var list []models.Reassignment
+	err := db.DB.Preload("Moves", func(tx *gorm.DB) *gorm.DB { return tx.Order("legal_name asc") }).
+		Order("created_at desc").Limit(limit).
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load reassignments")
+	}
+	return c.JSON(list)
+}
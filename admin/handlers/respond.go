@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// uniqueFields maps unique indexes to the request field they guard.
+var uniqueFields = map[string]string{
+	"idx_managers_email_live":      "email",
+	"idx_users_username_live":      "username",
+	"idx_units_number_live":        "number",
+	"idx_assignments_open_primary": "managerId",
+	"idx_custom_fields_key":        "key",
+	"idx_tags_name_lower":          "name",
+	"idx_segments_name":            "name",
+}
+
+// validationError is the 422 problem listing every field error in err.
+func validationError(err error) error {
+	var errs validate.Errors
+	errors.As(err, &errs)
+	return problem.Validation(errs)
+}
+
+// dbError maps a unique-constraint violation to a 409 naming the field and
+// anything else to a 500 carrying msg.
+func dbError(err error, msg string) error {
+	if constraint, ok := db.UniqueViolation(err); ok {
+		field, known := uniqueFields[constraint]
+		if !known {
+			field = constraint
+		}
+		return problem.New(http.StatusConflict, field+" is already in use").
+			With("errors", validate.Errors{{Field: field, Message: "is already in use"}})
+	}
+	log.Println(msg+":", err)
+	return problem.New(http.StatusInternalServerError, msg)
+}
+
+// trimAll trims surrounding whitespace from each optional field that was sent.
+func trimAll(fields ...*string) {
+	for _, f := range fields {
+		if f != nil {
+			*f = strings.TrimSpace(*f)
+		}
+	}
+}
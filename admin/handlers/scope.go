@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// associationScope is the set of associations whose restricted data the
+// current user may see: every association for super users, the granted
+// ones (see models.AssociationGrant) for admins.
+type associationScope struct {
+	all bool
+	ids map[string]bool
+}
+
+func scopeOf(c *fiber.Ctx) (associationScope, error) {
+	return scopeFor(middleware.CurrentUser(c))
+}
+
+// scopeFor is the scope of username with role, for use outside a request.
+func scopeFor(username, role string) (associationScope, error) {
+	if role == "super" {
+		return associationScope{all: true}, nil
+	}
+	var ids []string
+	err := db.DB.Model(&models.AssociationGrant{}).
+		Joins("JOIN users ON users.id = association_grants.user_id AND users.deleted_at IS NULL").
+		Where("users.username = ?", username).
+		Pluck("association_grants.association_id", &ids).Error
+	if err != nil {
+		return associationScope{}, problem.New(http.StatusInternalServerError, "Failed to load access scope")
+	}
+	s := associationScope{ids: make(map[string]bool, len(ids))}
+	for _, id := range ids {
+		s.ids[id] = true
+	}
+	return s, nil
+}
+
+func (s associationScope) allows(associationID string) bool {
+	return s.all || s.ids[associationID]
+}
+
+// requireAssociation refuses with 403 unless associationID is in the
+// current user's scope.
+func requireAssociation(c *fiber.Ctx, associationID string) error {
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	if !scope.allows(associationID) {
+		return problem.New(http.StatusForbidden, "This association is outside your scope")
+	}
+	return nil
+}
+
+// ownerContactVisible reports, per owner id, whether s covers the owner's
+// contact data. An owner is covered when any of their units, past or
+// present, is in scope, or when they have no units yet.
+func (s associationScope) ownerContactVisible(ownerIDs []string) (map[string]bool, error) {
+	visible := make(map[string]bool, len(ownerIDs))
+	for _, id := range ownerIDs {
+		visible[id] = true
+	}
+	if s.all || len(ownerIDs) == 0 {
+		return visible, nil
+	}
+	var links []struct {
+		OwnerID       string
+		AssociationID string
+	}
+	err := db.DB.Model(&models.Ownership{}).
+		Select("ownerships.owner_id, units.association_id").
+		Joins("JOIN units ON units.id = ownerships.unit_id").
+		Where("ownerships.owner_id IN ?", ownerIDs).
+		Scan(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	linked := map[string]bool{}
+	for _, l := range links {
+		if !linked[l.OwnerID] {
+			linked[l.OwnerID] = true
+			visible[l.OwnerID] = false
+		}
+		if s.allows(l.AssociationID) {
+			visible[l.OwnerID] = true
+		}
+	}
+	return visible, nil
+}
+
+// hideContacts blanks the contact data of every owner outside s and marks
+// them ContactHidden.
+func (s associationScope) hideContacts(owners ...*models.Owner) error {
+	ids := make([]string, len(owners))
+	for i, o := range owners {
+		ids[i] = o.ID
+	}
+	visible, err := s.ownerContactVisible(ids)
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load access scope")
+	}
+	for _, o := range owners {
+		if !visible[o.ID] {
+			o.Email, o.Phone, o.MailingAddress = "", "", ""
+			o.ContactHidden = true
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/users/:id/associations   (super only)
+// Lists the associations granted to the user.
+func ListUserGrants(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var list []models.Association
+	err := db.DB.Joins("JOIN association_grants g ON g.association_id = associations.id").
+		Where("g.user_id = ?", id).
+		Order("legal_name asc").
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load grants")
+	}
+	return c.JSON(list)
+}
+
+// PUT /api/admin/users/:id/associations   (super only)
+// Body: { "associationIds": ["uuid", ...] } replaces the user's grants.
+// This bumps the user's version and emits user.updated, on which the user's
+// open event streams re-read their scope.
+func SetUserGrants(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var in struct {
+		AssociationIDs []string `json:"associationIds"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+
+	var v validate.Validator
+	v.UUID("id", id)
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, a := range in.AssociationIDs {
+		v.UUID("associationIds", a)
+		if !seen[a] {
+			seen[a] = true
+			ids = append(ids, a)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var u models.User
+	if err := userQuery().First(&u, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "User not found")
+	}
+	if u.Role == "super" {
+		return problem.New(http.StatusBadRequest, "Super users see every association; grants do not apply")
+	}
+
+	who, _ := middleware.CurrentUser(c)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var found int64
+		if len(ids) > 0 {
+			if err := tx.Model(&models.Association{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+				return err
+			}
+		}
+		if int(found) != len(ids) {
+			return problem.Validation(validate.Errors{{Field: "associationIds", Message: "association not found"}})
+		}
+		if err := tx.Where("user_id = ?", id).Delete(&models.AssociationGrant{}).Error; err != nil {
+			return err
+		}
+		for _, a := range ids {
+			g := models.AssociationGrant{UserID: id, AssociationID: a, GrantedBy: who}
+			if err := tx.Omit("User", "Association").Create(&g).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", id).Update("version", nextVersion).Error; err != nil {
+			return err
+		}
+		return emit(tx, "user.updated", id, who)
+	})
+	if err != nil {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		return dbError(err, "Failed to update grants")
+	}
+	return c.JSON(fiber.Map{"message": "Updated"})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/models"
+	"admin/problem"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// searchSpec describes how one table is searched. vector is its generated
+// tsvector column (see db/search.go), ranked with ts_rank; fuzzy are the text
+// columns matched by trigram word similarity, which tolerates typos, and by
+// substring, which keeps one- and two-letter queries working.
+type searchSpec struct {
+	vector string
+	fuzzy  []string
+}
+
+var (
+	associationSearch = searchSpec{"search_vector", []string{"legal_name", "filter_name", "location"}}
+	managerSearch     = searchSpec{"search_vector", []string{"name", "email", "initials"}}
+	userSearch        = searchSpec{"", []string{"username"}}
+	unitSearch        = searchSpec{"", []string{"number", "street"}}
+	ownerSearch       = searchSpec{"", []string{"name"}}
+	vendorSearch      = searchSpec{"", []string{"name", "contact_name"}}
+)
+
+// tsQuery turns free text into a prefix tsquery, so results appear while a
+// word is still being typed: "alph vill" -> "alph:* & vill:*".
+func tsQuery(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// match is the WHERE condition for q and its arguments.
+func (s searchSpec) match(q string) (string, []any) {
+	var conds []string
+	var args []any
+	if s.vector != "" {
+		conds = append(conds, s.vector+" @@ to_tsquery('simple', ?)")
+		args = append(args, tsQuery(q))
+	}
+	like := "%" + q + "%"
+	for _, col := range s.fuzzy {
+		conds = append(conds, "? <% "+col, col+" ILIKE ?")
+		args = append(args, q, like)
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// rank is the relevance score for q: full-text rank plus the best trigram
+// similarity, so exact words outrank near misses.
+func (s searchSpec) rank(q string) (string, []any) {
+	var terms []string
+	var args []any
+	for _, col := range s.fuzzy {
+		terms = append(terms, "word_similarity(?, "+col+")")
+		args = append(args, q)
+	}
+	score := "greatest(" + strings.Join(terms, ", ") + ")"
+	if s.vector != "" {
+		score = "ts_rank(" + s.vector + ", to_tsquery('simple', ?)) + " + score
+		args = append([]any{tsQuery(q)}, args...)
+	}
+	return score, args
+}
+
+// apply filters tx to rows matching q, best first. The score is selected as
+// search_rank rather than ordered on directly so callers can keep chaining
+// Order for tie-breaks.
+func (s searchSpec) apply(tx *gorm.DB, table, q string) *gorm.DB {
+	cond, condArgs := s.match(q)
+	score, scoreArgs := s.rank(q)
+	return tx.Select(table+".*, "+score+" AS search_rank", scoreArgs...).
+		Where(cond, condArgs...).
+		Order("search_rank desc")
+}
+
+// highlight wraps the words of col matching q in <mark> tags. The text is
+// HTML-escaped first, so the marks are the only markup in the result.
+func highlight(col string) string {
+	return "ts_headline('simple', " + escapeHTML(col) + ", to_tsquery('simple', ?), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')"
+}
+
+// htmlEscapes are the replacements html.EscapeString makes, & first.
+var htmlEscapes = [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&#34;"}, {"'", "&#39;"}}
+
+// escapeHTML is the SQL for col with the characters html.EscapeString
+// escapes replaced by entities.
+func escapeHTML(col string) string {
+	for _, r := range htmlEscapes {
+		col = "replace(" + col + ", '" + strings.ReplaceAll(r[0], "'", "''") + "', '" + r[1] + "')"
+	}
+	return col
+}
+
+type searchHit struct {
+	Kind      string  `json:"kind"`
+	ID        string  `json:"id"`
+	Title     string  `json:"title"`
+	Subtitle  string  `json:"subtitle"`
+	Highlight string  `json:"highlight"`
+	Score     float64 `json:"score"`
+}
+
+// searchKinds maps each searchable kind to the query for its hits; title is
+// the column shown and highlighted, subtitle an SQL expression for a second
+// line of context. Owners get none, as their contact data is scoped.
+var searchKinds = map[string]struct {
+	model    any
+	table    string
+	spec     searchSpec
+	title    string
+	subtitle string
+}{
+	"associations": {&models.Association{}, "associations", associationSearch, "legal_name", "location"},
+	"managers":     {&models.Manager{}, "managers", managerSearch, "name", "email"},
+	"users":        {&models.User{}, "users", userSearch, "username", "role"},
+	"units":        {&models.Unit{}, "units", unitSearch, "number", "street"},
+	"owners":       {&models.Owner{}, "owners", ownerSearch, "name", "''"},
+	"vendors":      {&models.Vendor{}, "vendors", vendorSearch, "name", "contact_name"},
+}
+
+// GET /api/admin/search?q=alpah&kinds=associations,managers&limit=20
+// Returns associations, managers, users, units, owners and vendors matching
+// q in one list ranked by relevance, each with its title highlighted.
+func Search(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return problem.New(http.StatusBadRequest, "q is required")
+	}
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 100 {
+		return problem.New(http.StatusBadRequest, "limit must be between 1 and 100")
+	}
+	kinds := []string{"associations", "managers", "users", "units", "owners", "vendors"}
+	if k := c.Query("kinds"); k != "" {
+		kinds = strings.Split(k, ",")
+	}
+
+	hits := []searchHit{}
+	for _, kind := range kinds {
+		sk, ok := searchKinds[strings.TrimSpace(kind)]
+		if !ok {
+			return problem.New(http.StatusBadRequest, "Unknown kind: "+kind)
+		}
+		cond, condArgs := sk.spec.match(q)
+		score, scoreArgs := sk.spec.rank(q)
+		args := append([]any{strings.TrimSuffix(strings.TrimSpace(kind), "s"), tsQuery(q)}, scoreArgs...)
+
+		var found []searchHit
+		err := db.DB.Model(sk.model).
+			Select("? AS kind, id, "+sk.title+" AS title, "+sk.subtitle+" AS subtitle, "+
+				highlight(sk.title)+" AS highlight, "+score+" AS score", args...).
+			Where(cond, condArgs...).
+			Order("score desc").
+			Limit(limit).
+			Scan(&found).Error
+		if err != nil {
+			return problem.New(http.StatusInternalServerError, "Search failed")
+		}
+		hits = append(hits, found...)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return c.JSON(fiber.Map{"query": q, "results": hits})
+}
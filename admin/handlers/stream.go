@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/live"
+	"admin/middleware"
+	"admin/models"
+	"admin/outbox"
+	"admin/problem"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// streamReplayLimit bounds the events replayed on resume; beyond it the
+	// client is told to reload instead.
+	streamReplayLimit = 1000
+	// streamHeartbeat keeps idle connections open through proxies.
+	streamHeartbeat = 25 * time.Second
+)
+
+// eventVisible reports whether a subscriber with role and scope may see e.
+func eventVisible(e models.OutboxEvent, role string, scope associationScope) bool {
+	entity, _, _ := strings.Cut(e.Type, ".")
+	var u struct{ Role string }
+	if entity == "user" && json.Unmarshal([]byte(e.Data), &u) != nil {
+		return role == "super"
+	}
+	return changeVisible(entity, e.EntityID, u.Role, role, scope)
+}
+
+// scopeChanged reports whether e may have changed the association scope of
+// username: it is a change to that user, such as new grants.
+func scopeChanged(e models.OutboxEvent, username string) bool {
+	if entity, _, _ := strings.Cut(e.Type, "."); entity != "user" {
+		return false
+	}
+	var u struct{ Username string }
+	return json.Unmarshal([]byte(e.Data), &u) == nil && u.Username == username
+}
+
+// changeVisible reports whether a viewer with role and scope may see a
+// change to the entity record with id; userRole is the role of a changed
+// user. Admins only see associations in their scope and users other than
+// super users, which they cannot change.
+func changeVisible(entity, id, userRole, role string, scope associationScope) bool {
+	if role == "super" {
+		return true
+	}
+	switch entity {
+	case "association":
+		return scope.allows(id)
+	case "user":
+		return userRole != "super"
+	}
+	return true
+}
+
+// GET /api/admin/events
+// Header: Last-Event-ID: <id> (or ?lastEventId=) to resume after a disconnect.
+// A server-sent event stream of association, manager and user changes as
+// they commit, on any replica, in commit order (see live). Each event's id
+// is its sequence number, its name the event type and its data the event
+// as webhooks receive it. On resume the missed events are replayed first;
+// if too many were missed, or the id is unknown, a "reset" event asks the
+// client to reload instead. The stream ends when the client falls behind;
+// reconnecting resumes it. An admin's association scope is read again
+// whenever their grants change.
+func StreamEvents(c *fiber.Ctx) error {
+	var last int64
+	if h := strings.TrimSpace(c.Get("Last-Event-ID", c.Query("lastEventId"))); h != "" {
+		n, err := strconv.ParseInt(h, 10, 64)
+		if err != nil || n < 0 {
+			return problem.New(http.StatusBadRequest, "Last-Event-ID must be an event id from this stream")
+		}
+		last = n
+	}
+	username, role := middleware.CurrentUser(c)
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before reading the backlog so nothing falls in between.
+	events, cancel := live.Events.Subscribe()
+	var backlog []models.OutboxEvent
+	reset := false
+	if last > 0 {
+		// Resume from the event's place in the stream, not its Seq: a later
+		// Seq may have been streamed before an earlier one committed.
+		var from models.OutboxEvent
+		err := db.DB.Where("seq = ?", last).Limit(1).Find(&from).Error
+		if err == nil && from.Seq != 0 {
+			backlog, err = live.After(db.DB, from, streamReplayLimit+1)
+		}
+		if err == nil && (from.Seq == 0 || len(backlog) > streamReplayLimit) {
+			// Unknown or too far behind: skip to the newest event and have
+			// the client reload.
+			backlog = nil
+			from, err = live.Latest(db.DB)
+			last = from.Seq
+			reset = true
+		}
+		if err != nil {
+			cancel()
+			return problem.New(http.StatusInternalServerError, "Failed to load missed events")
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		send := func(e models.OutboxEvent) error {
+			if role != "super" && scopeChanged(e, username) {
+				// Events arrive in commit order, so every later event is
+				// filtered by the grants as they now stand.
+				var err error
+				if scope, err = scopeFor(username, role); err != nil {
+					return err
+				}
+			}
+			if !eventVisible(e, role, scope) {
+				return nil
+			}
+			data, err := outbox.Payload(e)
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			return w.Flush()
+		}
+
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if reset {
+			fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {}\n\n", last)
+		}
+		replayed := make(map[int64]bool, len(backlog))
+		for _, e := range backlog {
+			replayed[e.Seq] = true
+			if send(e) != nil {
+				return
+			}
+		}
+		if w.Flush() != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if replayed[e.Seq] {
+					continue
+				}
+				if send(e) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tagFilter restricts tx to associations carrying every tag named in the
+// comma-separated names (case-insensitive), or any of them with matchAny.
+func tagFilter(tx *gorm.DB, names string, matchAny bool) *gorm.DB {
+	var list []string
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.ToLower(strings.TrimSpace(n)); n != "" {
+			list = append(list, n)
+		}
+	}
+	if len(list) == 0 {
+		return tx
+	}
+	tagged := db.DB.Model(&models.AssociationTag{}).
+		Select("association_tags.association_id").
+		Joins("JOIN tags ON tags.id = association_tags.tag_id").
+		Where("lower(tags.name) IN ?", list).
+		Group("association_tags.association_id")
+	if !matchAny {
+		tagged = tagged.Having("count(*) = ?", len(list))
+	}
+	return tx.Where("associations.id IN (?)", tagged)
+}
+
+// GET /api/admin/data/tags
+// Every tag with its count of live associations, by name.
+func ListTags(c *fiber.Ctx) error {
+	var list []models.Tag
+	err := db.DB.Select(`tags.*, (SELECT count(*) FROM association_tags at
+			JOIN associations a ON a.id = at.association_id AND a.deleted_at IS NULL
+			WHERE at.tag_id = tags.id) AS association_count`).
+		Order("lower(name) asc").
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load tags")
+	}
+	return c.JSON(list)
+}
+
+func validateTagName(v *validate.Validator, name string) {
+	v.Required("name", name)
+	v.MaxLen("name", name, 50)
+	v.Check(!strings.Contains(name, ","), "name", "must not contain commas")
+}
+
+// POST /api/admin/data/tags
+// Body: { "name": "high-rise" }
+func CreateTag(c *fiber.Ctx) error {
+	var in struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	var v validate.Validator
+	validateTagName(&v, in.Name)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+	t := models.Tag{Name: in.Name, CreatedBy: who}
+	if err := db.DB.Create(&t).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(t)
+}
+
+// PUT /api/admin/data/tags/:id
+// Header: If-Match: "<version>"
+// Body: { "name": "..." } renames the tag.
+func UpdateTag(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in struct {
+		Name *string `json:"name"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	trimAll(in.Name)
+	var v validate.Validator
+	v.UUID("id", id)
+	if in.Name != nil {
+		validateTagName(&v, *in.Name)
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if in.Name == nil {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.Tag{}, id, version, map[string]any{"name": *in.Name})
+}
+
+// DELETE /api/admin/data/tags/:id
+// Deletes the tag and untags every association.
+func DeleteTag(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.Tag{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Tag not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
+
+// tagLinks reads the tag id and the { "associationIds": [...] } body shared
+// by TagAssociations and UntagAssociations.
+func tagLinks(c *fiber.Ctx) (models.Tag, []string, error) {
+	id := c.Params("id")
+	var in struct {
+		AssociationIDs []string `json:"associationIds"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return models.Tag{}, nil, problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	var v validate.Validator
+	v.UUID("id", id)
+	v.Check(len(in.AssociationIDs) > 0, "associationIds", "is required")
+	v.Check(len(in.AssociationIDs) <= 1000, "associationIds", "at most 1000 per request")
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, a := range in.AssociationIDs {
+		v.UUID("associationIds", a)
+		if !seen[a] {
+			seen[a] = true
+			ids = append(ids, a)
+		}
+	}
+	if err := v.Err(); err != nil {
+		return models.Tag{}, nil, validationError(err)
+	}
+	var t models.Tag
+	if err := db.DB.First(&t, "id = ?", id).Error; err != nil {
+		return models.Tag{}, nil, problem.New(http.StatusNotFound, "Tag not found")
+	}
+	return t, ids, nil
+}
+
+// POST /api/admin/data/tags/:id/associations
+// Body: { "associationIds": ["uuid", ...] } tags every listed association;
+// ones already tagged are left as they are.
+func TagAssociations(c *fiber.Ctx) error {
+	t, ids, err := tagLinks(c)
+	if err != nil {
+		return err
+	}
+	var found int64
+	if err := db.DB.Model(&models.Association{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Tagging failed")
+	}
+	if int(found) != len(ids) {
+		return validationError(validate.Errors{{Field: "associationIds", Message: "association not found"}})
+	}
+
+	who, _ := middleware.CurrentUser(c)
+	links := make([]models.AssociationTag, len(ids))
+	for i, a := range ids {
+		links[i] = models.AssociationTag{AssociationID: a, TagID: t.ID, TaggedBy: who}
+	}
+	res := db.DB.Omit("Association", "Tag").Clauses(clause.OnConflict{DoNothing: true}).Create(&links)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Tagging failed")
+	}
+	return c.JSON(fiber.Map{"message": "Tagged", "tagged": res.RowsAffected})
+}
+
+// DELETE /api/admin/data/tags/:id/associations
+// Body: { "associationIds": ["uuid", ...] } removes the tag from each.
+func UntagAssociations(c *fiber.Ctx) error {
+	t, ids, err := tagLinks(c)
+	if err != nil {
+		return err
+	}
+	res := db.DB.Where("tag_id = ? AND association_id IN ?", t.ID, ids).Delete(&models.AssociationTag{})
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Untagging failed")
+	}
+	return c.JSON(fiber.Map{"message": "Untagged", "untagged": res.RowsAffected})
+}
+
+// segmentParams are the ListAssociations query parameters a segment may
+// hold, besides custom.<key> filters.
+var segmentParams = map[string]bool{
+	"q": true, "state": true, "county": true, "type": true, "tags": true, "tagMode": true, "bbox": true,
+	"includeDeleted": true,
+}
+
+// segmentInput is a create or update body; nil fields were not sent.
+type segmentInput struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Query       *string `json:"query"`
+}
+
+func (in *segmentInput) validate(v *validate.Validator) {
+	trimAll(in.Name, in.Description, in.Query)
+	if in.Name != nil {
+		v.Required("name", *in.Name)
+		v.MaxLen("name", *in.Name, 100)
+	}
+	if in.Description != nil {
+		v.MaxLen("description", *in.Description, 500)
+	}
+	if in.Query != nil {
+		*in.Query = strings.TrimPrefix(*in.Query, "?")
+		v.Required("query", *in.Query)
+		v.MaxLen("query", *in.Query, 2000)
+		params, err := url.ParseQuery(*in.Query)
+		v.Check(err == nil, "query", "must be a URL query string")
+		for name := range params {
+			v.Check(segmentParams[name] || strings.HasPrefix(name, "custom."), "query", "unknown filter "+name)
+		}
+	}
+}
+
+// GET /api/admin/data/segments
+func ListSegments(c *fiber.Ctx) error {
+	var list []models.Segment
+	if err := db.DB.Order("lower(name) asc").Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load segments")
+	}
+	return c.JSON(list)
+}
+
+// POST /api/admin/data/segments
+// Body: { "name": "Florida high-rises", "description": "...", "query": "state=FL&tags=high-rise" }
+// query takes the filters of ListAssociations: q, state, county, type,
+// tags, tagMode, bbox, includeDeleted and custom.<key>.
+func CreateSegment(c *fiber.Ctx) error {
+	var in segmentInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if in.Name == nil {
+		in.Name = new(string)
+	}
+	if in.Query == nil {
+		in.Query = new(string)
+	}
+	var v validate.Validator
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+	s := models.Segment{Name: *in.Name, Query: *in.Query, CreatedBy: who}
+	if in.Description != nil {
+		s.Description = *in.Description
+	}
+	if err := db.DB.Create(&s).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(s)
+}
+
+// PUT /api/admin/data/segments/:id
+// Header: If-Match: "<version>"
+// Body (any subset): the fields of CreateSegment.
+func UpdateSegment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in segmentInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	var v validate.Validator
+	v.UUID("id", id)
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	updates := map[string]any{}
+	for col, value := range map[string]*string{"name": in.Name, "description": in.Description, "query": in.Query} {
+		if value != nil {
+			updates[col] = *value
+		}
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.Segment{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/segments/:id
+func DeleteSegment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.Segment{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Segment not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
+
+// useSegment replaces the request's filters with those saved in segment id,
+// keeping the export format and columns.
+func useSegment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var s models.Segment
+	if err := db.DB.First(&s, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Segment not found")
+	}
+	params, _ := url.ParseQuery(s.Query)
+	for _, keep := range []string{"format", "columns"} {
+		if value := c.Query(keep); value != "" {
+			params.Set(keep, value)
+		}
+	}
+	c.Request().URI().SetQueryString(params.Encode())
+	return nil
+}
+
+// GET /api/admin/data/segments/:id/associations
+// The associations the segment's filters select, as ListAssociations.
+func ListSegmentAssociations(c *fiber.Ctx) error {
+	if err := useSegment(c); err != nil {
+		return err
+	}
+	return ListAssociations(c)
+}
+
+// GET /api/admin/data/segments/:id/export?format=csv&columns=...
+// Exports the segment's associations, as ExportAssociations.
+func ExportSegment(c *fiber.Ctx) error {
+	if err := useSegment(c); err != nil {
+		return err
+	}
+	return ExportAssociations(c)
+}
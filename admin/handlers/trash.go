@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// softDelete stamps deleted_by and soft-deletes the live row matching id.
+// It returns gorm.ErrRecordNotFound when no live row matches.
+func softDelete(tx *gorm.DB, model any, id, by string) error {
+	res := tx.Model(model).Where("id = ?", id).Updates(map[string]any{"deleted_by": by, "version": nextVersion})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return tx.Delete(model, "id = ?", id).Error
+}
+
+// unscoped is a Preload option that also loads soft-deleted rows.
+func unscoped(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }
+
+// trashModel maps the :kind route param to its model.
+func trashModel(kind string) (any, bool) {
+	switch kind {
+	case "associations":
+		return &models.Association{}, true
+	case "managers":
+		return &models.Manager{}, true
+	case "users":
+		return &models.User{}, true
+	case "units":
+		return &models.Unit{}, true
+	case "owners":
+		return &models.Owner{}, true
+	case "vendors":
+		return &models.Vendor{}, true
+	}
+	return nil, false
+}
+
+// trashEvents names the entity of the trash kinds whose restores are
+// recorded as outbox events.
+var trashEvents = map[string]string{"associations": "association", "managers": "manager", "users": "user"}
+
+// GET /api/admin/trash/:kind   (kind = associations | managers | users | units | owners | vendors)
+func ListTrash(c *fiber.Ctx) error {
+	trashed := db.DB.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at desc")
+
+	var err error
+	var out any
+	switch c.Params("kind") {
+	case "associations":
+		var list []models.Association
+		err = trashed.Preload("Manager", unscoped).Find(&list).Error
+		out = list
+	case "managers":
+		var list []models.Manager
+		err = trashed.Find(&list).Error
+		out = list
+	case "users":
+		var list []models.User
+		err = trashed.Select("id", "username", "role", "deleted_at", "deleted_by").Find(&list).Error
+		out = list
+	case "units":
+		var list []models.Unit
+		err = trashed.Find(&list).Error
+		out = list
+	case "owners":
+		var list []models.Owner
+		if err = trashed.Find(&list).Error; err == nil {
+			err = hideTrashedContacts(c, list)
+		}
+		out = list
+	case "vendors":
+		var list []models.Vendor
+		err = trashed.Find(&list).Error
+		out = list
+	default:
+		return problem.New(http.StatusNotFound, "Unknown trash kind")
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load trash")
+	}
+	return c.JSON(out)
+}
+
+// POST /api/admin/trash/:kind/:id/restore
+// Fails with 409 when the restored row would collide with a live one.
+func RestoreTrash(c *fiber.Ctx) error {
+	kind, id := c.Params("kind"), c.Params("id")
+	model, ok := trashModel(kind)
+	if !ok {
+		return problem.New(http.StatusNotFound, "Unknown trash kind")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	if err := db.DB.Unscoped().Where("deleted_at IS NOT NULL").First(model, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Not found in trash")
+	}
+
+	var taken int64
+	switch m := model.(type) {
+	case *models.Association:
+		var live int64
+		db.DB.Model(&models.Manager{}).Where("id = ?", m.ManagerID).Count(&live)
+		if live == 0 {
+			return problem.New(http.StatusConflict, "Manager is deleted; restore the manager first")
+		}
+	case *models.Manager:
+		db.DB.Model(&models.Manager{}).Where("email = ?", m.Email).Count(&taken)
+		if taken > 0 {
+			return problem.New(http.StatusConflict, "Another manager already uses this email")
+		}
+	case *models.User:
+		db.DB.Model(&models.User{}).Where("username = ?", m.Username).Count(&taken)
+		if taken > 0 {
+			return problem.New(http.StatusConflict, "Another user already has this username")
+		}
+	case *models.Unit:
+		var live int64
+		db.DB.Model(&models.Association{}).Where("id = ?", m.AssociationID).Count(&live)
+		if live == 0 {
+			return problem.New(http.StatusConflict, "Association is deleted; restore the association first")
+		}
+		db.DB.Model(&models.Unit{}).Where("association_id = ? AND number = ?", m.AssociationID, m.Number).Count(&taken)
+		if taken > 0 {
+			return problem.New(http.StatusConflict, "Another unit in the association already has this number")
+		}
+	}
+
+	who, _ := middleware.CurrentUser(c)
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(model).Where("id = ?", id).
+			Updates(map[string]any{"deleted_at": nil, "deleted_by": "", "version": nextVersion}).Error
+		if err != nil {
+			return err
+		}
+		if entity, ok := trashEvents[kind]; ok {
+			return emit(tx, entity+".restored", id, who)
+		}
+		return nil
+	})
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Restore failed")
+	}
+	return c.JSON(fiber.Map{"message": "Restored"})
+}
+
+// DELETE /api/admin/trash/:kind/:id   (super only)
+// Permanently removes a row that is already in the trash.
+func PurgeTrash(c *fiber.Ctx) error {
+	kind, id := c.Params("kind"), c.Params("id")
+	model, ok := trashModel(kind)
+	if !ok {
+		return problem.New(http.StatusNotFound, "Unknown trash kind")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("deleted_at IS NOT NULL").First(model, "id = ?", id).Error; err != nil {
+			return problem.New(http.StatusNotFound, "Not found in trash")
+		}
+		// Trashed children still reference their parent (RESTRICT).
+		var refs int64
+		switch model.(type) {
+		case *models.Manager:
+			if err := tx.Unscoped().Model(&models.Association{}).Where("manager_id = ?", id).Count(&refs).Error; err != nil {
+				return err
+			}
+			if refs > 0 {
+				return problem.New(http.StatusConflict, "Manager is still referenced by associations; purge them first")
+			}
+		case *models.Association:
+			if err := tx.Unscoped().Model(&models.Unit{}).Where("association_id = ?", id).Count(&refs).Error; err != nil {
+				return err
+			}
+			if refs > 0 {
+				return problem.New(http.StatusConflict, "Association still has units; purge them first")
+			}
+			// Documents are kept in storage, which a cascade would orphan.
+			if err := tx.Model(&models.Document{}).Where("association_id = ?", id).Count(&refs).Error; err != nil {
+				return err
+			}
+			if refs > 0 {
+				return problem.New(http.StatusConflict, "Association still has documents; delete them first")
+			}
+		}
+		return tx.Unscoped().Delete(model, "id = ?", id).Error
+	})
+	if err != nil {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		return problem.New(http.StatusInternalServerError, "Purge failed")
+	}
+	return c.JSON(fiber.Map{"message": "Purged"})
+}
+
+// hideTrashedContacts applies the association scope to trashed owners.
+func hideTrashedContacts(c *fiber.Ctx, list []models.Owner) error {
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	owners := make([]*models.Owner, len(list))
+	for i := range list {
+		owners[i] = &list[i]
+	}
+	return scope.hideContacts(owners...)
+}
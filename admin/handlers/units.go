@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var (
+	unitTypes      = []string{"residential", "commercial", "parking", "storage"}
+	ownershipRoles = []string{"owner", "resident"}
+)
+
+// unitQuery applies the list filters (associationId, q, includeDeleted)
+// shared by ListUnits and the search endpoint's unit kind.
+func unitQuery(c *fiber.Ctx) *gorm.DB {
+	q := strings.TrimSpace(c.Query("q"))
+
+	tx := db.DB.Model(&models.Unit{})
+	if c.QueryBool("includeDeleted") {
+		tx = tx.Unscoped()
+	}
+	if a := c.Query("associationId"); a != "" {
+		tx = tx.Where("association_id = ?", a)
+	}
+	if q != "" {
+		tx = unitSearch.apply(tx, "units", q)
+	}
+	return tx.Order("number asc")
+}
+
+// GET /api/admin/data/units?associationId=uuid&q=101&includeDeleted=true
+func ListUnits(c *fiber.Ctx) error {
+	if a := c.Query("associationId"); a != "" {
+		if err := validate.ID("associationId", a); err != nil {
+			return validationError(err)
+		}
+	}
+	var list []models.Unit
+	if err := unitQuery(c).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load units")
+	}
+	return c.JSON(list)
+}
+
+// unitInput is a create or update body; nil fields were not sent.
+type unitInput struct {
+	AssociationID *string `json:"associationId"`
+	Number        *string `json:"number"`
+	Street        *string `json:"street"`
+	City          *string `json:"city"`
+	State         *string `json:"state"`
+	ZIP           *string `json:"zip"`
+	Type          *string `json:"type"`
+}
+
+func (in *unitInput) normalize() {
+	trimAll(in.AssociationID, in.Number, in.Street, in.City, in.State, in.ZIP, in.Type)
+	if in.State != nil {
+		*in.State = strings.ToUpper(*in.State)
+	}
+	if in.Type != nil {
+		*in.Type = strings.ToLower(*in.Type)
+	}
+}
+
+// validate checks the sent fields. associationId must name a live
+// association.
+func (in *unitInput) validate(v *validate.Validator) {
+	if in.AssociationID != nil {
+		v.Required("associationId", *in.AssociationID)
+		v.UUID("associationId", *in.AssociationID)
+		if !v.Has("associationId") {
+			var a models.Association
+			v.Check(db.DB.First(&a, "id = ?", *in.AssociationID).Error == nil, "associationId", "association not found")
+		}
+	}
+	if in.Number != nil {
+		v.Required("number", *in.Number)
+		v.MaxLen("number", *in.Number, 20)
+	}
+	if in.Street != nil {
+		v.MaxLen("street", *in.Street, 100)
+	}
+	if in.City != nil {
+		v.MaxLen("city", *in.City, 100)
+	}
+	if in.State != nil && *in.State != "" {
+		v.State("state", *in.State)
+	}
+	if in.ZIP != nil && *in.ZIP != "" {
+		v.ZIP("zip", *in.ZIP)
+	}
+	if in.Type != nil {
+		v.OneOf("type", *in.Type, unitTypes...)
+	}
+}
+
+func (in *unitInput) columns() map[string]any {
+	updates := map[string]any{}
+	for col, value := range map[string]*string{
+		"association_id": in.AssociationID, "number": in.Number, "street": in.Street,
+		"city": in.City, "state": in.State, "zip": in.ZIP, "type": in.Type,
+	} {
+		if value != nil {
+			updates[col] = *value
+		}
+	}
+	return updates
+}
+
+// POST /api/admin/data/units
+// Body: { "associationId": "uuid", "number": "101", "street": "...", "city": "...",
+// "state": "FL", "zip": "33601", "type": "residential" }
+// Address fields left out are copied from the association.
+func CreateUnit(c *fiber.Ctx) error {
+	var in unitInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.normalize()
+	if in.AssociationID == nil {
+		in.AssociationID = new(string)
+	}
+	if in.Number == nil {
+		in.Number = new(string)
+	}
+	if in.Type == nil {
+		t := unitTypes[0]
+		in.Type = &t
+	}
+
+	var v validate.Validator
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var a models.Association
+	db.DB.First(&a, "id = ?", *in.AssociationID)
+	u := models.Unit{
+		AssociationID: a.ID,
+		Number:        *in.Number,
+		Street:        a.Street,
+		City:          a.City,
+		State:         a.State,
+		ZIP:           a.ZIP,
+		Type:          *in.Type,
+	}
+	for dst, src := range map[*string]*string{&u.Street: in.Street, &u.City: in.City, &u.State: in.State, &u.ZIP: in.ZIP} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if err := db.DB.Omit("Association").Create(&u).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(u)
+}
+
+// GET /api/admin/data/units/:id
+// Includes the association and the unit's owners and residents, with contact
+// data withheld outside the caller's association scope.
+func GetUnit(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var u models.Unit
+	err := db.DB.Preload("Association").
+		Preload("Ownerships", func(tx *gorm.DB) *gorm.DB { return tx.Order("start_date desc") }).
+		Preload("Ownerships.Owner").
+		First(&u, "id = ?", id).Error
+	if err != nil {
+		return problem.New(http.StatusNotFound, "Unit not found")
+	}
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	var owners []*models.Owner
+	for _, o := range u.Ownerships {
+		if o.Owner != nil {
+			owners = append(owners, o.Owner)
+		}
+	}
+	if err := scope.hideContacts(owners...); err != nil {
+		return err
+	}
+	setETag(c, &u)
+	return c.JSON(u)
+}
+
+// PUT /api/admin/data/units/:id
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body (any subset): the fields of CreateUnit.
+func UpdateUnit(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in unitInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	in.normalize()
+
+	var v validate.Validator
+	v.UUID("id", id)
+	in.validate(&v)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	updates := in.columns()
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.Unit{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/units/:id
+// Moves the unit to the trash; its ownership history is kept.
+func DeleteUnit(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return softDelete(tx, &models.Unit{}, id, who)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return problem.New(http.StatusNotFound, "Unit not found")
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
+
+// ownershipInput is a create or update body for an ownership period; nil
+// fields were not sent.
+type ownershipInput struct {
+	OwnerID   *string `json:"ownerId"`
+	Role      *string `json:"role"`
+	StartDate *string `json:"startDate"`
+	EndDate   *string `json:"endDate"`
+}
+
+// validate checks the sent fields; start and end are the period after the
+// update, for the end-after-start check.
+func (in *ownershipInput) validate(v *validate.Validator, start, end *time.Time) {
+	trimAll(in.OwnerID, in.Role, in.StartDate, in.EndDate)
+	if in.OwnerID != nil {
+		v.Required("ownerId", *in.OwnerID)
+		v.UUID("ownerId", *in.OwnerID)
+		if !v.Has("ownerId") {
+			var o models.Owner
+			v.Check(db.DB.First(&o, "id = ?", *in.OwnerID).Error == nil, "ownerId", "owner not found")
+		}
+	}
+	if in.Role != nil {
+		v.OneOf("role", *in.Role, ownershipRoles...)
+	}
+	if in.StartDate != nil {
+		v.Required("startDate", *in.StartDate)
+		v.Date("startDate", *in.StartDate)
+	}
+	if in.EndDate != nil && *in.EndDate != "" {
+		v.Date("endDate", *in.EndDate)
+	}
+	if v.Has("startDate") || v.Has("endDate") {
+		return
+	}
+	if in.StartDate != nil {
+		*start, _ = time.Parse(time.DateOnly, *in.StartDate)
+	}
+	if in.EndDate != nil {
+		*end = time.Time{}
+		if *in.EndDate != "" {
+			*end, _ = time.Parse(time.DateOnly, *in.EndDate)
+		}
+	}
+	v.Check(end.IsZero() || !end.Before(*start), "endDate", "must not be before startDate")
+}
+
+// POST /api/admin/data/units/:id/ownerships
+// Body: { "ownerId": "uuid", "role": "owner"|"resident", "startDate": "2020-01-31", "endDate": "" }
+func CreateOwnership(c *fiber.Ctx) error {
+	unitID := c.Params("id")
+	var in ownershipInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if in.OwnerID == nil {
+		in.OwnerID = new(string)
+	}
+	if in.Role == nil {
+		r := ownershipRoles[0]
+		in.Role = &r
+	}
+	if in.StartDate == nil {
+		in.StartDate = new(string)
+	}
+
+	var v validate.Validator
+	v.UUID("id", unitID)
+	var start, end time.Time
+	in.validate(&v, &start, &end)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	var u models.Unit
+	if err := db.DB.First(&u, "id = ?", unitID).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Unit not found")
+	}
+	// Linking an owner to a unit in scope would reveal their contact data,
+	// so the owner must already be visible to the caller.
+	scope, err := scopeOf(c)
+	if err != nil {
+		return err
+	}
+	visible, err := scope.ownerContactVisible([]string{*in.OwnerID})
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load access scope")
+	}
+	if !visible[*in.OwnerID] {
+		return problem.New(http.StatusForbidden, "Owner is outside your association scope")
+	}
+	o := models.Ownership{UnitID: u.ID, OwnerID: *in.OwnerID, Role: *in.Role, StartDate: start}
+	if !end.IsZero() {
+		o.EndDate = &end
+	}
+	if err := db.DB.Omit("Unit", "Owner").Create(&o).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(o)
+}
+
+// PUT /api/admin/data/ownerships/:id
+// Header: If-Match: "<version>"
+// Body (any subset): { "role": "...", "startDate": "...", "endDate": "" } ("" reopens the period)
+func UpdateOwnership(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in ownershipInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var current models.Ownership
+	if err := db.DB.First(&current, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Ownership not found")
+	}
+
+	var v validate.Validator
+	v.Check(in.OwnerID == nil, "ownerId", "cannot be changed; end this period and add a new one")
+	start, end := current.StartDate, time.Time{}
+	if current.EndDate != nil {
+		end = *current.EndDate
+	}
+	in.validate(&v, &start, &end)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	updates := map[string]any{}
+	if in.Role != nil {
+		updates["role"] = *in.Role
+	}
+	if in.StartDate != nil {
+		updates["start_date"] = start
+	}
+	if in.EndDate != nil {
+		if end.IsZero() {
+			updates["end_date"] = nil
+		} else {
+			updates["end_date"] = end
+		}
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.Ownership{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/ownerships/:id
+// Removes a period entered in error; to record a sale or move-out, set
+// endDate instead.
+func DeleteOwnership(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.Ownership{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Ownership not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
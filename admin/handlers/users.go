@@ -2,93 +2,164 @@ package handlers
 
 import (
 	"admin/db"
+	"admin/middleware"
 	"admin/models"
-	"log"
+	"admin/problem"
+	"admin/validate"
 	"net/http"
 	"strings"
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 func isSuperUser(u *models.User) bool { return u.Role == "super" }
 
+// userColumns are the non-secret user columns.
+var userColumns = []string{"id", "username", "role", "version"}
+
+// userQuery selects the non-secret user columns shared by ListUsers and ExportUsers.
+func userQuery() *gorm.DB {
+	return db.DB.Select(userColumns)
+}
+
 // GET /api/admin/users
 func ListUsers(c *fiber.Ctx) error {
 	var users []models.User
-	if err := db.DB.Select("id", "username", "role").Find(&users).Error; err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch users"})
+	if err := userQuery().Find(&users).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to fetch users")
 	}
 	return c.JSON(users)
 }
 
+// assignableRoles are the roles an admin may grant; super is seeded only.
+var assignableRoles = []string{"user", "admin"}
+
 // POST /api/admin/users
+// Body: { "username": "...", "password": "...", "role": "user" | "admin" }
 func CreateUser(c *fiber.Ctx) error {
 	var input models.User
 	if err := c.BodyParser(&input); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return problem.New(http.StatusBadRequest, "Invalid input")
 	}
-	if strings.ToLower(input.Role) == "super" {
-		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "Cannot create super user"})
+	input.Username = strings.TrimSpace(input.Username)
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+	if input.Role == "" {
+		input.Role = "user"
+	}
+
+	var v validate.Validator
+	v.Required("username", input.Username)
+	v.Username("username", input.Username)
+	v.Required("password", input.Password)
+	v.MinLen("password", input.Password, 8)
+	v.Check(input.Role != "super", "role", "cannot create super user")
+	v.OneOf("role", input.Role, assignableRoles...)
+	if err := v.Err(); err != nil {
+		return validationError(err)
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to hash password"})
+		return problem.New(http.StatusInternalServerError, "Failed to hash password")
 	}
 
-	input.Password = string(hashedPassword)
-	if err := db.DB.Create(&input).Error; err != nil {
-		log.Println("Error creating user:", err)
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user"})
+	u := models.User{Username: input.Username, Password: string(hashedPassword), Role: input.Role}
+	who, _ := middleware.CurrentUser(c)
+	err = dbOf(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return emit(tx, "user.created", u.ID, who)
+	})
+	if err != nil {
+		return dbError(err, "Could not create user")
 	}
 
-	return c.Status(http.StatusCreated).JSON(fiber.Map{"message": "User created successfully"})
+	return c.Status(http.StatusCreated).JSON(fiber.Map{"message": "User created successfully", "id": u.ID})
+}
+
+// GET /api/admin/users/:id
+// The ETag header carries the version to send back as If-Match on update.
+func GetUser(c *fiber.Ctx) error {
+    id := c.Params("id")
+    if err := validate.ID("id", id); err != nil {
+        return validationError(err)
+    }
+    var u models.User
+    if err := userQuery().First(&u, "id = ?", id).Error; err != nil {
+        return problem.New(http.StatusNotFound, "User not found")
+    }
+    setETag(c, &u)
+    return c.JSON(u)
 }
 
 // PUT /api/admin/users/:id/role
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body: { "role": "user" | "admin" }
 func UpdateUserRole(c *fiber.Ctx) error {
     id := c.Params("id")
+    if err := validate.ID("id", id); err != nil {
+        return validationError(err)
+    }
+    version, err := ifMatch(c)
+    if err != nil {
+        return err
+    }
 
     var u models.User
-    if err := db.DB.First(&u, "id = ?", id).Error; err != nil {
-        return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+    if err := dbOf(c).First(&u, "id = ?", id).Error; err != nil {
+        return problem.New(404, "User not found")
     }
 
-	if u.ID == id {
-		return c.Status(400).JSON(fiber.Map{"error": "Cannot update self"})
-	}
-	
-	
+    if me, _ := middleware.CurrentUser(c); u.Username == me {
+        return problem.New(400, "Cannot update self")
+    }
     if isSuperUser(&u) {
-        return c.Status(403).JSON(fiber.Map{"error": "Cannot modify super user"})
+        return problem.New(403, "Cannot modify super user")
     }
 
     var input models.User
     if err := c.BodyParser(&input); err != nil {
-        return c.Status(400).JSON(fiber.Map{"error": "Invalid input"})
+        return problem.New(400, "Invalid input")
     }
+    role := strings.ToLower(strings.TrimSpace(input.Role))
 
-    u.Role = input.Role
-    if err := db.DB.Save(&u).Error; err != nil {
-        return c.Status(500).JSON(fiber.Map{"error": "Failed to update user"})
+    var v validate.Validator
+    v.Required("role", role)
+    v.OneOf("role", role, assignableRoles...)
+    if err := v.Err(); err != nil {
+        return validationError(err)
     }
 
-    return c.JSON(fiber.Map{"message": "User updated successfully"})
+    who, _ := middleware.CurrentUser(c)
+    return versionedUpdate(c, dbOf(c).Select(userColumns), &models.User{}, id, version, map[string]any{"role": role},
+        emitting("user.updated", id, who))
 }
 
 // DELETE /api/admin/users/:id
+// Moves the user to the trash; see RestoreTrash.
 func DeleteUser(c *fiber.Ctx) error {
     id := c.Params("id")
+    if err := validate.ID("id", id); err != nil {
+        return validationError(err)
+    }
 
     var u models.User
-    if err := db.DB.First(&u, "id = ?", id).Error; err != nil {
-        return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+    if err := dbOf(c).First(&u, "id = ?", id).Error; err != nil {
+        return problem.New(404, "User not found")
     }
     if isSuperUser(&u) {
-        return c.Status(403).JSON(fiber.Map{"error": "Cannot delete super user"})
+        return problem.New(403, "Cannot delete super user")
     }
 
-    if err := db.DB.Delete(&u).Error; err != nil {
-        return c.Status(500).JSON(fiber.Map{"error": "Failed to delete user"})
+    who, _ := middleware.CurrentUser(c)
+    if err := dbOf(c).Transaction(func(tx *gorm.DB) error {
+        if err := softDelete(tx, &models.User{}, u.ID, who); err != nil {
+            return err
+        }
+        return emit(tx, "user.deleted", u.ID, who)
+    }); err != nil {
+        return problem.New(500, "Failed to delete user")
     }
     return c.JSON(fiber.Map{"message": "User deleted"})
 }
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/problem"
+	"admin/validate"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// inEffect is the condition for contracts covering today.
+const inEffect = "vendor_contracts.start_date <= CURRENT_DATE AND (vendor_contracts.end_date IS NULL OR vendor_contracts.end_date >= CURRENT_DATE)"
+
+// vendorQuery applies the list filters (q, category, associationId for
+// vendors with a contract in effect there, includeDeleted).
+func vendorQuery(c *fiber.Ctx) *gorm.DB {
+	q := strings.TrimSpace(c.Query("q"))
+
+	tx := db.DB.Model(&models.Vendor{})
+	if c.QueryBool("includeDeleted") {
+		tx = tx.Unscoped()
+	}
+	if q != "" {
+		tx = vendorSearch.apply(tx, "vendors", q)
+	}
+	if cat := strings.ToLower(strings.TrimSpace(c.Query("category"))); cat != "" {
+		contains, _ := json.Marshal([]string{cat})
+		tx = tx.Where("categories @> ?::jsonb", string(contains))
+	}
+	if a := c.Query("associationId"); a != "" {
+		tx = tx.Where("vendors.id IN (?)", db.DB.Model(&models.VendorContract{}).
+			Select("vendor_id").
+			Where("association_id = ?", a).
+			Where(inEffect))
+	}
+	return tx.Order("name asc")
+}
+
+// GET /api/admin/data/vendors?q=pool&category=pool&associationId=uuid&includeDeleted=true
+func ListVendors(c *fiber.Ctx) error {
+	if a := c.Query("associationId"); a != "" {
+		if err := validate.ID("associationId", a); err != nil {
+			return validationError(err)
+		}
+	}
+	var list []models.Vendor
+	if err := vendorQuery(c).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load vendors")
+	}
+	return c.JSON(list)
+}
+
+// GET /api/admin/data/vendors/expiring?days=30
+// Vendors whose license or insurance certificate has expired or expires
+// within days (default 30), soonest first.
+func ListExpiringCertificates(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 0 || days > 3660 {
+		return problem.New(http.StatusBadRequest, "days must be between 0 and 3660")
+	}
+	cutoff := today().AddDate(0, 0, days)
+	var list []models.Vendor
+	err := db.DB.Where("license_expires <= ? OR insurance_expires <= ?", cutoff, cutoff).
+		Order("least(license_expires, insurance_expires) asc").Order("name asc").
+		Find(&list).Error
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load expiring certificates")
+	}
+	return c.JSON(list)
+}
+
+// vendorInput is a create or update body; nil fields were not sent.
+type vendorInput struct {
+	Name             *string  `json:"name"`
+	Categories       []string `json:"categories"`
+	ContactName      *string  `json:"contactName"`
+	Email            *string  `json:"email"`
+	Phone            *string  `json:"phone"`
+	Website          *string  `json:"website"`
+	Address          *string  `json:"address"`
+	LicenseNumber    *string  `json:"licenseNumber"`
+	LicenseExpires   *string  `json:"licenseExpires"`
+	InsuranceExpires *string  `json:"insuranceExpires"`
+	Notes            *string  `json:"notes"`
+}
+
+// optionalDate parses a sent date field into *dst; an empty string clears it.
+func optionalDate(v *validate.Validator, field string, value *string, dst **time.Time) {
+	if value == nil {
+		return
+	}
+	*dst = nil
+	if *value == "" {
+		return
+	}
+	v.Date(field, *value)
+	if d, err := time.Parse(time.DateOnly, *value); err == nil {
+		*dst = &d
+	}
+}
+
+// validate checks the sent fields and copies them into m.
+func (in *vendorInput) validate(v *validate.Validator, m *models.Vendor) {
+	trimAll(in.Name, in.ContactName, in.Email, in.Phone, in.Website, in.Address,
+		in.LicenseNumber, in.LicenseExpires, in.InsuranceExpires, in.Notes)
+	for _, f := range []struct {
+		name  string
+		value *string
+		max   int
+		dst   *string
+	}{
+		{"name", in.Name, 200, &m.Name},
+		{"contactName", in.ContactName, 200, &m.ContactName},
+		{"email", in.Email, 254, &m.Email},
+		{"phone", in.Phone, 30, &m.Phone},
+		{"website", in.Website, 300, &m.Website},
+		{"address", in.Address, 300, &m.Address},
+		{"licenseNumber", in.LicenseNumber, 100, &m.LicenseNumber},
+		{"notes", in.Notes, 2000, &m.Notes},
+	} {
+		if f.value != nil {
+			v.MaxLen(f.name, *f.value, f.max)
+			*f.dst = *f.value
+		}
+	}
+	if in.Name != nil {
+		v.Required("name", *in.Name)
+	}
+	if in.Email != nil && *in.Email != "" {
+		v.Email("email", *in.Email)
+	}
+	if in.Categories != nil {
+		m.Categories = models.StringList{}
+		for _, cat := range in.Categories {
+			cat = strings.ToLower(strings.TrimSpace(cat))
+			v.MaxLen("categories", cat, 50)
+			if cat != "" && !slices.Contains(m.Categories, cat) {
+				m.Categories = append(m.Categories, cat)
+			}
+		}
+	}
+	optionalDate(v, "licenseExpires", in.LicenseExpires, &m.LicenseExpires)
+	optionalDate(v, "insuranceExpires", in.InsuranceExpires, &m.InsuranceExpires)
+}
+
+// columns returns the sent fields of m, as validated, as column updates.
+func (in *vendorInput) columns(m *models.Vendor) map[string]any {
+	updates := map[string]any{}
+	set := func(col string, sent bool, value any) {
+		if sent {
+			updates[col] = value
+		}
+	}
+	set("name", in.Name != nil, m.Name)
+	set("categories", in.Categories != nil, m.Categories)
+	set("contact_name", in.ContactName != nil, m.ContactName)
+	set("email", in.Email != nil, m.Email)
+	set("phone", in.Phone != nil, m.Phone)
+	set("website", in.Website != nil, m.Website)
+	set("address", in.Address != nil, m.Address)
+	set("license_number", in.LicenseNumber != nil, m.LicenseNumber)
+	set("license_expires", in.LicenseExpires != nil, m.LicenseExpires)
+	set("insurance_expires", in.InsuranceExpires != nil, m.InsuranceExpires)
+	set("notes", in.Notes != nil, m.Notes)
+	return updates
+}
+
+// POST /api/admin/data/vendors
+// Body: { "name": "...", "categories": ["pool", "landscaping"], "contactName": "...",
+// "email": "...", "phone": "...", "website": "...", "address": "...", "licenseNumber": "...",
+// "licenseExpires": "2026-03-31", "insuranceExpires": "2026-01-15", "notes": "..." }
+func CreateVendor(c *fiber.Ctx) error {
+	var in vendorInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if in.Name == nil {
+		in.Name = new(string)
+	}
+	m := models.Vendor{Categories: models.StringList{}}
+	var v validate.Validator
+	in.validate(&v, &m)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	if err := db.DB.Create(&m).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(m)
+}
+
+// GET /api/admin/data/vendors/:id
+// Includes the vendor's contracts, most recent first, with their associations.
+func GetVendor(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var m models.Vendor
+	err := db.DB.Preload("Contracts", func(tx *gorm.DB) *gorm.DB { return tx.Order("start_date desc") }).
+		Preload("Contracts.Association", unscoped).
+		First(&m, "id = ?", id).Error
+	if err != nil {
+		return problem.New(http.StatusNotFound, "Vendor not found")
+	}
+	setETag(c, &m)
+	return c.JSON(m)
+}
+
+// PUT /api/admin/data/vendors/:id
+// Header: If-Match: "<version>" (from the ETag of a GET)
+// Body (any subset): the fields of CreateVendor; empty dates clear them.
+func UpdateVendor(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in vendorInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	var m models.Vendor
+	var v validate.Validator
+	v.UUID("id", id)
+	in.validate(&v, &m)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	updates := in.columns(&m)
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.Vendor{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/vendors/:id
+// Moves the vendor to the trash; its contracts are kept.
+func DeleteVendor(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	who, _ := middleware.CurrentUser(c)
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		return softDelete(tx, &models.Vendor{}, id, who)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return problem.New(http.StatusNotFound, "Vendor not found")
+	}
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
+
+// contractInput is a create or update body; nil fields were not sent.
+type contractInput struct {
+	AssociationID *string `json:"associationId"`
+	Service       *string `json:"service"`
+	StartDate     *string `json:"startDate"`
+	EndDate       *string `json:"endDate"`
+	Notes         *string `json:"notes"`
+}
+
+// validate checks the sent fields and copies them into k, so the period can
+// be checked as a whole.
+func (in *contractInput) validate(v *validate.Validator, k *models.VendorContract) {
+	trimAll(in.AssociationID, in.Service, in.StartDate, in.EndDate, in.Notes)
+	if in.Service != nil {
+		*in.Service = strings.ToLower(*in.Service)
+		v.MaxLen("service", *in.Service, 50)
+		k.Service = *in.Service
+	}
+	if in.Notes != nil {
+		v.MaxLen("notes", *in.Notes, 2000)
+		k.Notes = *in.Notes
+	}
+	if in.StartDate != nil {
+		v.Required("startDate", *in.StartDate)
+		v.Date("startDate", *in.StartDate)
+		k.StartDate, _ = time.Parse(time.DateOnly, *in.StartDate)
+	}
+	optionalDate(v, "endDate", in.EndDate, &k.EndDate)
+	if !v.Has("startDate") && !v.Has("endDate") && k.EndDate != nil && k.EndDate.Before(k.StartDate) {
+		v.Add("endDate", "must not be before startDate")
+	}
+}
+
+// POST /api/admin/data/vendors/:id/contracts
+// Body: { "associationId": "uuid", "service": "pool", "startDate": "2025-01-01",
+// "endDate": "2025-12-31", "notes": "..." }
+func CreateContract(c *fiber.Ctx) error {
+	vendorID := c.Params("id")
+	var in contractInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	for _, f := range []**string{&in.AssociationID, &in.StartDate} {
+		if *f == nil {
+			*f = new(string)
+		}
+	}
+	k := models.VendorContract{VendorID: vendorID, AssociationID: *in.AssociationID}
+	var v validate.Validator
+	v.UUID("id", vendorID)
+	v.UUID("associationId", *in.AssociationID)
+	in.validate(&v, &k)
+	if !v.Has("associationId") {
+		var a models.Association
+		v.Check(db.DB.First(&a, "id = ?", k.AssociationID).Error == nil, "associationId", "association not found")
+	}
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	var m models.Vendor
+	if err := db.DB.First(&m, "id = ?", vendorID).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Vendor not found")
+	}
+	if err := db.DB.Omit("Vendor", "Association").Create(&k).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(k)
+}
+
+// PUT /api/admin/data/contracts/:id
+// Header: If-Match: "<version>"
+// Body (any subset): { "service": "...", "startDate": "...", "endDate": "...", "notes": "..." };
+// an empty endDate makes the contract open-ended.
+func UpdateContract(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in contractInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	var k models.VendorContract
+	if err := db.DB.First(&k, "id = ?", id).Error; err != nil {
+		return problem.New(http.StatusNotFound, "Contract not found")
+	}
+	var v validate.Validator
+	v.Check(in.AssociationID == nil || *in.AssociationID == k.AssociationID, "associationId", "cannot be changed")
+	in.validate(&v, &k)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	updates := map[string]any{}
+	if in.Service != nil {
+		updates["service"] = k.Service
+	}
+	if in.StartDate != nil {
+		updates["start_date"] = k.StartDate
+	}
+	if in.EndDate != nil {
+		updates["end_date"] = k.EndDate
+	}
+	if in.Notes != nil {
+		updates["notes"] = k.Notes
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, db.DB, &models.VendorContract{}, id, version, updates)
+}
+
+// DELETE /api/admin/data/contracts/:id
+// Removes a contract entered in error; to end one, set endDate instead.
+func DeleteContract(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.VendorContract{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Contract not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
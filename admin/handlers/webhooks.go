@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"admin/db"
+	"admin/middleware"
+	"admin/models"
+	"admin/outbox"
+	"admin/problem"
+	"admin/secret"
+	"admin/validate"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// webhookQuery loads webhooks without their secret, which is only shown
+// when created or rotated.
+func webhookQuery() *gorm.DB {
+	return db.DB.Omit("secret")
+}
+
+// newWebhookSecret is 32 random bytes, hex-encoded.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GET /api/admin/webhooks   (super only)
+func ListWebhooks(c *fiber.Ctx) error {
+	var list []models.Webhook
+	if err := webhookQuery().Order("created_at asc").Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load webhooks")
+	}
+	return c.JSON(list)
+}
+
+// webhookInput is a create or update body; nil fields were not sent.
+type webhookInput struct {
+	URL         *string  `json:"url"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+	Description *string  `json:"description"`
+}
+
+// validate checks the sent fields and copies them into w.
+func (in *webhookInput) validate(v *validate.Validator, w *models.Webhook) {
+	trimAll(in.URL, in.Description)
+	if in.URL != nil {
+		v.Required("url", *in.URL)
+		v.MaxLen("url", *in.URL, 500)
+		u, err := url.Parse(*in.URL)
+		v.Check(err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "", "url", "must be an http or https URL")
+		w.URL = *in.URL
+	}
+	if in.Events != nil {
+		w.Events = models.StringList{}
+		for _, e := range in.Events {
+			v.OneOf("events", e, outbox.EventTypes...)
+			if !slices.Contains(w.Events, e) {
+				w.Events = append(w.Events, e)
+			}
+		}
+	}
+	if in.Active != nil {
+		w.Active = *in.Active
+	}
+	if in.Description != nil {
+		v.MaxLen("description", *in.Description, 500)
+		w.Description = *in.Description
+	}
+}
+
+// POST /api/admin/webhooks   (super only)
+// Body: { "url": "https://...", "events": ["association.created"], "active": true, "description": "..." }
+// No events (or an empty list) subscribes to every event. The response
+// carries the generated signing secret; it is not shown again.
+func CreateWebhook(c *fiber.Ctx) error {
+	var in webhookInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	if in.URL == nil {
+		in.URL = new(string)
+	}
+	w := models.Webhook{Events: models.StringList{}, Active: true}
+	var v validate.Validator
+	in.validate(&v, &w)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	key, err := newWebhookSecret()
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to generate secret")
+	}
+	w.Secret = secret.String(key)
+	w.CreatedBy, _ = middleware.CurrentUser(c)
+	if err := db.DB.Create(&w).Error; err != nil {
+		return dbError(err, "Create failed")
+	}
+	return c.Status(http.StatusCreated).JSON(w)
+}
+
+// PUT /api/admin/webhooks/:id   (super only)
+// Header: If-Match: "<version>"
+// Body (any subset): the fields of CreateWebhook. Deactivating stops new
+// deliveries; those already queued are still sent.
+func UpdateWebhook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	version, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+	var in webhookInput
+	if err := c.BodyParser(&in); err != nil {
+		return problem.New(http.StatusBadRequest, "Invalid input")
+	}
+	var w models.Webhook
+	var v validate.Validator
+	v.UUID("id", id)
+	in.validate(&v, &w)
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+	updates := map[string]any{}
+	if in.URL != nil {
+		updates["url"] = w.URL
+	}
+	if in.Events != nil {
+		updates["events"] = w.Events
+	}
+	if in.Active != nil {
+		updates["active"] = w.Active
+	}
+	if in.Description != nil {
+		updates["description"] = w.Description
+	}
+	if len(updates) == 0 {
+		return problem.New(http.StatusBadRequest, "No changes")
+	}
+	return versionedUpdate(c, webhookQuery(), &models.Webhook{}, id, version, updates)
+}
+
+// POST /api/admin/webhooks/:id/secret   (super only)
+// Replaces the signing secret and returns the new one. Deliveries sent
+// from now on are signed with it.
+func RotateWebhookSecret(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	key, err := newWebhookSecret()
+	if err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to generate secret")
+	}
+	res := db.DB.Model(&models.Webhook{}).Where("id = ?", id).
+		Updates(map[string]any{"secret": secret.String(key), "version": nextVersion})
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Update failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Webhook not found")
+	}
+	return c.JSON(fiber.Map{"secret": key})
+}
+
+// DELETE /api/admin/webhooks/:id   (super only)
+// Deletes the webhook with its delivery history.
+func DeleteWebhook(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Delete(&models.Webhook{}, "id = ?", id)
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Delete failed")
+	}
+	if res.RowsAffected == 0 {
+		return problem.New(http.StatusNotFound, "Webhook not found")
+	}
+	return c.JSON(fiber.Map{"message": "Deleted"})
+}
+
+var deliveryStatuses = []string{"pending", "delivered", "dead"}
+
+// GET /api/admin/webhooks/deliveries?status=dead&webhookId=uuid&limit=100   (super only)
+// Deliveries newest first, each with its event; status=dead is the
+// dead-letter queue.
+func ListDeliveries(c *fiber.Ctx) error {
+	status := strings.TrimSpace(c.Query("status"))
+	webhookID := strings.TrimSpace(c.Query("webhookId"))
+	limit := c.QueryInt("limit", 100)
+	var v validate.Validator
+	if status != "" {
+		v.OneOf("status", status, deliveryStatuses...)
+	}
+	if webhookID != "" {
+		v.UUID("webhookId", webhookID)
+	}
+	v.Check(limit >= 1 && limit <= 500, "limit", "must be between 1 and 500")
+	if err := v.Err(); err != nil {
+		return validationError(err)
+	}
+
+	tx := db.DB.Preload("Event")
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if webhookID != "" {
+		tx = tx.Where("webhook_id = ?", webhookID)
+	}
+	var list []models.WebhookDelivery
+	if err := tx.Order("created_at desc").Limit(limit).Find(&list).Error; err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to load deliveries")
+	}
+	return c.JSON(list)
+}
+
+// POST /api/admin/webhooks/deliveries/:id/retry   (super only)
+// Queues a dead delivery again with a fresh set of attempts.
+func RetryDelivery(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.ID("id", id); err != nil {
+		return validationError(err)
+	}
+	res := db.DB.Model(&models.WebhookDelivery{}).Where("id = ? AND status = 'dead'", id).
+		Updates(map[string]any{"status": "pending", "attempts": 0, "next_attempt_at": time.Now(), "last_error": ""})
+	if res.Error != nil {
+		return problem.New(http.StatusInternalServerError, "Retry failed")
+	}
+	if res.RowsAffected == 0 {
+		var d models.WebhookDelivery
+		if err := db.DB.First(&d, "id = ?", id).Error; err != nil {
+			return problem.New(http.StatusNotFound, "Delivery not found")
+		}
+		return problem.New(http.StatusConflict, "Only dead deliveries can be retried").With("status", d.Status)
+	}
+	return c.JSON(fiber.Map{"message": "Queued"})
+}
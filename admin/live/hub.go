@@ -0,0 +1,158 @@
+// Package live relays outbox events to the admin UI as they are committed.
+// Each replica keeps one connection LISTENing on db.EventChannel; Run loads
+// every settled event once and hands it to the subscribers in this process,
+// which filter and stream it (see handlers.StreamEvents).
+//
+// Events are streamed in (Xid, Seq) order. An event is settled once every
+// transaction with a lower id than its own has ended, so no event can later
+// commit ahead of it in that order, and a client resuming after an event
+// misses nothing.
+package live
+
+import (
+	"admin/db"
+	"admin/models"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+const (
+	// subscriberBuffer is how many events a subscriber may fall behind
+	// before it is dropped; the client then resumes with Last-Event-ID.
+	subscriberBuffer = 256
+	// settlePoll is how often held-back events are looked at again when no
+	// notification arrives; the transaction holding them may have ended
+	// without writing an event.
+	settlePoll = time.Second
+)
+
+// settled selects the events no open transaction can still precede.
+const settled = "xid < pg_snapshot_xmin(pg_current_snapshot())::text::bigint"
+
+// Latest returns the newest settled event, or a zero event if there is none.
+func Latest(gdb *gorm.DB) (models.OutboxEvent, error) {
+	var e models.OutboxEvent
+	err := gdb.Where(settled).Order("xid DESC, seq DESC").Limit(1).Find(&e).Error
+	return e, err
+}
+
+// After returns up to limit settled events that follow from in stream
+// order; limit 0 means all of them.
+func After(gdb *gorm.DB, from models.OutboxEvent, limit int) ([]models.OutboxEvent, error) {
+	q := gdb.Where(settled).Where("(xid, seq) > (?, ?)", from.Xid, from.Seq).Order("xid, seq")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	var events []models.OutboxEvent
+	return events, q.Find(&events).Error
+}
+
+// Hub fans events out to subscribers.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[chan models.OutboxEvent]struct{}
+}
+
+// Events is the process-wide hub.
+var Events = &Hub{subs: map[chan models.OutboxEvent]struct{}{}}
+
+// Subscribe returns a channel of new events and a function that ends the
+// subscription. The channel is closed when the subscriber falls too far
+// behind or the hub loses its database connection, as events may then
+// have been missed.
+func (h *Hub) Subscribe() (<-chan models.OutboxEvent, func()) {
+	ch := make(chan models.OutboxEvent, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() { h.drop(ch) }
+}
+
+func (h *Hub) drop(ch chan models.OutboxEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *Hub) publish(e models.OutboxEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// dropAll ends every subscription.
+func (h *Hub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Run listens for events on dsn until ctx is done, loading each from gdb
+// once it is settled and publishing it, and reconnects after a failure.
+func (h *Hub) Run(ctx context.Context, dsn string, gdb *gorm.DB) {
+	for ctx.Err() == nil {
+		err := h.listen(ctx, dsn, gdb)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("live: listener failed, reconnecting:", err)
+		// Notifications sent while disconnected are lost.
+		h.dropAll()
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context, dsn string, gdb *gorm.DB) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+db.EventChannel); err != nil {
+		return err
+	}
+	// Earlier events are the business of each subscriber's replay.
+	last, err := Latest(gdb)
+	if err != nil {
+		return err
+	}
+	for {
+		wait, cancel := context.WithTimeout(ctx, settlePoll)
+		_, err := conn.WaitForNotification(wait)
+		cancel()
+		if err != nil && !pgconn.Timeout(err) {
+			return err
+		}
+		events, err := After(gdb, last, 0)
+		if err != nil {
+			log.Println("live: cannot load events:", err)
+			continue
+		}
+		for _, e := range events {
+			h.publish(e)
+			last = e
+		}
+	}
+}
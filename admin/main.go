@@ -1,54 +1,56 @@
 package main
 
 import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
     "os"
 
-    "github.com/gofiber/fiber/v2"
-    "github.com/gofiber/fiber/v2/middleware/cors"
-    "admin/handlers"
-    "admin/middleware"
+    "admin/analytics"
+    "admin/geo"
+    "admin/live"
+    "admin/openapi"
+    "admin/outbox"
+    "admin/secret"
+    "admin/server"
+    "admin/storage"
 	"admin/db"
 )
 
 func main() {
+    checkOnly := flag.Bool("openapi-check", false, "verify routes match openapi/openapi.yaml and exit")
+    flag.Parse()
+
+    spec, err := openapi.Load()
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    app := server.New(spec, os.Getenv("OPENAPI_ENFORCE") != "")
+
+    if err := spec.CheckRoutes(app); err != nil {
+        log.Fatal(err)
+    }
+    if *checkOnly {
+        fmt.Println("✅ Routes match openapi.yaml")
+        return
+    }
+
+	if err := secret.Init(); err != nil {
+		log.Fatal(err)
+	}
+	if err := storage.Init(); err != nil {
+		log.Fatal(err)
+	}
+	if err := geo.Init(); err != nil {
+		log.Fatal(err)
+	}
 	db.InitDB()
 	db.SeedTestData()
-    app := fiber.New()
-
-    app.Use(cors.New(cors.Config{
-        AllowOrigins: "http://localhost:5173",
-        AllowCredentials: true,
-        AllowMethods: "GET,POST,DELETE,OPTIONS,PUT",
-        AllowHeaders: "Origin, Content-Type, Accept",
-    }))
-
-    admin := app.Group("/api/admin",
-        middleware.JWTProtected(),
-		middleware.RequireAnyRole("super", "admin"),
-    )
-	
-
-	admin.Get("/users", handlers.ListUsers)
-	admin.Post("/users", handlers.CreateUser)
-	admin.Delete("/users/:id", handlers.DeleteUser)
-	admin.Put("/users/:id/role", handlers.UpdateUserRole)
-    
-	data := admin.Group("/data",
-		middleware.JWTProtected(),
-		middleware.RequireAnyRole("super", "admin"),
-	)
-
-	data.Get("/associations", handlers.ListAssociations)
-	data.Post("/associations", handlers.CreateAssociation)
-	data.Delete("/associations/:id", handlers.DeleteAssociation)
-	data.Put("/associations/:id", handlers.UpdateAssociation)
-    
-	data.Get("/managers", handlers.ListManagers)
-	data.Post("/managers", handlers.CreateManager)
-	data.Delete("/managers/:id", handlers.DeleteManager)
-	data.Put("/managers/:id", handlers.UpdateManager)
-	
-
+	go outbox.Run(context.Background(), db.DB)
+	go live.Events.Run(context.Background(), os.Getenv("DATABASE_URL"), db.DB)
+	go analytics.Run(context.Background(), os.Getenv("DATABASE_URL"), db.DB)
 
     port := os.Getenv("PORT")
     if port == "" {
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"admin/problem"
 	"os"
+
 	"github.com/gofiber/fiber/v2"
 	jwtware "github.com/gofiber/jwt/v3"
 	"github.com/golang-jwt/jwt/v4"
@@ -15,6 +17,9 @@ func JWTProtected() fiber.Handler {
 		ContextKey:    "user", // stored in c.Locals("user")
 		TokenLookup:   "cookie:token",
 		SigningMethod: "HS256",
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return problem.New(fiber.StatusUnauthorized, "Invalid or missing token")
+		},
 	})
 }
 
@@ -24,23 +29,17 @@ func RequireAnyRole(roles ...string) fiber.Handler {
         // Fiber's JWT middleware stores a *jwt.Token in Locals
         user, ok := c.Locals("user").(*jwt.Token)
         if !ok || user == nil {
-            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-                "error": "Invalid or missing token",
-            })
+            return problem.New(fiber.StatusUnauthorized, "Invalid or missing token")
         }
 
         claims, ok := user.Claims.(jwt.MapClaims)
         if !ok {
-            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-                "error": "Invalid claims",
-            })
+            return problem.New(fiber.StatusUnauthorized, "Invalid claims")
         }
 
         role, ok := claims["role"].(string)
         if !ok {
-            return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-                "error": "Role missing from token",
-            })
+            return problem.New(fiber.StatusForbidden, "Role missing from token")
         }
 
         for _, allowed := range roles {
@@ -49,8 +48,22 @@ func RequireAnyRole(roles ...string) fiber.Handler {
             }
         }
 
-        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-            "error": "Insufficient privileges",
-        })
+        return problem.New(fiber.StatusForbidden, "Insufficient privileges")
     }
 }
+
+// CurrentUser returns the username and role from the request's JWT claims.
+// Both are empty when the route is not behind JWTProtected.
+func CurrentUser(c *fiber.Ctx) (username, role string) {
+	user, ok := c.Locals("user").(*jwt.Token)
+	if !ok || user == nil {
+		return "", ""
+	}
+	claims, ok := user.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", ""
+	}
+	username, _ = claims["username"].(string)
+	role, _ = claims["role"].(string)
+	return username, role
+}
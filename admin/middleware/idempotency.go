@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"admin/db"
+	"admin/models"
+	"admin/problem"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// HeaderIdempotencyKey names a POST so it can be retried safely.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed marks a response replayed for a repeated key.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	// idempotencyRetention is how long a key's response is kept.
+	idempotencyRetention = 24 * time.Hour
+	// idempotencyLease is how long the first request with a key may run
+	// before a retry takes the key over, e.g. after a crash.
+	idempotencyLease = 5 * time.Minute
+)
+
+// idempotencyService scopes this service's keys in the table.
+const idempotencyService = "admin"
+
+// replayedHeaders are the response headers stored with the body. Cookies
+// are never stored, as they may carry a session.
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderLocation, fiber.HeaderETag}
+
+// Idempotency makes a POST with an Idempotency-Key header safe to retry.
+// The first request with a key runs and its response is stored; a repeat
+// with the same method, URL and body gets that response back, marked
+// Idempotent-Replayed, without running again. Reusing the key for a
+// different request is refused with 422, and repeating it while the first
+// is still running with 409. Keys are per caller and kept for 24 hours.
+// 5xx responses are not stored, so a retry runs the request again.
+// Use it behind JWTProtected.
+func Idempotency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get(HeaderIdempotencyKey))
+		if c.Method() != fiber.MethodPost || key == "" {
+			return c.Next()
+		}
+		if len(key) > 255 {
+			return problem.New(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		}
+		owner, _ := CurrentUser(c)
+		fp := fingerprint(c)
+		row := models.IdempotencyKey{Service: idempotencyService, Owner: owner, Key: key, Fingerprint: fp}
+		claimed, err := claimKey(&row)
+		if err != nil {
+			return problem.New(http.StatusInternalServerError, "Failed to check Idempotency-Key")
+		}
+		if !claimed {
+			return replay(c, row, fp)
+		}
+
+		// First use: run the request and keep what it answered.
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				releaseKey(row)
+				return err
+			}
+		}
+		if c.Response().StatusCode() >= http.StatusInternalServerError {
+			releaseKey(row)
+			return nil
+		}
+		storeResponse(c, row)
+		return nil
+	}
+}
+
+// fingerprint identifies a request by method, URL and body. It is keyed
+// with the JWT secret, as bodies may hold passwords.
+func fingerprint(c *fiber.Ctx) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	mac.Write(c.Body())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// keyOf selects row's key.
+func keyOf(row models.IdempotencyKey) *gorm.DB {
+	return db.DB.Model(&models.IdempotencyKey{}).
+		Where("service = ? AND owner = ? AND key = ?", row.Service, row.Owner, row.Key)
+}
+
+// claimKey stores row's key for this request and reports true, or reports
+// false and loads the stored row for the key into row. An expired key, or
+// one whose first request outlived its lease, is taken over.
+func claimKey(row *models.IdempotencyKey) (bool, error) {
+	fp := row.Fingerprint
+	for range 2 {
+		row.Headers = "{}"
+		res := db.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+		if res.Error != nil {
+			return false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			// Good time to forget expired keys.
+			db.DB.Where("created_at < ?", time.Now().Add(-idempotencyRetention)).Delete(&models.IdempotencyKey{})
+			return true, nil
+		}
+		now := time.Now()
+		res = keyOf(*row).
+			Where("created_at < ? OR (status = 0 AND created_at < ?)", now.Add(-idempotencyRetention), now.Add(-idempotencyLease)).
+			Updates(map[string]any{"fingerprint": fp, "status": 0, "headers": "{}", "body": nil, "created_at": now})
+		if res.Error != nil {
+			return false, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return true, nil
+		}
+		err := keyOf(*row).Take(row).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, err
+		}
+		row.Fingerprint = fp
+		// Released in between; try again.
+	}
+	return false, errors.New("idempotency key keeps changing")
+}
+
+// replay answers with the response stored in row, if it was for the
+// request with fingerprint fp and has finished.
+func replay(c *fiber.Ctx, row models.IdempotencyKey, fp string) error {
+	if row.Fingerprint != fp {
+		return problem.New(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	}
+	if row.Status == 0 {
+		return problem.New(http.StatusConflict, "A request with this Idempotency-Key is still running; retry later")
+	}
+	var headers map[string][]string
+	if err := json.Unmarshal([]byte(row.Headers), &headers); err != nil {
+		return problem.New(http.StatusInternalServerError, "Failed to replay response")
+	}
+	for name, values := range headers {
+		for _, v := range values {
+			c.Response().Header.Add(name, v)
+		}
+	}
+	c.Set(HeaderIdempotentReplayed, "true")
+	return c.Status(row.Status).Send(row.Body)
+}
+
+// storeResponse saves the response c sent for row's key.
+func storeResponse(c *fiber.Ctx, row models.IdempotencyKey) {
+	headers := map[string][]string{}
+	for _, name := range replayedHeaders {
+		for _, v := range c.Response().Header.PeekAll(name) {
+			headers[name] = append(headers[name], string(v))
+		}
+	}
+	encoded, _ := json.Marshal(headers)
+	err := keyOf(row).Updates(map[string]any{
+		"status":  c.Response().StatusCode(),
+		"headers": string(encoded),
+		"body":    append([]byte(nil), c.Response().Body()...),
+	}).Error
+	if err != nil {
+		log.Println("idempotency: cannot store response:", err)
+		releaseKey(row)
+	}
+}
+
+// releaseKey forgets row's key so the request can be retried.
+func releaseKey(row models.IdempotencyKey) {
+	if err := keyOf(row).Where("status = 0").Delete(&models.IdempotencyKey{}).Error; err != nil {
+		log.Println("idempotency: cannot release key:", err)
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ManagerAssignment is one period of a manager working on an association in
+// a role. Periods are half-open: the assignment is in effect from
+// EffectiveFrom up to, but not including, EffectiveTo, so a handover on a
+// date ends one period and starts the next on the same day.
+//
+// Association.ManagerID mirrors the open primary assignment; there is at
+// most one per association.
+type ManagerAssignment struct {
+	ID            string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	AssociationID string       `gorm:"type:uuid;not null;index;uniqueIndex:idx_assignments_open_primary,where:role = 'primary' AND effective_to IS NULL"`
+	Association   *Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Purging a manager forgets their assignment history.
+	ManagerID string   `gorm:"type:uuid;not null;index"`
+	Manager   *Manager `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Role is primary, assistant or accountant.
+	Role          string    `gorm:"not null"`
+	EffectiveFrom time.Time `gorm:"type:date;not null"`
+	// EffectiveTo is nil while the assignment is current.
+	EffectiveTo *time.Time `gorm:"type:date"`
+	// Reason says why the assignment started, EndReason why it ended.
+	Reason    string `gorm:"not null;default:''"`
+	EndReason string `gorm:"not null;default:''"`
+	// ChangedBy is the user who recorded the assignment.
+	ChangedBy string `gorm:"not null;default:''"`
+	CreatedAt time.Time
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
@@ -1,12 +1,54 @@
 package models
 
-type Association struct {
-	ID			string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	LegalName 	string `gorm:"not null"`
-	FilterName 	string `gorm:"not null"`
-	Location 	string `gorm:"not null"`
-	ManagerID   string `gorm:"type:uuid;not null"`
-	Manager     Manager `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+import (
+	"admin/secret"
+	"time"
 
+	"gorm.io/gorm"
+)
 
+type Association struct {
+	ID         string  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	LegalName  string  `gorm:"not null"`
+	FilterName string  `gorm:"not null"`
+	Location   string  `gorm:"not null"`
+	ManagerID  string  `gorm:"type:uuid;not null"`
+	Manager    Manager `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	// Location is the short display label; the address fields below are
+	// the structured postal address. New columns default to empty so
+	// existing rows migrate cleanly.
+	Street string `gorm:"not null;default:''"`
+	City   string `gorm:"not null;default:''"`
+	County string `gorm:"not null;default:''"`
+	State  string `gorm:"size:2;not null;default:''"`
+	ZIP    string `gorm:"size:10;not null;default:''"`
+	// Type is condo, hoa or coop; empty when unknown.
+	Type string `gorm:"not null;default:''"`
+	// UnitCount is 0 when unknown.
+	UnitCount int `gorm:"not null;default:0"`
+	// FiscalYearEnd is the month and day the fiscal year closes, "MM-DD".
+	FiscalYearEnd   string `gorm:"size:5;not null;default:''"`
+	StateCorpNumber string `gorm:"not null;default:''"`
+	// EIN is encrypted at rest; see package secret.
+	EIN               secret.String `gorm:"not null;default:''"`
+	IncorporationDate *time.Time    `gorm:"type:date"`
+	// Latitude and Longitude place the association by its ZIP or city in
+	// the gazetteer (see package geo); nil when it cannot be placed.
+	// GeoPrecision is "zip" or "city", or empty then.
+	Latitude     *float64
+	Longitude    *float64
+	GeoPrecision string `gorm:"not null;default:''"`
+	// GeoFilled lists the address fields geocoding filled in rather than a
+	// user, e.g. "city,county". They are derived, so each geocode works them
+	// out again, until they are set explicitly.
+	GeoFilled string         `gorm:"not null;default:''"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	DeletedBy string
+	// Tags are linked through AssociationTag.
+	Tags []Tag `gorm:"many2many:association_tags"`
+	// Custom holds the values of the custom fields defined for this kind of
+	// record; see CustomField.
+	Custom CustomValues `gorm:"type:jsonb;not null;default:'{}';index:,type:gin"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// BoardMember is one term of a person on an association's board. A person
+// re-elected or moving to another office gets a new record, so the records
+// for an association are its board history.
+type BoardMember struct {
+	ID            string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	AssociationID string       `gorm:"type:uuid;not null;index"`
+	Association   *Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Name          string       `gorm:"not null"`
+	// Office is president, vice-president, secretary, treasurer or director.
+	Office    string    `gorm:"not null"`
+	TermStart time.Time `gorm:"type:date;not null"`
+	// TermEnd is nil for a term with no fixed end.
+	TermEnd *time.Time `gorm:"type:date;index"`
+	// Contact data, shown only within the viewer's association scope.
+	Email          string `gorm:"not null;default:''"`
+	Phone          string `gorm:"not null;default:''"`
+	MailingAddress string `gorm:"not null;default:''"`
+	ContactHidden  bool   `gorm:"-"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
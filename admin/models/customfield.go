@@ -0,0 +1,74 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// CustomField defines an extra attribute that associations or managers carry
+// in their Custom column, e.g. a gate code or a preferred bank.
+type CustomField struct {
+	ID string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	// Entity is associations or managers.
+	Entity string `gorm:"not null;uniqueIndex:idx_custom_fields_key"`
+	// Key names the value in Custom: a lower-case letter, then lower-case
+	// letters, digits and underscores.
+	Key   string `gorm:"not null;uniqueIndex:idx_custom_fields_key"`
+	Label string `gorm:"not null"`
+	// Type is text, number, date, enum or boolean.
+	Type string `gorm:"not null"`
+	// Options are the values an enum field accepts.
+	Options  StringList `gorm:"type:jsonb;not null;default:'[]'"`
+	Required bool       `gorm:"not null;default:false"`
+	// Min and Max bound a number field's value or a text field's length.
+	Min       *float64
+	Max       *float64
+	CreatedBy string `gorm:"not null;default:''"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// StringList is a list of strings stored as a JSON array.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *StringList) Scan(src any) error {
+	return scanJSON(src, l)
+}
+
+// CustomValues are custom field values by key, stored as a JSON object:
+// strings for text, date (YYYY-MM-DD) and enum fields, numbers and booleans
+// for the rest.
+type CustomValues map[string]any
+
+func (v CustomValues) Value() (driver.Value, error) {
+	if v == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]any(v))
+	return string(b), err
+}
+
+func (v *CustomValues) Scan(src any) error {
+	return scanJSON(src, v)
+}
+
+func scanJSON(src, dst any) error {
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(s, dst)
+	case string:
+		return json.Unmarshal([]byte(s), dst)
+	}
+	return fmt.Errorf("cannot scan %T as JSON", src)
+}
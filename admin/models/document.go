@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// Document is a file kept for an association, e.g. its bylaws or the
+// minutes of a meeting. The file itself is a series of DocumentVersions;
+// the highest Number is current.
+type Document struct {
+	ID            string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	AssociationID string       `gorm:"type:uuid;not null;index"`
+	Association   *Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Title         string       `gorm:"not null"`
+	// Type is governing, budget, minutes, financial, insurance, contract or other.
+	Type string `gorm:"not null;index"`
+	// EffectiveDate is when the document took effect or the meeting was held.
+	EffectiveDate *time.Time `gorm:"type:date"`
+	Versions      []DocumentVersion
+	CreatedBy     string `gorm:"not null;default:''"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// DocumentVersion is one uploaded file of a Document. The bytes live in
+// storage under StorageKey.
+type DocumentVersion struct {
+	ID         string    `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	DocumentID string    `gorm:"type:uuid;not null;uniqueIndex:idx_document_versions_number"`
+	Document   *Document `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Number counts the document's uploads from 1.
+	Number      int    `gorm:"not null;uniqueIndex:idx_document_versions_number"`
+	FileName    string `gorm:"not null"`
+	ContentType string `gorm:"not null"`
+	Size        int64  `gorm:"not null"`
+	// SHA256 is the hex digest of the file.
+	SHA256     string `gorm:"not null"`
+	StorageKey string `gorm:"not null"`
+	Note       string `gorm:"not null;default:''"`
+	UploadedBy string `gorm:"not null;default:''"`
+	CreatedAt  time.Time
+}
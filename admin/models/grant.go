@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AssociationGrant puts an association in an admin's scope, letting them see
+// its restricted data such as owner contact details. Super users have every
+// association in scope without grants.
+type AssociationGrant struct {
+	UserID        string      `gorm:"type:uuid;primaryKey"`
+	User          User        `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	AssociationID string      `gorm:"type:uuid;primaryKey;index"`
+	Association   Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	GrantedBy     string
+	CreatedAt     time.Time
+}
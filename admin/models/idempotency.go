@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers a POST sent with an Idempotency-Key header, so
+// a retry gets the first response back instead of repeating the write.
+// Keys are per service and per caller.
+type IdempotencyKey struct {
+	Service string `gorm:"primaryKey"`
+	// Owner is the username of the caller; empty for anonymous requests.
+	Owner string `gorm:"primaryKey"`
+	Key   string `gorm:"primaryKey"`
+	// Fingerprint identifies the request the key was first sent with.
+	Fingerprint string `gorm:"not null"`
+	// Status is 0 while that request is still running.
+	Status int `gorm:"not null;default:0"`
+	// Headers are the replayed response headers, as a JSON object of lists.
+	Headers   string `gorm:"type:jsonb;not null;default:'{}'"`
+	Body      []byte
+	CreatedAt time.Time `gorm:"not null;index"`
+}
@@ -1,11 +1,20 @@
 package models
 
+import "gorm.io/gorm"
+
 type Manager struct {
-	ID 			string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	Email 		string `gorm:"uniqueIndex;not null"`
-	Name 		string `gorm:"not null"`
-	Titles 		string `gorm:"not null"`
-	Initials 	string `gorm:"not null"`
-	Associations    []Association `gorm:"foreignKey:ManagerID"`
+	ID string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	// Unique among live rows only, so a trashed manager's email can be reused.
+	Email        string         `gorm:"uniqueIndex:idx_managers_email_live,where:deleted_at IS NULL;not null"`
+	Name         string         `gorm:"not null"`
+	Titles       string         `gorm:"not null"`
+	Initials     string         `gorm:"not null"`
+	Associations []Association  `gorm:"foreignKey:ManagerID"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	DeletedBy    string
+	// Custom holds the values of the custom fields defined for this kind of
+	// record; see CustomField.
+	Custom CustomValues `gorm:"type:jsonb;not null;default:'{}';index:,type:gin"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
 }
-
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Merge is the audit record of a duplicate folded into the record it
+// duplicates. The ids are kept without foreign keys so the record survives
+// purges.
+type Merge struct {
+	ID string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	// Kind is associations or managers.
+	Kind string `gorm:"not null;index"`
+	// SurvivorID is the record kept; MergedID the one moved to the trash.
+	SurvivorID string `gorm:"type:uuid;not null;index"`
+	MergedID   string `gorm:"type:uuid;not null;index"`
+	// Fields says which record each field was taken from, as JSON, e.g.
+	// {"email": "merged", "name": "survivor"}.
+	Fields string `gorm:"type:jsonb;not null;default:'{}'"`
+	// Survivor and Merged are both records as they were before, as JSON.
+	Survivor string `gorm:"type:jsonb;not null;default:'{}'"`
+	Merged   string `gorm:"type:jsonb;not null;default:'{}'"`
+	// Repointed counts the rows moved from the merged record to the
+	// survivor, by table, as JSON.
+	Repointed string `gorm:"type:jsonb;not null;default:'{}'"`
+	Reason    string `gorm:"not null;default:''"`
+	MergedBy  string `gorm:"not null"`
+	CreatedAt time.Time
+}
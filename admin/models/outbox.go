@@ -0,0 +1,62 @@
+package models
+
+import (
+	"admin/secret"
+	"time"
+)
+
+// OutboxEvent is a domain event, e.g. "association.created", written in the
+// same transaction as the change it describes. The dispatcher fans each
+// event out to the matching webhooks once, then stamps FannedOutAt.
+type OutboxEvent struct {
+	ID string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	// Seq numbers events in insertion order; it is the SSE event id.
+	Seq int64 `gorm:"autoIncrement;not null;uniqueIndex;index:idx_outbox_events_stream,priority:2"`
+	// Xid is the id of the writing transaction. Seq is taken at insert, not
+	// commit, so the SSE stream orders events by (Xid, Seq) instead and
+	// holds each back until no older transaction is still open (see live).
+	Xid      int64  `gorm:"not null;default:(pg_current_xact_id()::text::bigint);index:idx_outbox_events_stream,priority:1"`
+	Type     string `gorm:"not null;index"`
+	EntityID string `gorm:"type:uuid;not null;index"`
+	// Data is the entity as written, as JSON.
+	Data        string `gorm:"type:jsonb;not null;default:'{}'"`
+	Actor       string `gorm:"not null;default:''"`
+	CreatedAt   time.Time
+	FannedOutAt *time.Time `gorm:"index:,where:fanned_out_at IS NULL"`
+}
+
+// Webhook is an endpoint that receives events as signed POSTs.
+type Webhook struct {
+	ID  string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	URL string `gorm:"not null"`
+	// Secret keys the HMAC signature; it is shown only when created or rotated.
+	Secret secret.String `gorm:"not null"`
+	// Events are the event types delivered; empty means all.
+	Events      StringList `gorm:"type:jsonb;not null;default:'[]'"`
+	Active      bool       `gorm:"not null"`
+	Description string     `gorm:"not null;default:''"`
+	CreatedBy   string     `gorm:"not null;default:''"`
+	CreatedAt   time.Time
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// WebhookDelivery is one event owed to one webhook. It is pending until a
+// 2xx response marks it delivered; after the last failed attempt it is dead
+// and waits for a manual retry.
+type WebhookDelivery struct {
+	ID        string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	WebhookID string       `gorm:"type:uuid;not null;index"`
+	Webhook   *Webhook     `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	EventID   string       `gorm:"type:uuid;not null;index"`
+	Event     *OutboxEvent `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Status is pending, delivered or dead.
+	Status        string    `gorm:"not null;default:pending;index"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	// LastStatus is the HTTP status of the last attempt, 0 if none came back.
+	LastStatus  int    `gorm:"not null;default:0"`
+	LastError   string `gorm:"not null;default:''"`
+	DeliveredAt *time.Time
+	CreatedAt   time.Time
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Reassignment is the audit record of one bulk move of associations between
+// primary managers.
+type Reassignment struct {
+	ID     string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Reason string `gorm:"not null"`
+	// Rule is the JSON rule the moves were derived from, if any.
+	Rule      string `gorm:"not null;default:''"`
+	AppliedBy string `gorm:"not null"`
+	CreatedAt time.Time
+	Moves     []ReassignmentMove `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// ReassignmentMove is one association moved by a Reassignment. The ids are
+// kept without foreign keys so the record survives purges.
+type ReassignmentMove struct {
+	ID             string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	ReassignmentID string `gorm:"type:uuid;not null;index"`
+	AssociationID  string `gorm:"type:uuid;not null;index"`
+	LegalName      string `gorm:"not null"`
+	FromManagerID  string `gorm:"type:uuid;not null"`
+	ToManagerID    string `gorm:"type:uuid;not null"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Tag groups associations across managers, e.g. "high-rise" or "litigation
+// pending". Names are unique regardless of case.
+type Tag struct {
+	ID        string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name      string `gorm:"not null"`
+	CreatedBy string `gorm:"not null;default:''"`
+	CreatedAt time.Time
+	// AssociationCount is filled in by ListTags.
+	AssociationCount int64 `gorm:"->;-:migration"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// AssociationTag links a tag to an association; see Association.Tags.
+type AssociationTag struct {
+	AssociationID string      `gorm:"type:uuid;primaryKey"`
+	Association   Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	TagID         string      `gorm:"type:uuid;primaryKey;index"`
+	Tag           Tag         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	TaggedBy      string
+	CreatedAt     time.Time
+}
+
+// Segment is a saved association list filter that every admin can reuse.
+type Segment struct {
+	ID          string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name        string `gorm:"not null;uniqueIndex"`
+	Description string `gorm:"not null;default:''"`
+	// Query is the filter as ListAssociations query parameters, e.g.
+	// "state=FL&tags=high-rise".
+	Query     string `gorm:"not null"`
+	CreatedBy string `gorm:"not null;default:''"`
+	CreatedAt time.Time
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Unit is a dwelling or other space within an association.
+type Unit struct {
+	ID            string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	AssociationID string       `gorm:"type:uuid;not null;uniqueIndex:idx_units_number_live,priority:1,where:deleted_at IS NULL"`
+	Association   *Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:RESTRICT;"`
+	// Number is the unit's designation within its association, e.g. "101"
+	// or "B-4"; unique per association among live rows.
+	Number string `gorm:"not null;uniqueIndex:idx_units_number_live,priority:2"`
+	Street string `gorm:"not null;default:''"`
+	City   string `gorm:"not null;default:''"`
+	State  string `gorm:"size:2;not null;default:''"`
+	ZIP    string `gorm:"size:10;not null;default:''"`
+	// Type is residential, commercial, parking or storage.
+	Type       string         `gorm:"not null;default:'residential'"`
+	Ownerships []Ownership    `gorm:"foreignKey:UnitID"`
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
+	DeletedBy  string
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// Owner is a person or company that owns or lives in units. Email, Phone
+// and MailingAddress are contact data, shown only to users whose association
+// scope covers one of the owner's units.
+type Owner struct {
+	ID             string      `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name           string      `gorm:"not null"`
+	Email          string      `gorm:"not null;default:''"`
+	Phone          string      `gorm:"not null;default:''"`
+	MailingAddress string      `gorm:"not null;default:''"`
+	Ownerships     []Ownership `gorm:"foreignKey:OwnerID"`
+	// ContactHidden is set on responses whose contact data was withheld.
+	ContactHidden bool           `gorm:"-"`
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+	DeletedBy     string
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// Ownership links an owner or resident to a unit for a period. EndDate is
+// nil while the period is current.
+type Ownership struct {
+	ID      string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UnitID  string `gorm:"type:uuid;not null;index"`
+	Unit    *Unit  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	OwnerID string `gorm:"type:uuid;not null;index"`
+	Owner   *Owner `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Role is owner or resident.
+	Role      string     `gorm:"not null"`
+	StartDate time.Time  `gorm:"type:date;not null"`
+	EndDate   *time.Time `gorm:"type:date"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
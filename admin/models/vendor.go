@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Vendor is a contractor that serves associations, e.g. a landscaper.
+type Vendor struct {
+	ID   string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name string `gorm:"not null"`
+	// Categories are the lower-case services offered, e.g. "pool".
+	Categories    StringList `gorm:"type:jsonb;not null;default:'[]';index:,type:gin"`
+	ContactName   string     `gorm:"not null;default:''"`
+	Email         string     `gorm:"not null;default:''"`
+	Phone         string     `gorm:"not null;default:''"`
+	Website       string     `gorm:"not null;default:''"`
+	Address       string     `gorm:"not null;default:''"`
+	LicenseNumber string     `gorm:"not null;default:''"`
+	// LicenseExpires and InsuranceExpires are certificate expiry dates; nil
+	// when not on file.
+	LicenseExpires   *time.Time `gorm:"type:date;index"`
+	InsuranceExpires *time.Time `gorm:"type:date;index"`
+	Notes            string     `gorm:"not null;default:''"`
+	Contracts        []VendorContract
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+	DeletedBy        string
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
+
+// VendorContract is a period in which a vendor serves an association.
+type VendorContract struct {
+	ID            string       `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	VendorID      string       `gorm:"type:uuid;not null;index"`
+	Vendor        *Vendor      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	AssociationID string       `gorm:"type:uuid;not null;index"`
+	Association   *Association `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Service is the category the contract covers.
+	Service   string    `gorm:"not null;default:''"`
+	StartDate time.Time `gorm:"type:date;not null"`
+	// EndDate is the last day of service; nil while open-ended.
+	EndDate *time.Time `gorm:"type:date"`
+	Notes   string     `gorm:"not null;default:''"`
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
+}
@@ -0,0 +1,184 @@
+package openapi_test
+
+import (
+	"admin/db"
+	"admin/geo"
+	"admin/openapi"
+	"admin/secret"
+	"admin/server"
+	"admin/storage"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// contractCase is one request and the status it must get. Every response is
+// also checked against openapi.yaml by Enforce, which turns a mismatch into
+// a 500.
+type contractCase struct {
+	name    string
+	method  string
+	path    string
+	body    string
+	headers map[string]string
+	status  int
+}
+
+func newApp(t *testing.T) *fiber.App {
+	t.Helper()
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := server.New(spec, true)
+	if err := spec.CheckRoutes(app); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// do sends tc to app and fails unless it gets tc.status.
+func do(t *testing.T, app *fiber.App, tc contractCase) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+	if tc.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range tc.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != tc.status {
+		t.Fatalf("%s %s: status %d, want %d: %s", tc.method, tc.path, resp.StatusCode, tc.status, body)
+	}
+	return resp, body
+}
+
+func run(t *testing.T, cases []contractCase) {
+	t.Helper()
+	app := newApp(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			do(t, app, tc)
+		})
+	}
+}
+
+// session returns the cookie header for a token with role, as the auth
+// service issues it.
+func session(t *testing.T, role string) map[string]string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": "alice", "role": role, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"Cookie": "token=" + signed}
+}
+
+func with(headers map[string]string, k, v string) map[string]string {
+	out := map[string]string{k: v}
+	for hk, hv := range headers {
+		out[hk] = hv
+	}
+	return out
+}
+
+const someID = "00000000-0000-4000-8000-000000000001"
+
+func TestContract(t *testing.T) {
+	super := session(t, "super")
+	run(t, []contractCase{
+		{name: "spec", method: http.MethodGet, path: "/openapi.json", status: 200},
+		{name: "docs", method: http.MethodGet, path: "/docs", status: 200},
+		{name: "docs script", method: http.MethodGet, path: "/docs/try.js", status: 200},
+		{name: "no token", method: http.MethodGet, path: "/api/admin/users", status: 401},
+		{name: "bad token", method: http.MethodGet, path: "/api/admin/users",
+			headers: map[string]string{"Cookie": "token=not-a-jwt"}, status: 401},
+		{name: "user role", method: http.MethodGet, path: "/api/admin/users", headers: session(t, "user"), status: 403},
+		{name: "merge as admin", method: http.MethodPost, path: "/api/admin/data/merges",
+			body: `{}`, headers: session(t, "admin"), status: 403},
+		{name: "long Idempotency-Key", method: http.MethodPost, path: "/api/admin/data/managers",
+			body: `{}`, headers: with(super, "Idempotency-Key", strings.Repeat("k", 256)), status: 400},
+		{name: "update without If-Match", method: http.MethodPut, path: "/api/admin/data/associations/" + someID,
+			body: `{}`, headers: super, status: 428},
+		{name: "association with a bad id", method: http.MethodGet, path: "/api/admin/data/associations/not-a-uuid",
+			headers: super, status: 422},
+		{name: "nearby in a bad unit", method: http.MethodGet, path: "/api/admin/data/associations/nearby?unit=parsec",
+			headers: super, status: 422},
+		{name: "duplicates of a bad kind", method: http.MethodGet, path: "/api/admin/data/duplicates?kind=bogus",
+			headers: super, status: 422},
+		{name: "merges with a bad limit", method: http.MethodGet, path: "/api/admin/data/merges?limit=0",
+			headers: super, status: 422},
+		{name: "empty merge", method: http.MethodPost, path: "/api/admin/data/merges", body: `{}`, headers: super, status: 422},
+		{name: "empty reassignment", method: http.MethodPost, path: "/api/admin/data/reassignments",
+			body: `{}`, headers: super, status: 422},
+		{name: "changes with a bad date", method: http.MethodGet, path: "/api/admin/analytics/changes?from=soon",
+			headers: super, status: 422},
+	})
+}
+
+// TestContractDB covers the requests that reach the database; it runs when
+// DATABASE_URL names one.
+func TestContractDB(t *testing.T) {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	if os.Getenv("DOCUMENT_DIR") == "" {
+		t.Setenv("DOCUMENT_DIR", t.TempDir())
+	}
+	for _, init := range []func() error{secret.Init, storage.Init, geo.Init} {
+		if err := init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.InitDB()
+	app := newApp(t)
+	super := session(t, "super")
+	stamp := time.Now().Format("150405.000")
+
+	_, body := do(t, app, contractCase{method: http.MethodPost, path: "/api/admin/data/managers", headers: super, status: 201,
+		body: `{"name":"Contract ` + stamp + `","email":"contract-` + stamp + `@example.com","titles":"CAM","initials":"CT"}`})
+	var manager struct{ ID string }
+	if err := json.Unmarshal(body, &manager); err != nil {
+		t.Fatal(err)
+	}
+	_, body = do(t, app, contractCase{method: http.MethodPost, path: "/api/admin/data/associations", headers: super, status: 201,
+		body: `{"legalName":"Contract ` + stamp + `","filterName":"Contract ` + stamp + `","location":"Tampa","managerId":"` + manager.ID + `"}`})
+	var association struct{ ID string }
+	if err := json.Unmarshal(body, &association); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := do(t, app, contractCase{method: http.MethodGet, path: "/api/admin/data/associations/" + association.ID,
+		headers: super, status: 200})
+	do(t, app, contractCase{method: http.MethodPut, path: "/api/admin/data/associations/" + association.ID,
+		headers: with(super, "If-Match", resp.Header.Get("ETag")), body: `{"location":"Miami"}`, status: 200})
+
+	for _, path := range []string{
+		"/api/admin/users",
+		"/api/admin/data/associations",
+		"/api/admin/data/managers",
+		"/api/admin/data/duplicates",
+		"/api/admin/data/merges",
+		"/api/admin/analytics/changes",
+		"/api/admin/search?q=contract",
+	} {
+		do(t, app, contractCase{method: http.MethodGet, path: path, headers: super, status: 200})
+	}
+	do(t, app, contractCase{method: http.MethodDelete, path: "/api/admin/data/associations/" + association.ID,
+		headers: super, status: 200})
+}
@@ -0,0 +1,220 @@
+package outbox
+
+import (
+	"admin/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxAttempts is how often a delivery is tried before it is dead.
+	MaxAttempts = 10
+	// pollInterval is how often Run looks for work.
+	pollInterval = 2 * time.Second
+	// batchSize bounds the events fanned out and deliveries sent per poll.
+	batchSize = 50
+	// lease is how long a claimed delivery is hidden from other replicas
+	// while it is sent. Deliveries are claimed one at a time, so it only
+	// needs to outlast one send, at most sendTimeout.
+	lease = time.Minute
+	// sendTimeout bounds one delivery's request.
+	sendTimeout = 10 * time.Second
+)
+
+var client = &http.Client{Timeout: sendTimeout}
+
+// Backoff is the wait after the given failed attempt: 30s, 1m, 2m, …,
+// capped at 6h.
+func Backoff(attempt int) time.Duration {
+	d := 30 * time.Second << min(max(attempt-1, 0), 10)
+	return min(d, 6*time.Hour)
+}
+
+// Sign is the X-Webhook-Signature value for body sent at timestamp (Unix
+// seconds): "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>".
+// Receivers recompute it with the webhook's secret and compare in constant
+// time, and reject stale timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Run fans out and delivers events until ctx is done. Any number of
+// replicas may run it; rows are claimed with SKIP LOCKED.
+func Run(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := fanOut(db); err != nil {
+			log.Println("outbox: fan-out failed:", err)
+		}
+		if err := deliverDue(ctx, db); err != nil {
+			log.Println("outbox: delivery failed:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fanOut creates a pending delivery of each new event for every active
+// webhook subscribed to its type.
+func fanOut(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var events []models.OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("fanned_out_at IS NULL").Order("created_at").Limit(batchSize).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		var hooks []models.Webhook
+		if err := tx.Select("id", "events").Where("active").Find(&hooks).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		ids := make([]string, len(events))
+		var deliveries []models.WebhookDelivery
+		for i, e := range events {
+			ids[i] = e.ID
+			for _, h := range hooks {
+				if len(h.Events) == 0 || slices.Contains(h.Events, e.Type) {
+					deliveries = append(deliveries, models.WebhookDelivery{
+						WebhookID: h.ID, EventID: e.ID, Status: "pending", NextAttemptAt: now,
+					})
+				}
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.Omit("Webhook", "Event").Create(&deliveries).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Update("fanned_out_at", now).Error
+	})
+}
+
+// deliverDue sends up to batchSize pending deliveries that are due. Each is
+// claimed just before it is sent, pushing its next attempt out by lease so
+// no other replica sends it meanwhile; claiming the whole batch up front
+// would let the lease of the last lapse while the first are still sent.
+func deliverDue(ctx context.Context, db *gorm.DB) error {
+	for range batchSize {
+		if ctx.Err() != nil {
+			return nil
+		}
+		d, ok, err := claimDue(db)
+		if err != nil || !ok {
+			return err
+		}
+		if err := deliver(ctx, db, d); err != nil {
+			log.Println("outbox: delivery", d.ID+":", err)
+		}
+	}
+	return nil
+}
+
+// claimDue claims the pending delivery due longest, if any. Deliveries to
+// an inactive webhook are parked: they stay pending, and are sent once it
+// is active again.
+func claimDue(db *gorm.DB) (models.WebhookDelivery, bool, error) {
+	var due []models.WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = 'pending' AND next_attempt_at <= ?", time.Now()).
+			Where("webhook_id IN (SELECT id FROM webhooks WHERE active)").
+			Order("next_attempt_at").Limit(1).
+			Find(&due).Error
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id = ?", due[0].ID).
+			Update("next_attempt_at", time.Now().Add(lease)).Error
+	})
+	if err != nil || len(due) == 0 {
+		return models.WebhookDelivery{}, false, err
+	}
+	return due[0], true, nil
+}
+
+// deliver sends d once and records the outcome. Failing to load its webhook
+// or event counts as a failed attempt too, so such a delivery ends up dead
+// rather than pending for ever.
+func deliver(ctx context.Context, db *gorm.DB, d models.WebhookDelivery) error {
+	var hook models.Webhook
+	var event models.OutboxEvent
+	var body []byte
+	var status int
+	sendErr := db.First(&hook, "id = ?", d.WebhookID).Error
+	if sendErr == nil && !hook.Active {
+		// Deactivated since it was claimed; leave it parked.
+		return nil
+	}
+	if sendErr == nil {
+		sendErr = db.First(&event, "id = ?", d.EventID).Error
+	}
+	if sendErr == nil {
+		body, sendErr = Payload(event)
+	}
+	if sendErr == nil {
+		status, sendErr = send(ctx, hook, d.ID, event.Type, body)
+	}
+	attempts := d.Attempts + 1
+	updates := map[string]any{"attempts": attempts, "last_status": status, "last_error": ""}
+	switch {
+	case sendErr == nil:
+		updates["status"] = "delivered"
+		updates["delivered_at"] = time.Now()
+	case attempts >= MaxAttempts:
+		updates["status"] = "dead"
+		updates["last_error"] = sendErr.Error()
+	default:
+		updates["next_attempt_at"] = time.Now().Add(Backoff(attempts))
+		updates["last_error"] = sendErr.Error()
+	}
+	return db.Model(&models.WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error
+}
+
+// send POSTs body to hook, returning the response status (0 if none) and
+// an error unless it is 2xx.
+func send(ctx context.Context, hook models.Webhook, deliveryID, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cns-admin-webhooks")
+	req.Header.Set("X-Webhook-Id", deliveryID)
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(now, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(string(hook.Secret), now, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return resp.StatusCode, nil
+}
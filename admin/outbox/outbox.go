@@ -0,0 +1,50 @@
+// Package outbox records domain events in the transaction of the change
+// they describe and delivers them to webhooks. Because the event row
+// commits or rolls back with the change, subscribers never hear of a write
+// that did not happen, nor miss one that did.
+//
+// Run delivers in the background: each event is fanned out to the active
+// webhooks subscribed to its type, and each delivery is POSTed with an HMAC
+// signature, retried with exponential backoff, and marked dead after
+// MaxAttempts failures.
+package outbox
+
+import (
+	"admin/models"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventTypes are the event types recorded, as "<entity>.<change>".
+var EventTypes = []string{
+	"association.created", "association.updated", "association.deleted", "association.restored",
+	"manager.created", "manager.updated", "manager.deleted", "manager.restored",
+	"user.created", "user.updated", "user.deleted", "user.restored",
+}
+
+// envelope is how an event is sent to subscribers.
+type envelope struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	EntityID   string          `json:"entityId"`
+	Actor      string          `json:"actor"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Payload is e as JSON, the body of webhook deliveries and live updates.
+func Payload(e models.OutboxEvent) ([]byte, error) {
+	return json.Marshal(envelope{e.ID, e.Type, e.EntityID, e.Actor, e.CreatedAt, json.RawMessage(e.Data)})
+}
+
+// Record adds an event of type typ for entityID to tx, with data (the
+// entity as written) as its JSON payload.
+func Record(tx *gorm.DB, typ, entityID, actor string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxEvent{Type: typ, EntityID: entityID, Data: string(payload), Actor: actor}).Error
+}
@@ -0,0 +1,108 @@
+// Package problem implements RFC 7807 problem details, the error body shared
+// by the Go services. See docs/errors.md for the contract.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const ContentType = "application/problem+json"
+
+// Problem is both an error a handler can return and the JSON body the
+// error handler writes for it.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+
+	// extensions are extra members such as "errors" for field errors.
+	extensions map[string]any
+}
+
+// New returns a problem for status whose type and title come from the status.
+func New(status int, detail string) *Problem {
+	return &Problem{
+		Type:   TypeFor(status),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Validation is a 422 carrying per-field errors under "errors".
+func Validation(errs any) *Problem {
+	p := New(http.StatusUnprocessableEntity, "One or more fields are invalid")
+	p.Type, p.Title = "/problems/validation", "Validation failed"
+	return p.With("errors", errs)
+}
+
+// TypeFor maps a status to the problem type URI used for it, e.g.
+// 404 -> "/problems/not-found".
+func TypeFor(status int) string {
+	text := http.StatusText(status)
+	if text == "" || status == http.StatusInternalServerError {
+		return "/problems/internal"
+	}
+	return "/problems/" + strings.ToLower(strings.ReplaceAll(text, " ", "-"))
+}
+
+// With adds an extension member and returns p.
+func (p *Problem) With(key string, value any) *Problem {
+	if p.extensions == nil {
+		p.extensions = map[string]any{}
+	}
+	p.extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// MarshalJSON writes the standard members followed by any extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type plain Problem
+	body, err := json.Marshal((*plain)(p))
+	if err != nil || len(p.extensions) == 0 {
+		return body, err
+	}
+	ext, err := json.Marshal(p.extensions)
+	if err != nil {
+		return nil, err
+	}
+	// Splice the two objects: {...standard,...extensions}
+	return append(append(body[:len(body)-1], ','), ext[1:]...), nil
+}
+
+// Handler is a fiber.ErrorHandler that writes every error returned by a
+// handler or middleware as problem+json. Errors that are neither a *Problem
+// nor a *fiber.Error are logged and reported as a bare 500.
+func Handler(c *fiber.Ctx, err error) error {
+	var p *Problem
+	var fe *fiber.Error
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &fe):
+		p = New(fe.Code, fe.Message)
+	default:
+		log.Println("Unhandled error:", err)
+		p = New(fiber.StatusInternalServerError, "")
+	}
+
+	out := *p
+	out.Instance = c.Path()
+	out.RequestID = c.GetRespHeader(fiber.HeaderXRequestID)
+	return c.Status(out.Status).JSON(&out, ContentType)
+}
@@ -0,0 +1,92 @@
+// Package secret encrypts sensitive columns at rest with AES-256-GCM. The key
+// comes from FIELD_ENCRYPTION_KEY (32 bytes, base64) and is loaded once at
+// startup with Init.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var aead cipher.AEAD
+
+// ErrNoKey is returned when a String is read or written before Init.
+var ErrNoKey = errors.New("secret: FIELD_ENCRYPTION_KEY is not configured")
+
+// Init loads the key from FIELD_ENCRYPTION_KEY. Generate one with
+// `openssl rand -base64 32`.
+func Init() error {
+	raw := os.Getenv("FIELD_ENCRYPTION_KEY")
+	if raw == "" {
+		return ErrNoKey
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(key) != 32 {
+		return errors.New("secret: FIELD_ENCRYPTION_KEY must be 32 bytes, base64-encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// String is a string stored encrypted. It is plain text in Go and JSON; the
+// column holds base64(nonce || ciphertext). The empty string is stored as is,
+// so an unset value needs no key.
+type String string
+
+// Value encrypts s for the database.
+func (s String) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	if aead == nil {
+		return nil, ErrNoKey
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(s), nil)), nil
+}
+
+// Scan decrypts a column value.
+func (s *String) Scan(src any) error {
+	var enc string
+	switch v := src.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		enc = v
+	case []byte:
+		enc = string(v)
+	default:
+		return fmt.Errorf("secret: cannot scan %T", src)
+	}
+	if enc == "" {
+		*s = ""
+		return nil
+	}
+	if aead == nil {
+		return ErrNoKey
+	}
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil || len(data) < aead.NonceSize() {
+		return errors.New("secret: malformed ciphertext")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return errors.New("secret: cannot decrypt; wrong FIELD_ENCRYPTION_KEY?")
+	}
+	*s = String(plain)
+	return nil
+}
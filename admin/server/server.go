@@ -0,0 +1,172 @@
+// Package server assembles the admin service's fiber app: its middleware
+// and every route.
+package server
+
+import (
+	"admin/handlers"
+	"admin/middleware"
+	"admin/openapi"
+	"admin/problem"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// New returns the app serving spec and every route. With enforce, each
+// response is checked against spec; see openapi.Document.Enforce.
+func New(spec *openapi.Document, enforce bool) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.Handler,
+		// Room for a document upload (see handlers.maxDocumentSize).
+		BodyLimit: 32 << 20,
+	})
+
+	app.Use(requestid.New())
+	if enforce {
+		app.Use(spec.Enforce())
+	}
+
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:     "http://localhost:5173",
+		AllowCredentials: true,
+		AllowMethods:     "GET,POST,DELETE,OPTIONS,PUT",
+		AllowHeaders:     "Origin, Content-Type, Accept, Last-Event-ID, Idempotency-Key, If-Match",
+		ExposeHeaders:    "X-Request-ID, Idempotent-Replayed, ETag",
+	}))
+
+	spec.Register(app)
+
+	admin := app.Group("/api/admin",
+		middleware.JWTProtected(),
+		middleware.RequireAnyRole("super", "admin"),
+		middleware.Idempotency(),
+	)
+
+	admin.Get("/users", handlers.ListUsers)
+	admin.Get("/users/export", handlers.ExportUsers)
+	admin.Post("/users", handlers.CreateUser)
+	admin.Get("/users/:id", handlers.GetUser)
+	admin.Delete("/users/:id", handlers.DeleteUser)
+	admin.Put("/users/:id/role", handlers.UpdateUserRole)
+	admin.Get("/users/:id/associations", middleware.RequireAnyRole("super"), handlers.ListUserGrants)
+	admin.Put("/users/:id/associations", middleware.RequireAnyRole("super"), handlers.SetUserGrants)
+
+	admin.Get("/search", handlers.Search)
+	admin.Get("/events", handlers.StreamEvents)
+	admin.Post("/graphql", handlers.GraphQL)
+	admin.Post("/batch", handlers.Batch)
+
+	admin.Get("/analytics/managers", handlers.AnalyticsManagers)
+	admin.Get("/analytics/distribution", handlers.AnalyticsDistribution)
+	admin.Get("/analytics/workload", handlers.AnalyticsWorkload)
+	admin.Get("/analytics/recent", handlers.AnalyticsRecent)
+	admin.Get("/analytics/completeness", handlers.AnalyticsCompleteness)
+	admin.Get("/analytics/changes", handlers.AnalyticsChanges)
+
+	admin.Get("/custom-fields", handlers.ListCustomFields)
+	admin.Post("/custom-fields", middleware.RequireAnyRole("super"), handlers.CreateCustomField)
+	admin.Put("/custom-fields/:id", middleware.RequireAnyRole("super"), handlers.UpdateCustomField)
+	admin.Delete("/custom-fields/:id", middleware.RequireAnyRole("super"), handlers.DeleteCustomField)
+
+	admin.Get("/webhooks", middleware.RequireAnyRole("super"), handlers.ListWebhooks)
+	admin.Post("/webhooks", middleware.RequireAnyRole("super"), handlers.CreateWebhook)
+	admin.Get("/webhooks/deliveries", middleware.RequireAnyRole("super"), handlers.ListDeliveries)
+	admin.Post("/webhooks/deliveries/:id/retry", middleware.RequireAnyRole("super"), handlers.RetryDelivery)
+	admin.Put("/webhooks/:id", middleware.RequireAnyRole("super"), handlers.UpdateWebhook)
+	admin.Delete("/webhooks/:id", middleware.RequireAnyRole("super"), handlers.DeleteWebhook)
+	admin.Post("/webhooks/:id/secret", middleware.RequireAnyRole("super"), handlers.RotateWebhookSecret)
+
+	admin.Get("/trash/:kind", handlers.ListTrash)
+	admin.Post("/trash/:kind/:id/restore", handlers.RestoreTrash)
+	admin.Delete("/trash/:kind/:id", middleware.RequireAnyRole("super"), handlers.PurgeTrash)
+
+	data := admin.Group("/data",
+		middleware.JWTProtected(),
+		middleware.RequireAnyRole("super", "admin"),
+	)
+
+	data.Get("/associations", handlers.ListAssociations)
+	data.Get("/associations/export", handlers.ExportAssociations)
+	data.Get("/associations/nearby", handlers.NearbyAssociations)
+	data.Post("/associations/geocode", middleware.RequireAnyRole("super"), handlers.GeocodeAssociations)
+	data.Post("/associations", handlers.CreateAssociation)
+	data.Get("/associations/:id", handlers.GetAssociation)
+	data.Delete("/associations/:id", handlers.DeleteAssociation)
+	data.Put("/associations/:id", handlers.UpdateAssociation)
+
+	data.Get("/managers", handlers.ListManagers)
+	data.Get("/managers/export", handlers.ExportManagers)
+	data.Post("/managers", handlers.CreateManager)
+	data.Get("/managers/:id", handlers.GetManager)
+	data.Delete("/managers/:id", handlers.DeleteManager)
+	data.Put("/managers/:id", handlers.UpdateManager)
+
+	data.Get("/units", handlers.ListUnits)
+	data.Post("/units", handlers.CreateUnit)
+	data.Get("/units/:id", handlers.GetUnit)
+	data.Delete("/units/:id", handlers.DeleteUnit)
+	data.Put("/units/:id", handlers.UpdateUnit)
+	data.Post("/units/:id/ownerships", handlers.CreateOwnership)
+	data.Put("/ownerships/:id", handlers.UpdateOwnership)
+	data.Delete("/ownerships/:id", handlers.DeleteOwnership)
+
+	data.Get("/owners", handlers.ListOwners)
+	data.Post("/owners", handlers.CreateOwner)
+	data.Get("/owners/:id", handlers.GetOwner)
+	data.Delete("/owners/:id", handlers.DeleteOwner)
+	data.Put("/owners/:id", handlers.UpdateOwner)
+
+	data.Get("/associations/:id/board", handlers.ListBoard)
+	data.Get("/associations/:id/board/history", handlers.ListBoardHistory)
+	data.Post("/associations/:id/board", handlers.CreateBoardMember)
+	data.Get("/board/expiring", handlers.ListExpiringTerms)
+	data.Put("/board/:id", handlers.UpdateBoardMember)
+	data.Delete("/board/:id", handlers.DeleteBoardMember)
+
+	data.Get("/associations/:id/managers", handlers.ListAssignments)
+	data.Get("/associations/:id/managers/history", handlers.ListAssignmentHistory)
+	data.Post("/associations/:id/managers", handlers.CreateAssignment)
+	data.Put("/assignments/:id", handlers.UpdateAssignment)
+	data.Get("/reassignments", handlers.ListReassignments)
+	data.Post("/reassignments", handlers.Reassign)
+	data.Get("/duplicates", handlers.ListDuplicates)
+	data.Get("/merges", handlers.ListMerges)
+	data.Post("/merges", middleware.RequireAnyRole("super"), handlers.MergeRecords)
+
+	data.Get("/tags", handlers.ListTags)
+	data.Post("/tags", handlers.CreateTag)
+	data.Put("/tags/:id", handlers.UpdateTag)
+	data.Delete("/tags/:id", handlers.DeleteTag)
+	data.Post("/tags/:id/associations", handlers.TagAssociations)
+	data.Delete("/tags/:id/associations", handlers.UntagAssociations)
+
+	data.Get("/segments", handlers.ListSegments)
+	data.Post("/segments", handlers.CreateSegment)
+	data.Put("/segments/:id", handlers.UpdateSegment)
+	data.Delete("/segments/:id", handlers.DeleteSegment)
+	data.Get("/segments/:id/associations", handlers.ListSegmentAssociations)
+	data.Get("/segments/:id/export", handlers.ExportSegment)
+
+	data.Get("/vendors", handlers.ListVendors)
+	data.Post("/vendors", handlers.CreateVendor)
+	data.Get("/vendors/expiring", handlers.ListExpiringCertificates)
+	data.Get("/vendors/:id", handlers.GetVendor)
+	data.Delete("/vendors/:id", handlers.DeleteVendor)
+	data.Put("/vendors/:id", handlers.UpdateVendor)
+	data.Post("/vendors/:id/contracts", handlers.CreateContract)
+	data.Put("/contracts/:id", handlers.UpdateContract)
+	data.Delete("/contracts/:id", handlers.DeleteContract)
+
+	data.Get("/associations/:id/documents", handlers.ListDocuments)
+	data.Post("/associations/:id/documents", handlers.CreateDocument)
+	data.Get("/documents/:id", handlers.GetDocument)
+	data.Put("/documents/:id", handlers.UpdateDocument)
+	data.Delete("/documents/:id", handlers.DeleteDocument)
+	data.Post("/documents/:id/versions", handlers.CreateDocumentVersion)
+	data.Get("/documents/:id/download", handlers.DownloadDocument)
+
+	data.Post("/import/:kind", handlers.ImportData)
+
+	return app
+}
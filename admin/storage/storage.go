@@ -0,0 +1,69 @@
+// Package storage keeps uploaded files (blobs) outside the database, on the
+// local filesystem or in an S3-compatible bucket. The backend is chosen by
+// DOCUMENT_STORAGE at startup with Init:
+//
+//	local (default)  DOCUMENT_DIR, default "documents"
+//	s3               S3_ENDPOINT, S3_BUCKET, S3_REGION (default us-east-1),
+//	                 S3_ACCESS_KEY, S3_SECRET_KEY
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Store reads and writes blobs by key. Keys are slash-separated paths
+// chosen by the caller, e.g. "documents/<id>/<version id>".
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any blob there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob under key; the caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. A missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrNotFound is returned by Get when no blob is stored under the key.
+var ErrNotFound = errors.New("storage: blob not found")
+
+// Blobs is the configured store; nil until Init.
+var Blobs Store
+
+// Init configures Blobs from the environment.
+func Init() error {
+	switch backend := os.Getenv("DOCUMENT_STORAGE"); backend {
+	case "", "local":
+		dir := os.Getenv("DOCUMENT_DIR")
+		if dir == "" {
+			dir = "documents"
+		}
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return err
+		}
+		Blobs = Local{Dir: dir}
+	case "s3":
+		s := &S3{
+			Endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Client:    &http.Client{Timeout: 5 * time.Minute},
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		if s.Endpoint == "" || s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+			return errors.New("storage: S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required for DOCUMENT_STORAGE=s3")
+		}
+		Blobs = s
+	default:
+		return errors.New("storage: DOCUMENT_STORAGE must be local or s3, not " + backend)
+	}
+	return nil
+}
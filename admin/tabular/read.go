@@ -0,0 +1,124 @@
+// Package tabular reads and writes the spreadsheet files used by the admin
+// import and export endpoints.
+package tabular
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+	JSON = "json" // export only
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported file format; use .csv or .xlsx")
+
+// FormatOf picks a format from a filename's extension.
+func FormatOf(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return CSV, nil
+	case ".xlsx":
+		return XLSX, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// ReadAll returns every row of a CSV file or of the first XLSX sheet,
+// header row included. Trailing blank rows are dropped, and the ' an export
+// puts before formula-like cells is removed.
+func ReadAll(r io.Reader, format string) ([][]string, error) {
+	var rows [][]string
+	switch format {
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		all, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(all) > 0 && len(all[0]) > 0 {
+			all[0][0] = strings.TrimPrefix(all[0][0], "\ufeff") // Excel's UTF-8 BOM
+		}
+		rows = all
+	case XLSX:
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		all, err := f.GetRows(f.GetSheetName(0))
+		if err != nil {
+			return nil, err
+		}
+		rows = all
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	for len(rows) > 0 && isBlank(rows[len(rows)-1]) {
+		rows = rows[:len(rows)-1]
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = restore(cell)
+		}
+	}
+	return rows, nil
+}
+
+func isBlank(row []string) bool {
+	for _, cell := range row {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Columns resolves each wanted field to its index in header. A field is found
+// under mapping[field] when mapped, otherwise under its own name; header names
+// match case-insensitively and ignore spaces, dashes and underscores.
+// Fields with no matching column are absent from the result.
+func Columns(header []string, fields []string, mapping map[string]string) (map[string]int, error) {
+	byName := make(map[string]int, len(header))
+	for i, h := range header {
+		byName[normalize(h)] = i
+	}
+
+	cols := make(map[string]int, len(fields))
+	for _, field := range fields {
+		source, mapped := mapping[field]
+		if !mapped {
+			source = field
+		}
+		i, ok := byName[normalize(source)]
+		if !ok {
+			if mapped {
+				return nil, fmt.Errorf("mapped column %q for %s not found in header", source, field)
+			}
+			continue
+		}
+		cols[field] = i
+	}
+	return cols, nil
+}
+
+func normalize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '_':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(name)))
+}
@@ -0,0 +1,185 @@
+package tabular
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Writer streams rows of values under a fixed set of column names.
+// Close must be called to finish the output.
+type Writer interface {
+	Write(values []any) error
+	Close() error
+}
+
+// ContentType returns the MIME type for an export format.
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSON:
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// NewWriter starts an export in format, writing the header where the format has one.
+func NewWriter(w io.Writer, format string, columns []string) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := &csvWriter{w: csv.NewWriter(w)}
+		if err := cw.w.Write(columns); err != nil {
+			return nil, err
+		}
+		return cw, nil
+	case XLSX:
+		f := excelize.NewFile()
+		sw, err := f.NewStreamWriter(f.GetSheetName(0))
+		if err != nil {
+			return nil, err
+		}
+		xw := &xlsxWriter{out: w, f: f, sw: sw}
+		header := make([]any, len(columns))
+		for i, c := range columns {
+			header[i] = c
+		}
+		if err := xw.Write(header); err != nil {
+			return nil, err
+		}
+		return xw, nil
+	case JSON:
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+		return &jsonWriter{w: w, columns: columns}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// formulaStarts are the first characters that make a spreadsheet evaluate a
+// cell as a formula.
+const formulaStarts = "=+-@\t\r"
+
+// defuse prefixes s with ' when a spreadsheet would read it as a formula,
+// so exported names and notes open as text. Values already so prefixed get
+// one more, so that restore gives them back unchanged.
+func defuse(s string) string {
+	if isFormula(strings.TrimLeft(s, "'")) {
+		return "'" + s
+	}
+	return s
+}
+
+// restore undoes defuse for a cell read back in.
+func restore(s string) string {
+	if strings.HasPrefix(s, "'") && isFormula(strings.TrimLeft(s, "'")) {
+		return s[1:]
+	}
+	return s
+}
+
+func isFormula(s string) bool {
+	return s != "" && strings.ContainsRune(formulaStarts, rune(s[0]))
+}
+
+type csvWriter struct{ w *csv.Writer }
+
+func (cw *csvWriter) Write(values []any) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case time.Time:
+			record[i] = v.Format(time.RFC3339)
+		case string:
+			record[i] = defuse(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type xlsxWriter struct {
+	out  io.Writer
+	f    *excelize.File
+	sw   *excelize.StreamWriter
+	rows int
+}
+
+func (xw *xlsxWriter) Write(values []any) error {
+	xw.rows++
+	cell, err := excelize.CoordinatesToCellName(1, xw.rows)
+	if err != nil {
+		return err
+	}
+	row := make([]any, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			v = defuse(s)
+		}
+		row[i] = v
+	}
+	return xw.sw.SetRow(cell, row)
+}
+
+// Close assembles the workbook; excelize spills large sheets to a temp
+// file, so rows are not all held in memory until this point.
+func (xw *xlsxWriter) Close() error {
+	defer xw.f.Close()
+	if err := xw.sw.Flush(); err != nil {
+		return err
+	}
+	return xw.f.Write(xw.out)
+}
+
+type jsonWriter struct {
+	w       io.Writer
+	columns []string
+	rows    int
+}
+
+// Write emits one object with keys in column order.
+func (jw *jsonWriter) Write(values []any) error {
+	sep := ","
+	if jw.rows == 0 {
+		sep = ""
+	}
+	jw.rows++
+	if _, err := io.WriteString(jw.w, sep+"{"); err != nil {
+		return err
+	}
+	for i, v := range values {
+		key, _ := json.Marshal(jw.columns[i])
+		val, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			key = append([]byte(","), key...)
+		}
+		if _, err := fmt.Fprintf(jw.w, "%s:%s", key, val); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(jw.w, "}")
+	return err
+}
+
+func (jw *jsonWriter) Close() error {
+	_, err := io.WriteString(jw.w, "]")
+	return err
+}
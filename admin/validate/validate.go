@@ -0,0 +1,170 @@
+// Package validate collects field-level input errors so handlers can report
+// every problem with a request at once instead of stopping at the first.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError is one problem with one input field. Field uses the JSON name
+// the client sent, e.g. "legalName".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is a non-empty list of field errors.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+var (
+	uuidRe     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	initialsRe = regexp.MustCompile(`^[A-Za-z]{1,4}$`)
+	usernameRe = regexp.MustCompile(`^[A-Za-z0-9._-]{3,50}$`)
+	zipRe      = regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`)
+	einRe      = regexp.MustCompile(`^[0-9]{2}-?[0-9]{7}$`)
+)
+
+// states are the USPS codes for the states, DC and the inhabited territories.
+var states = strings.Fields(`
+	AL AK AZ AR CA CO CT DE FL GA HI ID IL IN IA KS KY LA ME MD
+	MA MI MN MS MO MT NE NV NH NJ NM NY NC ND OH OK OR PA RI SC
+	SD TN TX UT VT VA WA WV WI WY DC PR GU VI AS MP`)
+
+// Validator accumulates field errors. The zero value is ready to use.
+// Each check is skipped for a field that already has an error, so a missing
+// value reports "is required" rather than a cascade of format errors.
+type Validator struct {
+	errs Errors
+}
+
+// Add records a custom error for field.
+func (v *Validator) Add(field, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: message})
+}
+
+// Check records message for field when ok is false.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok && !v.Has(field) {
+		v.Add(field, message)
+	}
+}
+
+// Has reports whether field already has an error.
+func (v *Validator) Has(field string) bool {
+	for _, fe := range v.errs {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the collected Errors, or nil when every check passed.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Errors returns the collected errors, possibly empty.
+func (v *Validator) Errors() Errors { return v.errs }
+
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+func (v *Validator) MaxLen(field, value string, max int) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+func (v *Validator) MinLen(field, value string, min int) {
+	v.Check(utf8.RuneCountInString(value) >= min, field, fmt.Sprintf("must be at least %d characters", min))
+}
+
+// Email accepts a bare address such as jane@example.com, not "Jane <jane@example.com>".
+func (v *Validator) Email(field, value string) {
+	addr, err := mail.ParseAddress(value)
+	ok := err == nil && addr.Address == value
+	if ok {
+		domain := value[strings.LastIndex(value, "@")+1:]
+		ok = strings.Contains(domain, ".")
+	}
+	v.Check(ok, field, "must be a valid email address")
+}
+
+func (v *Validator) UUID(field, value string) {
+	v.Check(uuidRe.MatchString(value), field, "must be a valid UUID")
+}
+
+// Initials accepts one to four letters.
+func (v *Validator) Initials(field, value string) {
+	v.Check(initialsRe.MatchString(value), field, "must be 1 to 4 letters")
+}
+
+// Username accepts 3 to 50 letters, digits, dots, dashes and underscores.
+func (v *Validator) Username(field, value string) {
+	v.Check(usernameRe.MatchString(value), field, "must be 3 to 50 letters, digits, '.', '-' or '_'")
+}
+
+// State accepts a two-letter USPS state or territory code such as "FL".
+func (v *Validator) State(field, value string) {
+	for _, s := range states {
+		if value == s {
+			return
+		}
+	}
+	v.Check(false, field, "must be a two-letter US state code")
+}
+
+// ZIP accepts a five-digit or ZIP+4 code.
+func (v *Validator) ZIP(field, value string) {
+	v.Check(zipRe.MatchString(value), field, "must be a ZIP code like 12345 or 12345-6789")
+}
+
+// EIN accepts a federal employer identification number, with or without the
+// dash: 12-3456789 or 123456789.
+func (v *Validator) EIN(field, value string) {
+	v.Check(einRe.MatchString(value), field, "must be an EIN like 12-3456789")
+}
+
+// Date accepts a calendar date in YYYY-MM-DD form.
+func (v *Validator) Date(field, value string) {
+	_, err := time.Parse(time.DateOnly, value)
+	v.Check(err == nil, field, "must be a date like 2006-01-02")
+}
+
+// MonthDay accepts a day of the year in MM-DD form, e.g. 12-31.
+func (v *Validator) MonthDay(field, value string) {
+	_, err := time.Parse("01-02", value)
+	v.Check(err == nil && len(value) == 5, field, "must be a month and day like 12-31")
+}
+
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Check(false, field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// ID returns Errors for field unless value is a UUID; handy for route params.
+func ID(field, value string) error {
+	var v Validator
+	v.UUID(field, value)
+	return v.Err()
+}
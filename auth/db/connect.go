@@ -26,5 +26,7 @@ func InitDB() {
 
 	DB = db
 	DB.AutoMigrate(&models.User{})
+	// Username uniqueness is a partial index over live rows (see admin service).
+	DB.Exec("DROP INDEX IF EXISTS idx_users_username")
 	fmt.Println("✅ Connected to PostgreSQL with GORM")
 }
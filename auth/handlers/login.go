@@ -10,6 +10,7 @@ import (
 
 	"auth/db"
 	"auth/models"
+	"auth/problem"
 )
 
 var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
@@ -22,18 +23,18 @@ type LoginInput struct {
 func Login(c *fiber.Ctx) error {
 	var input LoginInput
 	if err := c.BodyParser(&input); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return problem.New(fiber.StatusBadRequest, "Invalid input")
 	}
 
 	user := models.User{}
 	err := db.DB.Where("username = ?", input.Username).First(&user).Error
 
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
+		return problem.New(fiber.StatusUnauthorized, "User not found")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid password"})
+		return problem.New(fiber.StatusUnauthorized, "Invalid password")
 	}
 
 	// Create token
@@ -45,7 +46,7 @@ func Login(c *fiber.Ctx) error {
 
 	signedToken, err := token.SignedString(jwtSecret)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create token"})
+		return problem.New(fiber.StatusInternalServerError, "Could not create token")
 	}
 	
 	// Set secure HTTP-only cookie
@@ -78,7 +79,7 @@ func Logout(c *fiber.Ctx) error {
 func Me(c *fiber.Ctx) error {
     userToken := c.Cookies("token")
     if userToken == "" {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "No token"})
+        return problem.New(fiber.StatusUnauthorized, "No token")
     }
 
     claims := jwt.MapClaims{}
@@ -87,7 +88,7 @@ func Me(c *fiber.Ctx) error {
     })
 
     if err != nil || !token.Valid {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+        return problem.New(fiber.StatusUnauthorized, "Invalid token")
     }
 
     return c.JSON(fiber.Map{
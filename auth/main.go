@@ -1,29 +1,38 @@
 package main
 
 import (
-	"auth/handlers"
-	"auth/db"
-	"github.com/gofiber/fiber/v2/middleware/cors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
 
-	"github.com/gofiber/fiber/v2"
+	"auth/db"
+	"auth/openapi"
+	"auth/server"
 )
 
 func main() {
+    checkOnly := flag.Bool("openapi-check", false, "verify routes match openapi/openapi.yaml and exit")
+    flag.Parse()
+
+    spec, err := openapi.Load()
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    app := server.New(spec, os.Getenv("OPENAPI_ENFORCE") != "")
+
+    if err := spec.CheckRoutes(app); err != nil {
+        log.Fatal(err)
+    }
+    if *checkOnly {
+        fmt.Println("✅ Routes match openapi.yaml")
+        return
+    }
+
 	db.InitDB()
 	db.SeedSuperUser(db.DB)
 	db.SeedUsers(db.DB)
-    app := fiber.New()
-    // ✅ Allow all origins for dev
-    app.Use(cors.New(cors.Config{
-        AllowOrigins: "http://localhost:5173",
-        AllowHeaders: "Origin, Content-Type, Accept",
-        AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowCredentials: true,
-    }))
-
-    app.Post("/api/auth/login", handlers.Login)
-    app.Get("/api/auth/me", handlers.Me)
-    app.Post("/api/auth/logout", handlers.Logout)
 
     app.Listen(":8080")
 }
@@ -1,9 +1,15 @@
 package models
 
+import "gorm.io/gorm"
+
 type User struct {
-	ID       string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	Username string `gorm:"uniqueIndex;not null"`
-	Password string `gorm:"not null"`
-	Role     string `gorm:"default:user"`
+	ID string `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	// Unique among live rows only, so a trashed user's name can be reused.
+	Username  string         `gorm:"uniqueIndex:idx_users_username_live,where:deleted_at IS NULL;not null"`
+	Password  string         `gorm:"not null"`
+	Role      string         `gorm:"default:user"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	DeletedBy string
+	// Version is bumped on every write and served as the ETag.
+	Version int `gorm:"not null;default:1"`
 }
-
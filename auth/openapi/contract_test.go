@@ -0,0 +1,107 @@
+package openapi_test
+
+import (
+	"auth/db"
+	"auth/openapi"
+	"auth/server"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// contractCase is one request and the status it must get. Every response is
+// also checked against openapi.yaml by Enforce, which turns a mismatch into
+// a 500.
+type contractCase struct {
+	name    string
+	method  string
+	path    string
+	body    string
+	headers map[string]string
+	status  int
+}
+
+func run(t *testing.T, cases []contractCase) {
+	t.Helper()
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := server.New(spec, true)
+	if err := spec.CheckRoutes(app); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tc.status, body)
+			}
+		})
+	}
+}
+
+// token signs a session for username with role, as Login does.
+func token(t *testing.T, username, role string) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"username": username, "role": role, "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestContract(t *testing.T) {
+	session := map[string]string{"Cookie": "token=" + token(t, "alice", "admin")}
+	run(t, []contractCase{
+		{name: "spec", method: http.MethodGet, path: "/openapi.json", status: 200},
+		{name: "docs", method: http.MethodGet, path: "/docs", status: 200},
+		{name: "docs script", method: http.MethodGet, path: "/docs/try.js", status: 200},
+		{name: "me", method: http.MethodGet, path: "/api/auth/me", headers: session, status: 200},
+		{name: "me without a token", method: http.MethodGet, path: "/api/auth/me", status: 401},
+		{name: "me with a bad token", method: http.MethodGet, path: "/api/auth/me",
+			headers: map[string]string{"Cookie": "token=not-a-jwt"}, status: 401},
+		{name: "logout", method: http.MethodPost, path: "/api/auth/logout", status: 200},
+		{name: "logout with Idempotency-Key", method: http.MethodPost, path: "/api/auth/logout",
+			headers: map[string]string{"Idempotency-Key": "k1"}, status: 200},
+		{name: "login with bad JSON", method: http.MethodPost, path: "/api/auth/login", body: "{", status: 400},
+		{name: "login with Idempotency-Key", method: http.MethodPost, path: "/api/auth/login",
+			body: "{", headers: map[string]string{"Idempotency-Key": "k2"}, status: 400},
+	})
+}
+
+// TestContractDB covers the requests that reach the database; it runs when
+// DATABASE_URL names one.
+func TestContractDB(t *testing.T) {
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	db.InitDB()
+	run(t, []contractCase{
+		{name: "login as nobody", method: http.MethodPost, path: "/api/auth/login",
+			body: `{"username":"no-such-user-` + time.Now().Format("150405.000") + `","password":"x"}`, status: 401},
+		{name: "login as nobody with Idempotency-Key", method: http.MethodPost, path: "/api/auth/login",
+			body:    `{"username":"no-such-user-` + time.Now().Format("150405.000") + `","password":"x"}`,
+			headers: map[string]string{"Idempotency-Key": "k3"}, status: 401},
+	})
+}
@@ -0,0 +1,218 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// docsScript sends the page's try-it-out requests. It is served from this
+// service at docsScriptPath, so the page loads no third-party code.
+//
+//go:embed docs.js
+var docsScript []byte
+
+const docsScriptPath = "/docs/try.js"
+
+// docsCSP keeps the /docs page to itself, as it shares an origin with the
+// credentialed API: it runs only docsScript and sends requests only here.
+const docsCSP = "default-src 'none'; script-src 'self'; connect-src 'self'; style-src 'unsafe-inline'; " +
+	"base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body { font: 14px/1.5 system-ui, sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222 }
+section { border-top: 1px solid #ddd; padding: .5em 0 }
+code { font: 13px ui-monospace, monospace }
+.method { display: inline-block; width: 5em; font-weight: bold }
+table { border-collapse: collapse; margin: .3em 0 }
+td, th { border: 1px solid #ddd; padding: .2em .5em; text-align: left; vertical-align: top }
+form.try label { display: block; margin: .2em 0 }
+form.try textarea { display: block; width: 100%; font: 13px ui-monospace, monospace }
+form.try output { display: block; white-space: pre-wrap; font: 13px ui-monospace, monospace; background: #f6f6f6; margin-top: .5em }
+</style>
+<script src="{{.Script}}" defer></script>
+</head>
+<body>
+<h1>{{.Title}} <small>{{.Version}}</small></h1>
+<p>The machine-readable document is <a href="/openapi.json">/openapi.json</a>; load it into any OpenAPI viewer or client generator.
+Each operation can be tried out here; requests carry the session cookie of this browser.</p>
+{{range .Operations}}<section>
+<h3><span class="method">{{.Method}}</span><code>{{.Path}}</code></h3>
+{{if .Summary}}<p><b>{{.Summary}}</b></p>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Parameters}}<table><tr><th>Parameter</th><th>In</th><th>Description</th></tr>
+{{range .Parameters}}<tr><td><code>{{.Name}}</code>{{if .Required}} *{{end}}</td><td>{{.In}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Body}}<p>Body: <code>{{.Body}}</code></p>{{end}}
+<table><tr><th>Status</th><th>Response</th></tr>
+{{range .Responses}}<tr><td>{{.Status}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+<details><summary>Try it</summary>
+<form class="try" data-method="{{.Method}}" data-path="{{.Path}}">
+{{range .Parameters}}{{if ne .In "cookie"}}<label><code>{{.Name}}</code> ({{.In}}) <input name="{{.Name}}" data-in="{{.In}}"{{if .Required}} required{{end}}></label>
+{{end}}{{end}}{{if .Sample}}<label>Body <textarea name="body" rows="6">{{.Sample}}</textarea></label>
+{{end}}{{range .Fields}}<label><code>{{.Name}}</code> <input name="{{.Name}}" data-field{{if .File}} type="file"{{end}}{{if .Required}} required{{end}}></label>
+{{end}}<button>Send</button>
+<output></output>
+</form>
+</details>
+</section>
+{{end}}
+</body>
+</html>`))
+
+type docsParameter struct {
+	Name, In, Description string
+	Required              bool
+}
+
+// docsField is a part of a multipart body.
+type docsField struct {
+	Name           string
+	File, Required bool
+}
+
+type docsResponse struct{ Status, Description string }
+
+// docsOperation is one operation on the page. Sample starts the try-it-out
+// body of a JSON request and Fields are the parts of a multipart one.
+type docsOperation struct {
+	Method, Path, Summary, Description, Body, Sample string
+	Parameters                                       []docsParameter
+	Fields                                           []docsField
+	Responses                                        []docsResponse
+}
+
+// docsPage renders the reference served at /docs from the document itself,
+// so the page needs no third-party script.
+func (d *Document) docsPage() ([]byte, error) {
+	data := struct {
+		Title, Version, Script string
+		Operations             []docsOperation
+	}{Script: docsScriptPath}
+	data.Title, _ = lookup(d.root, "info", "title").(string)
+	data.Version, _ = lookup(d.root, "info", "version").(string)
+
+	paths, _ := d.root["paths"].(map[string]any)
+	for _, path := range sortedKeys(paths) {
+		for _, m := range methods {
+			op, ok := lookup(paths, path, strings.ToLower(m)).(map[string]any)
+			if !ok {
+				continue
+			}
+			o := docsOperation{Method: m, Path: path}
+			o.Summary, _ = op["summary"].(string)
+			o.Description, _ = op["description"].(string)
+			params, _ := op["parameters"].([]any)
+			for _, p := range params {
+				p = d.resolve(p)
+				var dp docsParameter
+				dp.Name, _ = lookup(p, "name").(string)
+				dp.In, _ = lookup(p, "in").(string)
+				dp.Description, _ = lookup(p, "description").(string)
+				dp.Required, _ = lookup(p, "required").(bool)
+				o.Parameters = append(o.Parameters, dp)
+			}
+			if content, ok := lookup(d.resolve(op["requestBody"]), "content").(map[string]any); ok {
+				for media, mt := range content {
+					ref, _ := lookup(mt, "schema", "$ref").(string)
+					o.Body = strings.TrimSpace(media + " " + ref[strings.LastIndex(ref, "/")+1:])
+					props, required := d.properties(lookup(mt, "schema"))
+					switch media {
+					case "application/json":
+						o.Sample = sampleBody(props, required)
+					case "multipart/form-data":
+						for _, name := range sortedKeys(props) {
+							_, file := lookup(props[name], "contentMediaType").(string)
+							o.Fields = append(o.Fields, docsField{name, file, required[name]})
+						}
+					}
+					break
+				}
+			}
+			responses, _ := op["responses"].(map[string]any)
+			for _, status := range sortedKeys(responses) {
+				desc, _ := lookup(d.resolve(responses[status]), "description").(string)
+				o.Responses = append(o.Responses, docsResponse{status, desc})
+			}
+			data.Operations = append(data.Operations, o)
+		}
+	}
+	var buf bytes.Buffer
+	if err := docsTemplate.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("openapi: docs page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// properties returns the top-level properties of an object schema, merging
+// allOf parts, and which of them are required.
+func (d *Document) properties(schema any) (map[string]any, map[string]bool) {
+	props, required := map[string]any{}, map[string]bool{}
+	s, _ := d.resolve(schema).(map[string]any)
+	parts, _ := s["allOf"].([]any)
+	for _, part := range append([]any{s}, parts...) {
+		part = d.resolve(part)
+		own, _ := lookup(part, "properties").(map[string]any)
+		for name, p := range own {
+			props[name] = d.resolve(p)
+		}
+		names, _ := lookup(part, "required").([]any)
+		for _, name := range names {
+			if n, ok := name.(string); ok {
+				required[n] = true
+			}
+		}
+	}
+	return props, required
+}
+
+// sampleBody is a JSON object with the required properties, each set to a
+// placeholder of its type, for the caller to fill in. Optional properties
+// are left out, as sending one may change or clear it.
+func sampleBody(props map[string]any, required map[string]bool) string {
+	sample := map[string]any{}
+	for name, p := range props {
+		if !required[name] {
+			continue
+		}
+		if values, ok := lookup(p, "enum").([]any); ok && len(values) > 0 {
+			sample[name] = values[0]
+			continue
+		}
+		typ, _ := lookup(p, "type").(string)
+		if types, ok := lookup(p, "type").([]any); ok && len(types) > 0 {
+			typ, _ = types[0].(string)
+		}
+		switch typ {
+		case "integer", "number":
+			sample[name] = 0
+		case "boolean":
+			sample[name] = false
+		case "array":
+			sample[name] = []any{}
+		case "object":
+			sample[name] = map[string]any{}
+		default:
+			sample[name] = ""
+		}
+	}
+	b, _ := json.MarshalIndent(sample, "", "  ")
+	return string(b)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
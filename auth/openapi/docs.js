@@ -0,0 +1,90 @@
+// Try it out for /docs: each operation's form sends its request from this
+// origin, with the session cookie, and shows the response below it.
+"use strict";
+
+document.addEventListener("submit", async (event) => {
+  const form = event.target.closest("form.try");
+  if (!form) {
+    return;
+  }
+  event.preventDefault();
+  const out = form.querySelector("output");
+
+  let path = form.dataset.path;
+  const query = new URLSearchParams();
+  const headers = new Headers();
+  for (const input of form.querySelectorAll("input[data-in]")) {
+    const value = input.value.trim();
+    if (value === "") {
+      continue;
+    }
+    switch (input.dataset.in) {
+      case "path":
+        path = path.replace("{" + input.name + "}", encodeURIComponent(value));
+        break;
+      case "query":
+        query.append(input.name, value);
+        break;
+      case "header":
+        headers.set(input.name, value);
+        break;
+    }
+  }
+
+  let body;
+  const json = form.querySelector("textarea[name=body]");
+  if (json && json.value.trim() !== "") {
+    headers.set("Content-Type", "application/json");
+    body = json.value;
+  }
+  const fields = form.querySelectorAll("input[data-field]");
+  if (fields.length > 0) {
+    body = new FormData();
+    for (const field of fields) {
+      if (field.type === "file") {
+        if (field.files.length > 0) {
+          body.append(field.name, field.files[0]);
+        }
+      } else if (field.value !== "") {
+        body.append(field.name, field.value);
+      }
+    }
+  }
+
+  const search = query.toString();
+  out.textContent = "Sending…";
+  try {
+    const resp = await fetch(path + (search ? "?" + search : ""), {
+      method: form.dataset.method,
+      headers,
+      body,
+      credentials: "same-origin",
+    });
+    const lines = [resp.status + " " + resp.statusText];
+    resp.headers.forEach((value, name) => lines.push(name + ": " + value));
+    const type = resp.headers.get("Content-Type") || "";
+    let text;
+    if (type.startsWith("text/event-stream")) {
+      // The stream never ends; show that it opened and let it go.
+      resp.body.cancel();
+      text = "(event stream; read it with EventSource)";
+    } else if (/json|text\//.test(type)) {
+      text = await resp.text();
+      try {
+        text = JSON.stringify(JSON.parse(text), null, 2);
+      } catch {
+        // Not JSON; show it as it came.
+      }
+    } else {
+      const link = document.createElement("a");
+      link.href = URL.createObjectURL(await resp.blob());
+      link.download = "";
+      link.textContent = "Download the response";
+      out.replaceChildren(lines.join("\n") + "\n\n", link);
+      return;
+    }
+    out.textContent = lines.join("\n") + "\n\n" + text;
+  } catch (err) {
+    out.textContent = String(err);
+  }
+});
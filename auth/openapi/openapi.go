@@ -0,0 +1,322 @@
+// Package openapi serves the service's OpenAPI document and checks the running
+// app against it: CheckRoutes compares registered routes with the documented
+// paths, and Enforce validates live responses against the documented schemas.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"auth/problem"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+// methods are the operation keys of an OpenAPI path item that fiber routes map to.
+var methods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// Document is the parsed specification.
+type Document struct {
+	root map[string]any
+	json []byte
+}
+
+// Load parses the embedded specification.
+func Load() (*Document, error) {
+	var root map[string]any
+	if err := yaml.Unmarshal(specYAML, &root); err != nil {
+		return nil, fmt.Errorf("openapi.yaml: %w", err)
+	}
+	js, err := json.Marshal(root)
+	if err != nil {
+		return nil, fmt.Errorf("openapi.yaml: %w", err)
+	}
+	return &Document{root: root, json: js}, nil
+}
+
+// Register serves the document at /openapi.json and an interactive
+// reference rendered from it at /docs.
+func (d *Document) Register(app *fiber.App) {
+	app.Get("/openapi.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(d.json)
+	})
+	page, err := d.docsPage()
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Get("/docs", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		c.Set(fiber.HeaderContentSecurityPolicy, docsCSP)
+		return c.Send(page)
+	})
+	app.Get(docsScriptPath, func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextJavaScriptCharsetUTF8)
+		return c.Send(docsScript)
+	})
+}
+
+var paramRe = regexp.MustCompile(`:(\w+)`)
+
+// templatePath turns a fiber route path (/users/:id) into an OpenAPI
+// path template (/users/{id}).
+func templatePath(route string) string {
+	return paramRe.ReplaceAllString(route, "{$1}")
+}
+
+// CheckRoutes reports every registered route missing from the document and
+// every documented operation with no route behind it.
+func (d *Document) CheckRoutes(app *fiber.App) error {
+	registered := map[string]bool{}
+	for _, r := range app.GetRoutes(true) {
+		registered[r.Method+" "+templatePath(r.Path)] = true
+	}
+	documented := map[string]bool{}
+	paths, _ := d.root["paths"].(map[string]any)
+	for path, item := range paths {
+		ops, _ := item.(map[string]any)
+		for _, m := range methods {
+			if _, ok := ops[strings.ToLower(m)]; ok {
+				documented[m+" "+path] = true
+			}
+		}
+	}
+
+	var problems []string
+	for op := range registered {
+		method := op[:strings.IndexByte(op, ' ')]
+		if !documented[op] && contains(methods, method) {
+			problems = append(problems, "undocumented route "+op)
+		}
+	}
+	for op := range documented {
+		if !registered[op] {
+			problems = append(problems, "documented operation has no route: "+op)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("openapi.yaml is out of date:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// Enforce returns middleware that checks each response against the
+// documented operation: the status must be listed (or fall under "default"
+// for errors), the content type must be one the status documents, and JSON
+// bodies must match the schema. A mismatch is logged and the response is
+// replaced with a 500 so contract drift fails loudly in development and
+// end-to-end runs. Streamed bodies (exports) are checked by headers only.
+func (d *Document) Enforce() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			if herr := c.App().ErrorHandler(c, err); herr != nil {
+				return herr
+			}
+		}
+		if err := d.checkResponse(c); err != nil {
+			log.Printf("openapi: %s %s: %v", c.Method(), c.Path(), err)
+			c.Response().ResetBody()
+			return problem.New(fiber.StatusInternalServerError, "Response does not match the API specification")
+		}
+		return nil
+	}
+}
+
+func (d *Document) checkResponse(c *fiber.Ctx) error {
+	op, ok := lookup(d.root, "paths", templatePath(c.Route().Path), strings.ToLower(c.Method())).(map[string]any)
+	if !ok {
+		return nil // unrouted request (404/405); CheckRoutes covers real routes
+	}
+	status := c.Response().StatusCode()
+	resp := lookup(op, "responses", strconv.Itoa(status))
+	if resp == nil && status >= 400 {
+		resp = lookup(op, "responses", "default")
+	}
+	if resp == nil {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+	resp = d.resolve(resp)
+
+	content, _ := lookup(resp, "content").(map[string]any)
+	if len(content) == 0 {
+		return nil
+	}
+	ctype, _, _ := mime.ParseMediaType(string(c.Response().Header.ContentType()))
+	media, ok := content[ctype]
+	if !ok {
+		return fmt.Errorf("content type %q is not documented for status %d", ctype, status)
+	}
+	if c.Response().IsBodyStream() || !strings.HasSuffix(ctype, "json") {
+		return nil
+	}
+	var body any
+	if err := json.Unmarshal(c.Response().Body(), &body); err != nil {
+		return fmt.Errorf("body is not JSON: %w", err)
+	}
+	if schema := lookup(media, "schema"); schema != nil {
+		return d.validate(schema, body, "body")
+	}
+	return nil
+}
+
+// lookup walks nested maps by key, returning nil when any step is missing.
+func lookup(node any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = m[k]
+	}
+	return node
+}
+
+// resolve follows a local $ref ("#/components/...") if node is one.
+func (d *Document) resolve(node any) any {
+	for {
+		ref, ok := lookup(node, "$ref").(string)
+		if !ok {
+			return node
+		}
+		node = lookup(d.root, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+	}
+}
+
+// validate checks value against the subset of JSON Schema the document
+// uses: type, enum, properties, required, items, additionalProperties,
+// allOf and oneOf. Formats and patterns are documentation only.
+func (d *Document) validate(schema, value any, at string) error {
+	s, _ := d.resolve(schema).(map[string]any)
+	if s == nil {
+		return nil
+	}
+
+	for _, sub := range list(s["allOf"]) {
+		if err := d.validate(sub, value, at); err != nil {
+			return err
+		}
+	}
+	if options := list(s["oneOf"]); len(options) > 0 {
+		matched := false
+		for _, sub := range options {
+			if d.validate(sub, value, at) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s matches none of the oneOf schemas", at)
+		}
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, x := range t {
+				if x, ok := x.(string); ok {
+					types = append(types, x)
+				}
+			}
+		}
+		if !contains(types, jsonType(value)) && !(jsonType(value) == "integer" && contains(types, "number")) {
+			return fmt.Errorf("%s is %s, want %s", at, jsonType(value), strings.Join(types, " or "))
+		}
+	}
+	if enum := list(s["enum"]); len(enum) > 0 {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, r := range list(s["required"]) {
+			if name, _ := r.(string); name != "" {
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s is missing required %q", at, name)
+				}
+			}
+		}
+		props, _ := s["properties"].(map[string]any)
+		for name, val := range v {
+			sub, ok := props[name]
+			if !ok {
+				sub = s["additionalProperties"]
+			}
+			if sub == nil {
+				continue
+			}
+			if _, isBool := sub.(bool); isBool {
+				continue
+			}
+			if err := d.validate(sub, val, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items := s["items"]; items != nil {
+			for i, x := range v {
+				if err := d.validate(items, x, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func list(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+// Package server assembles the auth service's fiber app: its middleware
+// and every route.
+package server
+
+import (
+	"auth/handlers"
+	"auth/openapi"
+	"auth/problem"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// New returns the app serving spec and every route. With enforce, each
+// response is checked against spec; see openapi.Document.Enforce.
+func New(spec *openapi.Document, enforce bool) *fiber.App {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.Handler,
+	})
+	app.Use(requestid.New())
+	if enforce {
+		app.Use(spec.Enforce())
+	}
+	// ✅ Allow all origins for dev
+	app.Use(cors.New(cors.Config{
+		AllowOrigins: "http://localhost:5173",
+		// Idempotency-Key is allowed so clients can send it on every POST;
+		// auth ignores it (see openapi.yaml).
+		AllowHeaders:     "Origin, Content-Type, Accept, Idempotency-Key",
+		ExposeHeaders:    "X-Request-ID",
+		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowCredentials: true,
+	}))
+
+	app.Post("/api/auth/login", handlers.Login)
+	app.Get("/api/auth/me", handlers.Me)
+	app.Post("/api/auth/logout", handlers.Logout)
+	spec.Register(app)
+
+	return app
+}
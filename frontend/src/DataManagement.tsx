@@ -11,7 +11,7 @@ async function jsonOrText(res: Response) {
   const ct = res.headers.get("content-type") || "";
   if (res.status === 204) return null;
   const txt = await res.text();
-  if (ct.includes("application/json")) {
+  if (ct.includes("json")) {
     try { return JSON.parse(txt); } catch {}
   }
   return { _raw: txt };
@@ -24,6 +24,14 @@ const req = (url: string, init?: RequestInit) =>
     ...init,
   });
 
+// Headers for a PUT: the row's version as If-Match, so the server rejects
+// (412) an update to a row someone else changed since we loaded it. Never
+// "*": a row whose version is unknown is not editable (see startEdit).
+const putHeaders = (version: number) => ({
+  "Content-Type": "application/json",
+  "If-Match": `"${version}"`,
+});
+
 /* -------------------- types -------------------- */
 type Manager = {
   id: string;
@@ -31,6 +39,7 @@ type Manager = {
   email: string;
   titles: string;
   initials: string;
+  version?: number;     // ETag for If-Match
   _isNew?: boolean;     // staged
   _deleted?: boolean;   // staged
 };
@@ -42,6 +51,7 @@ type Association = {
   location: string;
   managerId: string;
   managerName?: string; // embedded from backend or derived from managers
+  version?: number;
   _isNew?: boolean;
   _deleted?: boolean;
 };
@@ -56,6 +66,7 @@ function normalizeManager(m: any): Manager {
     email: s(m.email ?? m.Email),
     titles: s(m.titles ?? m.Titles),
     initials: s(m.initials ?? m.Initials),
+    version: m.version ?? m.Version,
   };
 }
 
@@ -72,6 +83,7 @@ function normalizeAssociation(a: any, managersById?: Map<string, Manager>): Asso
     location: s(a.location ?? a.Location),
     managerId,
     managerName,
+    version: a.version ?? a.Version,
   };
 }
 
@@ -244,6 +256,12 @@ export default function DataManagement() {
 
   /* editing logic */
   function startEdit(t: TableChoice, id: string, field: any, value: string) {
+    // Stored rows are only editable once their version is known, so the
+    // update cannot overwrite a change we never saw.
+    const row = t === "associations"
+      ? associations.find((a) => a.id === id)
+      : managers.find((m) => m.id === id);
+    if (row && !row._isNew && !row.version) return;
     setEdit({ table: t, id, field, value } as EditCell);
   }
   function cancelEdit() { setEdit(null); }
@@ -434,12 +452,15 @@ export default function DataManagement() {
 
     for (const id of updateMgrIds) {
       const row = managers.find((m) => m.id === id)!;
+      if (!row.version) throw new Error(`Manager "${row.name}" has no known version; reload and reapply`);
       const res = await req(`${API.managers}/${id}`, {
         method: "PUT",
+        headers: putHeaders(row.version),
         body: JSON.stringify({
           name: row.name, email: row.email, titles: row.titles, initials: row.initials,
         }),
       });
+      if (res.status === 412) throw new Error(`Manager "${row.name}" was changed by someone else; reload and reapply`);
       if (!res.ok) throw new Error("Failed to update manager");
     }
 
@@ -451,8 +472,10 @@ export default function DataManagement() {
 
     for (const id of updateAssocIds) {
       const row = associations.find((a) => a.id === id)!;
+      if (!row.version) throw new Error(`Association "${row.legalName}" has no known version; reload and reapply`);
       const res = await req(`${API.associations}/${id}`, {
         method: "PUT",
+        headers: putHeaders(row.version),
         body: JSON.stringify({
           legalName: row.legalName,
           filterName: row.filterName,
@@ -460,6 +483,7 @@ export default function DataManagement() {
           managerId: row.managerId,
         }),
       });
+      if (res.status === 412) throw new Error(`Association "${row.legalName}" was changed by someone else; reload and reapply`);
       if (!res.ok) throw new Error("Failed to update association");
     }
 
@@ -21,7 +21,7 @@ export default function Login({ onLogin }: { onLogin: (username: string, role: s
 
       if (!res.ok) {
         const err = await res.json();
-        throw new Error(err.error || "Login failed");
+        throw new Error(err.detail || err.error || "Login failed");
       }
 
       // Optionally fetch user info after login
@@ -6,6 +6,7 @@ type User = {
   ID: string;
   Username: string;
   Role: Role;
+  Version: number;
 };
 
 const ADMIN_API = "http://localhost:8082";
@@ -26,7 +27,7 @@ export default function UserManagement() {
       });
       if (!res.ok) {
         const err = await res.json().catch(() => ({}));
-        throw new Error(err.error || `Failed to load users (${res.status})`);
+        throw new Error(err.detail || err.error || `Failed to load users (${res.status})`);
       }
       const data = await res.json();
       setUsers(data);
@@ -70,7 +71,7 @@ export default function UserManagement() {
       });
       if (!res.ok) {
         const err = await res.json().catch(() => ({}));
-        throw new Error(err.error || `Failed to create user (${res.status})`);
+        throw new Error(err.detail || err.error || `Failed to create user (${res.status})`);
       }
       form.reset();
       await load();
@@ -97,7 +98,7 @@ export default function UserManagement() {
       });
       if (!res.ok) {
         const err = await res.json().catch(() => ({}));
-        throw new Error(err.error || `Failed to delete user (${res.status})`);
+        throw new Error(err.detail || err.error || `Failed to delete user (${res.status})`);
       }
       await load();
     } catch (e: any) {
@@ -121,15 +122,15 @@ export default function UserManagement() {
       const res = await fetch(`${ADMIN_API}/api/admin/users/${u.ID}/role`, {
         method: "PUT",
         credentials: "include",
-        headers: { "Content-Type": "application/json" },
+        headers: { "Content-Type": "application/json", "If-Match": `"${u.Version}"` },
         body: JSON.stringify({ role: u.Role }),
       });
       if (!res.ok) {
         const err = await res.json().catch(() => ({}));
-        throw new Error(err.error || `Failed to update role (${res.status})`);
+        throw new Error(err.detail || err.error || `Failed to update role (${res.status})`);
       }
-      // Optionally reload to ensure canonical view
-      // await load();
+      // Pick up the new version so a second save is not refused.
+      await load();
     } catch (e: any) {
       setError(e?.message || "Failed to update role");
       await load(); // revert optimistic change on error